@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeBound is a -modified-since / -modified-before value: either an age
+// like "30d" relative to now or an absolute date.
+type timeBound struct {
+	t time.Time
+}
+
+func (b *timeBound) String() string {
+	if b.t.IsZero() {
+		return ""
+	}
+	return b.t.Format(time.RFC3339)
+}
+
+func (b *timeBound) Set(v string) error {
+	t, err := parseTimeBound(v, time.Now())
+	if err != nil {
+		return err
+	}
+	b.t = t
+	return nil
+}
+
+// parseTimeBound accepts ages in h, d or w ("12h", "30d", "2w") and dates
+// as 2006-01-02 or RFC 3339.
+func parseTimeBound(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	units := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}
+	if n := len(v); n > 1 {
+		if unit, ok := units[v[n-1]]; ok {
+			if count, err := strconv.Atoi(v[:n-1]); err == nil && count >= 0 {
+				return now.Add(-time.Duration(count) * unit), nil
+			}
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use e.g. 30d, 12h, 2w or 2023-01-01)", v)
+}
+
+// newestModTime returns the latest file mtime below dir, or the directory's
+// own mtime when it holds no files.
+func newestModTime(dir string) time.Time {
+	var newest time.Time
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" || path != dir && isJunk(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isJunk(info.Name()) {
+			return nil
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if newest.IsZero() {
+		if info, err := os.Stat(dir); err == nil {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// filterJobsByAge is filterByAge for planned jobs.
+func filterJobsByAge(jobs []DirJob, since, before time.Time) []DirJob {
+	if since.IsZero() && before.IsZero() {
+		return jobs
+	}
+	paths := make([]string, len(jobs))
+	for i, j := range jobs {
+		paths[i] = j.Path
+	}
+	keep := map[string]bool{}
+	for _, p := range filterByAge(paths, since, before) {
+		keep[p] = true
+	}
+	var kept []DirJob
+	for _, j := range jobs {
+		if keep[j.Path] {
+			kept = append(kept, j)
+		}
+	}
+	return kept
+}
+
+// filterByAge keeps directories whose newest file falls within the bounds.
+// Zero bounds are ignored.
+func filterByAge(dirs []string, since, before time.Time) []string {
+	if since.IsZero() && before.IsZero() {
+		return dirs
+	}
+	var kept []string
+	for _, dir := range dirs {
+		newest := newestModTime(dir)
+		if !since.IsZero() && newest.Before(since) {
+			continue
+		}
+		if !before.IsZero() && !newest.Before(before) {
+			continue
+		}
+		kept = append(kept, dir)
+	}
+	return kept
+}
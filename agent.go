@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A coordinator started with -queue also hands out work. Agents, push runs
+// started with -agent <url> on the machines that hold the data, pull one
+// directory at a time whenever a worker is free, push it as usual and
+// report the result, so fast machines take more of the queue. Queue lines
+// use the -f format and name each directory where its machine sees it; an
+// agent that doesn't have a directory hands it back for the others. A job
+// whose agent stops renewing its lease is given to the next agent that
+// asks. An agent exits when the queue has nothing more for it.
+//
+//	POST /agent/next     {holder, host, lease_seconds} → {id, line}, or 204
+//	POST /agent/result   {holder, host, id, status, message, url}
+//	POST /agent/enqueue  -f lines in the body
+//	GET  /agent/queue    every job and its state
+//
+// Agents renew their jobs' leases with /renew, like claims.
+var agentURL string
+
+// agentRequest is the body of /agent/next and /agent/result
+type agentRequest struct {
+	Holder       string `json:"holder"`
+	Host         string `json:"host"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Status       string `json:"status,omitempty"` // success, failed, skipped or missing
+	Message      string `json:"message,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+// queuedJob is a coordinator queue entry
+type queuedJob struct {
+	ID      string          `json:"id"`
+	Line    string          `json:"line"`
+	State   string          `json:"state"` // queued, running, success, failed or skipped
+	Agent   string          `json:"agent,omitempty"`
+	Host    string          `json:"host,omitempty"`
+	Expires time.Time       `json:"-"`
+	Message string          `json:"message,omitempty"`
+	URL     string          `json:"url,omitempty"`
+	Missing map[string]bool `json:"missing_on,omitempty"` // Hosts without the directory
+}
+
+// jobQueue is the coordinator's queue
+type jobQueue struct {
+	mu     sync.Mutex
+	jobs   []*queuedJob
+	byID   map[string]*queuedJob
+	nextID int
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{byID: map[string]*queuedJob{}}
+}
+
+// add queues every directory line of r, skipping blanks and comments.
+func (q *jobQueue) add(r io.Reader) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	added := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		q.nextID++
+		job := &queuedJob{ID: fmt.Sprint(q.nextID), Line: line, State: "queued", Missing: map[string]bool{}}
+		q.jobs = append(q.jobs, job)
+		q.byID[job.ID] = job
+		added++
+	}
+	return added, scanner.Err()
+}
+
+// next hands the first available job to an agent: a queued one, or one
+// whose agent's lease ran out, that isn't missing on the agent's host.
+func (q *jobQueue) next(req agentRequest, now time.Time) *queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		available := job.State == "queued" || job.State == "running" && now.After(job.Expires)
+		if !available || job.Missing[req.Host] {
+			continue
+		}
+		job.State, job.Agent, job.Host = "running", req.Holder, req.Host
+		job.Expires = now.Add(time.Duration(req.LeaseSeconds) * time.Second)
+		copied := *job
+		return &copied
+	}
+	return nil
+}
+
+func (q *jobQueue) renew(holder string, ids []string, lease time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range ids {
+		if job := q.byID[id]; job != nil && job.State == "running" && job.Agent == holder {
+			job.Expires = time.Now().Add(lease)
+		}
+	}
+}
+
+func (q *jobQueue) finish(req agentRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.byID[req.ID]
+	if job == nil || job.Agent != req.Holder {
+		return
+	}
+	if req.Status == "missing" {
+		job.Missing[req.Host] = true
+		job.State, job.Agent, job.Host = "queued", "", ""
+		return
+	}
+	job.State, job.Message, job.URL = req.Status, req.Message, req.URL
+}
+
+func (q *jobQueue) register(mux *http.ServeMux, c *coordinator) {
+	mux.HandleFunc("/agent/next", c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		var req agentRequest
+		if !decodeAgentRequest(w, r, &req) {
+			return
+		}
+		job := q.next(req, time.Now())
+		if job == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}))
+	mux.HandleFunc("/agent/result", c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		var req agentRequest
+		if decodeAgentRequest(w, r, &req) {
+			q.finish(req)
+		}
+	}))
+	mux.HandleFunc("/agent/enqueue", c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := q.add(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "queued %d directories\n", n)
+	}))
+	mux.HandleFunc("/agent/queue", c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		q.mu.Lock()
+		list := make([]queuedJob, 0, len(q.jobs))
+		for _, job := range q.jobs {
+			list = append(list, *job)
+		}
+		q.mu.Unlock()
+		sort.SliceStable(list, func(i, j int) bool { return list[i].State < list[j].State })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}))
+}
+
+func decodeAgentRequest(w http.ResponseWriter, r *http.Request, req *agentRequest) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Holder == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// Agent side
+
+var agentJobs sync.Map // Path → queue ID
+
+// agentPost sends a request to the coordinator's agent API.
+func agentPost(endpoint string, body agentRequest) (*http.Response, error) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(agentURL, "/")+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(coordinatorEnvKey); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := coordClient.Do(req)
+	if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("coordinator: %s", resp.Status)
+	}
+	return resp, err
+}
+
+// feedAgentJobs pulls jobs into the worker channel until the queue has
+// nothing more for this agent. The channel is unbuffered, so at most one
+// job taken from the queue waits here for a free worker.
+func feedAgentJobs(jobs chan<- DirJob) {
+	defer close(jobs)
+	failures := 0
+	for abortReason() == "" {
+		resp, err := agentPost("/agent/next", agentRequest{Holder: runID, Host: hostname,
+			LeaseSeconds: int(coordinatorLease.Seconds())})
+		if err != nil {
+			if failures++; failures > 5 {
+				fmt.Printf("\n✗ Coordinator unreachable, stopping: %v\n", err)
+				return
+			}
+			time.Sleep(time.Duration(failures) * 2 * time.Second)
+			continue
+		}
+		failures = 0
+		if resp.StatusCode == http.StatusNoContent {
+			resp.Body.Close()
+			return
+		}
+		var queued queuedJob
+		err = json.NewDecoder(resp.Body).Decode(&queued)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Printf("\n✗ Coordinator sent a bad job: %v\n", err)
+			return
+		}
+
+		job, err := parsePathLine(queued.Line)
+		if err != nil {
+			reportAgentJob(queued.ID, "failed", err.Error(), "")
+			continue
+		}
+		if info, err := os.Stat(job.Path); err != nil || !info.IsDir() {
+			reportAgentJob(queued.ID, "missing", "not on "+hostname, "")
+			continue
+		}
+		agentJobs.Store(job.Path, queued.ID)
+		heldClaims.Store(agentLeaseKey(queued.ID), true)
+		atomic.AddInt64(&stats.Total, 1)
+		jobs <- job
+	}
+}
+
+// agentLeaseKey marks a queue lease among the claims renewClaims renews.
+func agentLeaseKey(id string) string {
+	return "agent:" + id
+}
+
+// reportAgentResult sends a finished job back to the coordinator.
+func reportAgentResult(result Result) {
+	v, ok := agentJobs.LoadAndDelete(result.Path)
+	if !ok {
+		return
+	}
+	status := "failed"
+	switch {
+	case result.Skipped:
+		status = "skipped"
+	case result.Success:
+		status = "success"
+	}
+	reportAgentJob(v.(string), status, result.Message, result.RepoURL)
+}
+
+func reportAgentJob(id, status, message, url string) {
+	heldClaims.Delete(agentLeaseKey(id))
+	resp, err := agentPost("/agent/result", agentRequest{Holder: runID, Host: hostname, ID: id,
+		Status: status, Message: message, URL: url})
+	if err != nil {
+		fmt.Printf("\n⚠ Could not report job %s to the coordinator: %v\n", id, err)
+		return
+	}
+	resp.Body.Close()
+}
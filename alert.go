@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Mid-run alerting: a webhook (Slack incoming-webhook compatible) fires when
+// the failure rate crosses a threshold or when a critical path fails.
+var (
+	webhookURL        string
+	alertFailureRate  float64
+	alertMinCompleted int64 = 10
+	criticalPaths     stringList
+	alertRateFired    int32
+	alertsWG          sync.WaitGroup
+)
+
+// checkAlerts is called by workers after each result.
+func checkAlerts(result Result) {
+	if webhookURL == "" || result.Success || result.Skipped {
+		return
+	}
+
+	if isCriticalPath(result.Path) {
+		sendAlert(fmt.Sprintf("gitmax: critical path failed: %s: %s", result.Path, result.Message))
+	}
+
+	if alertFailureRate <= 0 {
+		return
+	}
+	completed := atomic.LoadInt64(&stats.Completed)
+	failed := atomic.LoadInt64(&stats.Failed)
+	if completed < alertMinCompleted {
+		return
+	}
+	rate := float64(failed) / float64(completed)
+	if rate >= alertFailureRate && atomic.CompareAndSwapInt32(&alertRateFired, 0, 1) {
+		sendAlert(fmt.Sprintf("gitmax: failure rate %.0f%% (%d of %d) crossed %.0f%%; latest: %s: %s",
+			rate*100, failed, completed, alertFailureRate*100, result.Path, result.Message))
+	}
+}
+
+// isCriticalPath matches like -match and the config's directories: a
+// relative pattern, a bare name included, matches at any depth.
+func isCriticalPath(path string) bool {
+	for _, pattern := range criticalPaths {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendAlert posts in the background; waitAlerts flushes before exit.
+func sendAlert(text string) {
+	alertsWG.Add(1)
+	go func() {
+		defer alertsWG.Done()
+
+		// Slack reads only text; other receivers can correlate by run
+		payload, _ := json.Marshal(map[string]string{
+			"text":   redact(fmt.Sprintf("%s (run %s on %s)", text, runID, hostname)),
+			"run_id": runID,
+			"host":   hostname,
+		})
+		resp, err := apiClient.Post(webhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			if verbose {
+				fmt.Printf("webhook failed: %v\n", err)
+			}
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+func waitAlerts() {
+	alertsWG.Wait()
+}
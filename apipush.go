@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// apiFile is a file staged for the Git Data API engine
+type apiFile struct {
+	Path string // Slash-separated path relative to the directory root
+	Abs  string
+	Mode string
+	Size int64
+}
+
+// collectAPIFiles returns the files of a directory when it is small enough
+// for the API engine. Directories with .gitignore rules or files over the
+// size limit need git's own handling and are left to the git engine.
+func collectAPIFiles(dir string) ([]apiFile, bool) {
+	var files []apiFile
+	var total int64
+	eligible := true
+	sizes := newSizeCounter()
+	filter := newStageFilter(dir)
+
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !eligible {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "." && !filter.includesDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !filter.includes(rel) {
+			return nil
+		}
+		if info.Name() == ".gitignore" || info.Size() > maxFileSize {
+			eligible = false
+			return nil
+		}
+
+		total += sizes.add(path, info)
+		if total > apiEngineMaxSize {
+			eligible = false
+			return nil
+		}
+
+		mode := "100644"
+		if info.Mode()&os.ModeSymlink != 0 {
+			mode = "120000"
+		} else if info.Mode()&0111 != 0 {
+			mode = "100755"
+		}
+		files = append(files, apiFile{
+			Path: filepath.ToSlash(rel),
+			Abs:  path,
+			Mode: mode,
+			Size: info.Size(),
+		})
+		return nil
+	})
+
+	// Empty directories have nothing to seed the repository with
+	return files, eligible && len(files) > 0
+}
+
+// pushViaAPI uploads a snapshot through the blobs/trees/commits/refs
+// endpoints without running git locally.
+func pushViaAPI(job DirJob, files []apiFile, result Result) Result {
+	created, err := ensureGitHubRepo(job)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Created = created
+	if created {
+		emitEvent(Event{Type: "repo_created", Path: job.Path, Repo: job.owner() + "/" + job.RepoName})
+	}
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, job.owner(), job.RepoName)
+
+	for _, f := range files {
+		if f.Size > warnFileSize {
+			result.LargeFiles = append(result.LargeFiles, f.Path)
+		}
+	}
+
+	head, err := apiRefSHA(repoAPI)
+	if err != nil {
+		result.Message = fmt.Sprintf("api push failed: %v", err)
+		return result
+	}
+	result.PrevSHA = head
+
+	// The Git Data API refuses writes to an empty repository, so seed it
+	// with a first commit through the contents endpoint.
+	if head == "" && len(files) > 0 {
+		if err := apiSeedRepo(repoAPI, job, files[0]); err != nil {
+			result.Message = fmt.Sprintf("api push failed: %v", err)
+			return result
+		}
+	}
+
+	type treeEntry struct {
+		Path string `json:"path"`
+		Mode string `json:"mode"`
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+	}
+	// Only upload blobs the repository doesn't already have
+	known := remoteBlobs(repoAPI, head)
+	if head == "" && len(files) > 0 {
+		known = remoteBlobs(repoAPI, mustRefSHA(repoAPI))
+	}
+
+	shas, err := hashFiles(files)
+	if err != nil {
+		result.Message = fmt.Sprintf("api push failed: %v", err)
+		return result
+	}
+	var tree []treeEntry
+	for i, f := range files {
+		sha := shas[i]
+		if !known[sha] {
+			sha, err = apiCreateBlob(repoAPI, f)
+		}
+		if err != nil {
+			result.Message = fmt.Sprintf("api push failed: %s: %v", f.Path, err)
+			return result
+		}
+		known[sha] = true
+		tree = append(tree, treeEntry{Path: f.Path, Mode: f.Mode, Type: "blob", SHA: sha})
+	}
+
+	var treeResp struct {
+		SHA string `json:"sha"`
+	}
+	if err := apiPost(repoAPI+"/git/trees", map[string]interface{}{"tree": tree}, &treeResp); err != nil {
+		result.Message = fmt.Sprintf("api push failed: create tree: %v", err)
+		return result
+	}
+
+	// Same history semantics as the git engine: a fresh snapshot replaces
+	// the remote unless we are merging into existing commits.
+	parents := []string{}
+	if head != "" && (created || mergeRemote) {
+		parents = append(parents, head)
+	} else if err := trashRef(job.owner(), job.RepoName, job.branch(), head); err != nil {
+		result.Message = fmt.Sprintf("saving overwritten history failed: %v", err)
+		return result
+	} else {
+		result.Forced = head != ""
+	}
+	var commitResp struct {
+		SHA string `json:"sha"`
+	}
+	commit := map[string]interface{}{
+		"message": commitMessage(job),
+		"tree":    treeResp.SHA,
+		"parents": parents,
+	}
+	if err := apiPost(repoAPI+"/git/commits", commit, &commitResp); err != nil {
+		result.Message = fmt.Sprintf("api push failed: create commit: %v", err)
+		return result
+	}
+
+	if err := apiUpdateRef(repoAPI, commitResp.SHA); err != nil {
+		result.Message = fmt.Sprintf("api push failed: update ref: %v", err)
+		return result
+	}
+
+	result.PushedSHA = commitResp.SHA
+	result.Success = true
+	result.Message = "Success (api)"
+	result.RepoURL = fmt.Sprintf("https://github.com/%s/%s", job.owner(), job.RepoName)
+	return result
+}
+
+// apiRefSHA returns the commit main points at, or "" for an empty repo.
+func apiRefSHA(repoAPI string) (string, error) {
+	return apiBranchSHA(repoAPI, "main")
+}
+
+// apiBranchSHA returns the commit a branch points at, or "" when it is
+// missing.
+func apiBranchSHA(repoAPI, branch string) (string, error) {
+	resp, err := githubRequest("GET", repoAPI+"/git/ref/heads/"+branch, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// 404 for a missing branch, 409 for an empty repository
+	if resp.StatusCode == 404 || resp.StatusCode == 409 {
+		return "", nil
+	}
+	if resp.StatusCode != 200 {
+		return "", responseError("ref lookup", resp)
+	}
+
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ref); err != nil {
+		return "", err
+	}
+	return ref.Object.SHA, nil
+}
+
+// mustRefSHA is apiRefSHA for callers that treat errors as "no commits".
+func mustRefSHA(repoAPI string) string {
+	sha, _ := apiRefSHA(repoAPI)
+	return sha
+}
+
+func apiSeedRepo(repoAPI string, job DirJob, f apiFile) error {
+	data, err := readAPIFile(f)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{
+		"message": "Initial commit\n\n" + commitTrailers(job),
+		"content": base64.StdEncoding.EncodeToString(data),
+		"branch":  "main",
+	}
+	return apiSend("PUT", repoAPI+"/contents/"+f.Path, body, nil)
+}
+
+func apiCreateBlob(repoAPI string, f apiFile) (string, error) {
+	data, err := readAPIFile(f)
+	if err != nil {
+		return "", err
+	}
+	var blob struct {
+		SHA string `json:"sha"`
+	}
+	body := map[string]string{
+		"content":  base64.StdEncoding.EncodeToString(data),
+		"encoding": "base64",
+	}
+	if err := apiPost(repoAPI+"/git/blobs", body, &blob); err != nil {
+		return "", err
+	}
+	return blob.SHA, nil
+}
+
+// readAPIFile returns file content, or the link target for symlinks.
+func readAPIFile(f apiFile) ([]byte, error) {
+	if f.Mode == "120000" {
+		target, err := os.Readlink(f.Abs)
+		return []byte(filepath.ToSlash(target)), err
+	}
+	return ioutil.ReadFile(f.Abs)
+}
+
+func apiUpdateRef(repoAPI, sha string) error {
+	err := apiSend("PATCH", repoAPI+"/git/refs/heads/main", map[string]interface{}{"sha": sha, "force": true}, nil)
+	if err == nil {
+		return nil
+	}
+	return apiPost(repoAPI+"/git/refs", map[string]string{"ref": "refs/heads/main", "sha": sha}, nil)
+}
+
+func apiPost(url string, body interface{}, out interface{}) error {
+	return apiSend("POST", url, body, out)
+}
+
+// apiSend sends a JSON body and decodes a JSON response into out.
+func apiSend(method, url string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	resp, err := githubRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(method+" "+strings.TrimPrefix(url, githubAPI), resp)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// apiMessage extracts the message GitHub puts in error bodies. Validation
+// failures (422) carry the useful detail in the errors list, e.g. "name
+// already exists on this account".
+func apiMessage(resp *http.Response) string {
+	data, _ := ioutil.ReadAll(resp.Body)
+	logAPIFailure(resp, data)
+	var e struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(data, &e) != nil || e.Message == "" {
+		return strings.TrimSpace(string(data))
+	}
+
+	var details []string
+	for _, d := range e.Errors {
+		switch {
+		case d.Message != "":
+			details = append(details, d.Message)
+		case d.Field != "":
+			details = append(details, d.Field+" "+d.Code)
+		}
+	}
+	if len(details) > 0 {
+		return strings.Join(details, "; ")
+	}
+	return e.Message
+}
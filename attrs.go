@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Git keeps only the executable bit. With -file-attrs each commit carries
+// AttrsFile with the permissions, owner and extended attributes of every
+// committed file and of the directories holding them, and restore puts
+// them back, so backed up scripts, configs and service directories work
+// again after a restore. Owners are recorded by name and ID; a restore
+// prefers the name, and only root can give files away.
+const AttrsFile = ".gitmax-attrs.json"
+
+var recordAttrs bool
+
+// FileAttrs is what git doesn't keep of one file or directory
+type FileAttrs struct {
+	Mode   string            `json:"mode"` // Octal permissions with setuid, setgid and sticky bits
+	UID    *int              `json:"uid,omitempty"`
+	GID    *int              `json:"gid,omitempty"`
+	User   string            `json:"user,omitempty"`
+	Group  string            `json:"group,omitempty"`
+	Xattrs map[string]string `json:"xattrs,omitempty"` // Name → base64 value
+}
+
+// AttrsManifest is the content of AttrsFile, keyed by slash path
+type AttrsManifest struct {
+	Files map[string]FileAttrs `json:"files"`
+}
+
+// writeAttrs records the attributes of the files staged in dir and stages
+// AttrsFile with them.
+func writeAttrs(dir string) error {
+	if !recordAttrs {
+		return nil
+	}
+	out, err := runGitOutput(dir, "ls-files", "-z")
+	if err != nil {
+		return err
+	}
+	if err := writeAttrsFile(dir, strings.Split(out, "\x00")); err != nil {
+		return err
+	}
+	return runGit(dir, "add", "-f", "--", AttrsFile)
+}
+
+// writeAttrsFile writes AttrsFile for the given slash paths.
+func writeAttrsFile(dir string, paths []string) error {
+	manifest := AttrsManifest{Files: map[string]FileAttrs{}}
+	for _, rel := range paths {
+		if rel == "" || rel == AttrsFile {
+			continue
+		}
+		for p := rel; p != "."; p = path.Dir(p) {
+			if _, done := manifest.Files[p]; done {
+				break
+			}
+			info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p)))
+			if err != nil {
+				break
+			}
+			manifest.Files[p] = fileAttrs(filepath.Join(dir, filepath.FromSlash(p)), info)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, AttrsFile), append(data, '\n'), 0644)
+}
+
+func fileAttrs(abs string, info os.FileInfo) FileAttrs {
+	mode := info.Mode().Perm()
+	if info.Mode()&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if info.Mode()&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if info.Mode()&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	attrs := FileAttrs{Mode: fmt.Sprintf("%04o", uint32(mode))}
+	if uid, gid, ok := fileOwner(info); ok {
+		attrs.UID, attrs.GID = &uid, &gid
+		attrs.User, attrs.Group = userName(uid), groupName(gid)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if xattrs := readXattrs(abs); len(xattrs) > 0 {
+			attrs.Xattrs = map[string]string{}
+			for name, value := range xattrs {
+				attrs.Xattrs[name] = base64.StdEncoding.EncodeToString(value)
+			}
+		}
+	}
+	return attrs
+}
+
+var (
+	idNamesMu sync.Mutex
+	idNames   = map[string]string{} // "u123" or "g123" → name
+)
+
+func userName(uid int) string {
+	return cachedName("u"+strconv.Itoa(uid), func() (string, error) {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+func groupName(gid int) string {
+	return cachedName("g"+strconv.Itoa(gid), func() (string, error) {
+		g, err := user.LookupGroupId(strconv.Itoa(gid))
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+func cachedName(key string, lookup func() (string, error)) string {
+	idNamesMu.Lock()
+	defer idNamesMu.Unlock()
+	if name, ok := idNames[key]; ok {
+		return name
+	}
+	name, _ := lookup()
+	idNames[key] = name
+	return name
+}
+
+// restoreAttrs applies a restored checkout's AttrsFile, deepest paths
+// first so directories made read-only don't block their contents. Every
+// attribute is tried; the ones that fail are reported together.
+func restoreAttrs(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, AttrsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var manifest AttrsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("reading %s: %v", AttrsFile, err)
+	}
+
+	paths := make([]string, 0, len(manifest.Files))
+	for p := range manifest.Files {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i] > paths[j] })
+
+	var failed []string
+	for _, p := range paths {
+		if err := applyAttrs(filepath.Join(dir, filepath.FromSlash(p)), manifest.Files[p]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", p, err))
+		}
+	}
+	if len(failed) > 0 {
+		if !verbose && len(failed) > 5 {
+			failed = append(failed[:5], fmt.Sprintf("… %d more (-v for all)", len(failed)-5))
+		}
+		return fmt.Errorf("restoring file attributes:\n  %s", strings.Join(failed, "\n  "))
+	}
+	return nil
+}
+
+func applyAttrs(abs string, attrs FileAttrs) error {
+	info, err := os.Lstat(abs)
+	if err != nil {
+		return err
+	}
+	if attrs.UID != nil && attrs.GID != nil {
+		uid, gid := *attrs.UID, *attrs.GID
+		if u, err := user.Lookup(attrs.User); attrs.User != "" && err == nil {
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+		if g, err := user.LookupGroup(attrs.Group); attrs.Group != "" && err == nil {
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+		if cur, curGID, ok := fileOwner(info); !ok || cur != uid || curGID != gid {
+			if err := os.Lchown(abs, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	for name, encoded := range attrs.Xattrs {
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("xattr %s: %v", name, err)
+		}
+		if err := writeXattr(abs, name, value); err != nil {
+			return fmt.Errorf("xattr %s: %v", name, err)
+		}
+	}
+	// Last, since chown clears setuid and setgid
+	mode, err := strconv.ParseUint(attrs.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("mode %q: %v", attrs.Mode, err)
+	}
+	perm := os.FileMode(mode).Perm()
+	if mode&04000 != 0 {
+		perm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		perm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		perm |= os.ModeSticky
+	}
+	return os.Chmod(abs, perm)
+}
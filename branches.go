@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The snapshot replaces a directory's .git, which used to drop every
+// branch of an existing repo. With -branches, the selected branches and
+// all tags of the old repo are saved to a bundle first, fetched into the
+// new repo and pushed along with the snapshot:
+//
+//	all      every branch
+//	current  the branch that was checked out
+//	<glob>   branches matching a pattern, e.g. feature/*
+//
+// Branches are pushed as they were, replacing the remote ones; tags are
+// never moved on the remote. The old branch with the snapshot's name is
+// superseded by the snapshot. Repos gitmax created are left out, since
+// their only branch is an earlier snapshot.
+var branchesMode string
+
+// savedBranchRefs is where the new repo keeps the fetched branches, so
+// they don't collide with the snapshot's main
+const savedBranchRefs = "refs/gitmax-branches/"
+
+func validBranchesMode(mode string) error {
+	if mode == "" || mode == "all" || mode == "current" {
+		return nil
+	}
+	_, err := path.Match(mode, "")
+	return err
+}
+
+// saveBranches bundles the selected refs of the directory's existing repo
+// and returns the bundle's path, or "" when there is nothing to keep.
+func saveBranches(job DirJob) (string, error) {
+	if branchesMode == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(job.Path, ".git")); err != nil || isGitmaxRepo(job.Path) {
+		return "", nil
+	}
+
+	out, _ := runGitOutput(job.Path, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	current, _ := runGitOutput(job.Path, "symbolic-ref", "--short", "-q", "HEAD")
+	var refs []string
+	for _, branch := range strings.Fields(out) {
+		if selectBranch(branch, current) {
+			refs = append(refs, "refs/heads/"+branch)
+		}
+	}
+	// A detached checkout has no current branch; its commit is kept on a
+	// detached-<sha> branch instead
+	if branchesMode == "current" && current == "" {
+		if _, err := runGitOutput(job.Path, "rev-parse", "-q", "--verify", "HEAD"); err == nil {
+			refs = append(refs, "HEAD")
+		}
+	}
+	if tags, _ := runGitOutput(job.Path, "tag", "--list"); tags != "" {
+		refs = append(refs, "--tags")
+	}
+	if len(refs) == 0 {
+		return "", nil
+	}
+
+	bundle, err := ioutil.TempFile("", "gitmax-branches-*.bundle")
+	if err != nil {
+		return "", err
+	}
+	bundle.Close()
+	if err := runGit(job.Path, append([]string{"bundle", "create", bundle.Name()}, refs...)...); err != nil {
+		os.Remove(bundle.Name())
+		return "", err
+	}
+	return bundle.Name(), nil
+}
+
+func selectBranch(branch, current string) bool {
+	switch branchesMode {
+	case "all":
+		return true
+	case "current":
+		return branch == current
+	}
+	ok, _ := path.Match(branchesMode, branch)
+	return ok
+}
+
+// restoreBranches fetches a saveBranches bundle into the new repo and
+// returns the refspecs that push its branches and tags.
+func restoreBranches(job DirJob, bundle string) ([]string, error) {
+	if bundle == "" {
+		return nil, nil
+	}
+	fetch := []string{"fetch", "-q", bundle, "+refs/heads/*:" + savedBranchRefs + "*", "refs/tags/*:refs/tags/*"}
+	heads, _ := runGitOutput(job.Path, "bundle", "list-heads", bundle, "HEAD")
+	detached := ""
+	if fields := strings.Fields(heads); len(fields) == 2 && len(fields[0]) >= 7 {
+		detached = savedBranchRefs + "detached-" + fields[0][:7]
+		fetch = append(fetch, "+HEAD:"+detached)
+	}
+	if err := runGit(job.Path, fetch...); err != nil {
+		return nil, err
+	}
+
+	out, err := runGitOutput(job.Path, "for-each-ref", "--format=%(refname)", savedBranchRefs)
+	if err != nil {
+		return nil, err
+	}
+	var specs []string
+	for _, ref := range strings.Fields(out) {
+		branch := strings.TrimPrefix(ref, savedBranchRefs)
+		if branch != job.branch() {
+			specs = append(specs, "+"+ref+":refs/heads/"+branch)
+		}
+	}
+	if tags, _ := runGitOutput(job.Path, "tag", "--list"); tags != "" {
+		specs = append(specs, "refs/tags/*:refs/tags/*")
+	}
+	if verbose && len(specs) > 0 {
+		fmt.Printf("%s: also pushing %s\n", job.RepoName, strings.Join(specs, " "))
+	}
+	return specs, nil
+}
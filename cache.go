@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// blobCache maps a file's path, size and mtime to its git blob SHA, so an
+// unchanged file isn't hashed again by a later run. It is a per-path cache:
+// an identical file at another path is hashed on its own. It is persisted
+// between runs and serves the API engine and verify; the git engine hashes
+// through git add and doesn't use it.
+//
+// GitHub stores blobs per repository; uploads are skipped only when the
+// target repository already has the blob.
+type blobCache struct {
+	mu      sync.Mutex
+	path    string
+	Entries map[string]string `json:"entries"`
+	dirty   bool
+}
+
+var blobs = &blobCache{Entries: map[string]string{}}
+
+func loadBlobCache() {
+	blobs.path = filepath.Join(gitmaxDir(), "blobcache.json")
+	if data, err := ioutil.ReadFile(blobs.path); err == nil {
+		json.Unmarshal(data, blobs)
+		if blobs.Entries == nil {
+			blobs.Entries = map[string]string{}
+		}
+	}
+}
+
+func saveBlobCache() {
+	blobs.mu.Lock()
+	defer blobs.mu.Unlock()
+
+	if !blobs.dirty || blobs.path == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(blobs.path), 0755)
+	data, err := json.Marshal(blobs)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(blobs.path, data, 0644)
+}
+
+// blobSHA returns the git object ID for a file, using the cache when the
+// file is unchanged.
+func blobSHA(f apiFile) (string, error) {
+	info, err := os.Lstat(f.Abs)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s|%d|%d", f.Abs, info.Size(), info.ModTime().UnixNano())
+
+	blobs.mu.Lock()
+	sha, ok := blobs.Entries[key]
+	blobs.mu.Unlock()
+	if ok {
+		return sha, nil
+	}
+
+	data, err := readAPIFile(f)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	sha = hex.EncodeToString(h.Sum(nil))
+
+	blobs.mu.Lock()
+	blobs.Entries[key] = sha
+	blobs.dirty = true
+	blobs.mu.Unlock()
+	return sha, nil
+}
+
+// hashFiles returns the blob SHAs of files, in order, hashing up to
+// hashWorkers files at a time.
+func hashFiles(files []apiFile) ([]string, error) {
+	shas := make([]string, len(files))
+	errs := make([]error, len(files))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < hashWorkers && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(files) {
+					return
+				}
+				shas[i], errs[i] = blobSHA(files[i])
+			}
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", files[i].Path, err)
+		}
+	}
+	return shas, nil
+}
+
+// remoteBlobs lists the blob SHAs reachable from a commit's tree.
+func remoteBlobs(repoAPI, commit string) map[string]bool {
+	known := map[string]bool{}
+	if commit == "" {
+		return known
+	}
+
+	resp, err := githubRequest("GET", fmt.Sprintf("%s/git/trees/%s?recursive=1", repoAPI, commit), nil)
+	if err != nil {
+		return known
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return known
+	}
+
+	var tree struct {
+		Tree []struct {
+			Type string `json:"type"`
+			SHA  string `json:"sha"`
+		} `json:"tree"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&tree) == nil {
+		for _, e := range tree.Tree {
+			if e.Type == "blob" {
+				known[e.SHA] = true
+			}
+		}
+	}
+	return known
+}
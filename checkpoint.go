@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Very large snapshots are pushed in segments so a dropped connection
+// late in a 40GB upload doesn't start it over. Segment k is a commit whose
+// tree holds the files of segments 1..k; the segments go to the
+// checkpointBranch, which is therefore the record of how far the upload
+// got. Segment commits are built with fixed dates, so a rerun over the
+// same content rebuilds identical commits, finds the one the remote
+// already has and carries on after it.
+//
+// git push only skips objects reachable from the commits it builds on, and
+// the snapshot doesn't build on any checkpoint. So the snapshot push also
+// moves the branch to a commit joining the last segment and the snapshot,
+// which lets git see that the snapshot's tree is already on the remote.
+// The branch is deleted afterwards.
+const checkpointBranch = "gitmax-checkpoint"
+
+// checkpointSize is the most a segment holds, 0 to disable segmenting
+var checkpointSize int64
+
+// checkpointDate keeps segment commits reproducible across runs
+const checkpointDate = "1970-01-01T00:00:00Z"
+
+// pushCheckpoints uploads the staged files in segments when they exceed
+// checkpointSize. It returns the data pushed, the transport in use and the
+// refspec to add to the snapshot push, "" when segments weren't needed.
+func pushCheckpoints(job DirJob, transport string) (pushTransfer, string, string, error) {
+	var total pushTransfer
+	if checkpointSize <= 0 {
+		return total, transport, "", nil
+	}
+	segments, err := stagedSegments(job.Path, checkpointSize)
+	if err != nil || len(segments) < 2 {
+		return total, transport, "", err
+	}
+
+	shas, err := segmentCommits(job.Path, segments)
+	if err != nil {
+		return total, transport, "", fmt.Errorf("building checkpoints: %v", err)
+	}
+
+	start := 0
+	if out, err := runGitOutput(job.Path, "ls-remote", "--heads", pushRemote(), checkpointBranch); err == nil {
+		remote := headFromLsRemote(out)
+		for i, sha := range shas {
+			if sha == remote {
+				start = i + 1
+			}
+		}
+	}
+	if start > 0 {
+		fmt.Printf("\n↻ %s: resuming upload at segment %d of %d\n", truncatePath(job.Path, 40), start+1, len(shas))
+	}
+
+	for i := start; i < len(shas); i++ {
+		transfer, t, err := pushOrigin(job, transport, "push", "--force", pushRemote(), shas[i]+":refs/heads/"+checkpointBranch)
+		total.Bytes += transfer.Bytes
+		total.Objects += transfer.Objects
+		transport = t
+		if err != nil {
+			return total, transport, "", fmt.Errorf("segment %d of %d: %v (rerun to resume)", i+1, len(shas), err)
+		}
+	}
+
+	join, err := runGitOutput(job.Path, "commit-tree", "HEAD^{tree}", "-p", shas[len(shas)-1], "-p", "HEAD", "-m", "gitmax checkpoint join")
+	if err != nil {
+		return total, transport, "", fmt.Errorf("joining checkpoints: %v", err)
+	}
+	return total, transport, join + ":refs/heads/" + checkpointBranch, nil
+}
+
+// dropCheckpoints deletes the checkpoint branch after the snapshot landed.
+func dropCheckpoints(job DirJob) {
+	if err := runGit(job.Path, "push", pushRemote(), "--delete", checkpointBranch); err != nil && verbose {
+		fmt.Printf("deleting %s in %s failed: %v\n", checkpointBranch, job.RepoName, err)
+	}
+}
+
+// stagedSegments splits the index into runs of index lines (as printed by
+// ls-files -s) whose files add up to at most limit bytes each.
+func stagedSegments(dir string, limit int64) ([][]string, error) {
+	out, err := runGitOutput(dir, "ls-files", "-s", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments [][]string
+	var current []string
+	var size int64
+	for _, entry := range strings.Split(out, "\x00") {
+		if entry == "" {
+			continue
+		}
+		_, path, _ := strings.Cut(entry, "\t")
+		var fileSize int64
+		if info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(path))); err == nil {
+			fileSize = info.Size()
+		}
+		if len(current) > 0 && size+fileSize > limit {
+			segments = append(segments, current)
+			current, size = nil, 0
+		}
+		current = append(current, entry)
+		size += fileSize
+	}
+	if len(current) > 0 {
+		segments = append(segments, current)
+	}
+	return segments, nil
+}
+
+// segmentCommits builds one commit per segment, each on top of the last,
+// in a scratch index so the real one is untouched.
+func segmentCommits(dir string, segments [][]string) ([]string, error) {
+	index, err := ioutil.TempFile("", "gitmax-checkpoint-index")
+	if err != nil {
+		return nil, err
+	}
+	index.Close()
+	os.Remove(index.Name()) // git wants to create it
+	defer os.Remove(index.Name())
+
+	env := []string{
+		"GIT_INDEX_FILE=" + index.Name(),
+		"GIT_AUTHOR_DATE=" + checkpointDate,
+		"GIT_COMMITTER_DATE=" + checkpointDate,
+	}
+	run := func(stdin string, args ...string) (string, error) {
+		cmd := gitCommand(dir, args...)
+		cmd.Env = append(cmd.Env, env...)
+		cmd.Stdin = strings.NewReader(stdin)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	var shas []string
+	parent := ""
+	for i, segment := range segments {
+		if _, err := run(strings.Join(segment, "\x00")+"\x00", "update-index", "-z", "--index-info"); err != nil {
+			return nil, err
+		}
+		tree, err := run("", "write-tree")
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"commit-tree", tree, "-m", fmt.Sprintf("gitmax checkpoint %d/%d", i+1, len(segments))}
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+		sha, err := run("", args...)
+		if err != nil {
+			return nil, err
+		}
+		shas = append(shas, sha)
+		parent = sha
+	}
+	return shas, nil
+}
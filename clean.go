@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitmax keeps its .gitignore rules between these markers and rewrites the
+// section on every run. Older versions appended a section starting with
+// gitignoreHeader and ending at the next blank line on each run instead.
+const (
+	gitignoreBegin  = "# BEGIN gitmax: auto-excluded large files"
+	gitignoreEnd    = "# END gitmax"
+	gitignoreHeader = "# gitit: auto-excluded large files"
+)
+
+// runClean undoes what gitmax did to source trees: the .git directories it
+// created, its .gitignore sections and README footers, and its metadata
+// files.
+func runClean(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	fs.BoolVar(&verbose, "v", false, "Verbose output")
+	dry := fs.Bool("dry-run", false, "List what would be removed")
+	depth := fs.Int("depth", 20, "Max directory depth")
+	addGitFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: gitmax clean [-dry-run] <root>")
+		return 1
+	}
+	root := normalizePath(fs.Arg(0))
+
+	var removed, kept int
+	for _, dir := range scanDirectories(root, *depth) {
+		if linked := linkedCheckout(dir); linked != "" {
+			if verbose {
+				fmt.Printf("keep   %s (%s)\n", filepath.Join(dir, ".git"), linked)
+			}
+			kept++
+		} else if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			if isGitmaxRepo(dir) {
+				fmt.Printf("remove %s\n", filepath.Join(dir, ".git"))
+				if !*dry {
+					os.RemoveAll(filepath.Join(dir, ".git"))
+				}
+				removed++
+			} else {
+				if verbose {
+					fmt.Printf("keep   %s (not created by gitmax)\n", filepath.Join(dir, ".git"))
+				}
+				kept++
+			}
+		}
+
+		for _, name := range []string{MetadataFile, AttrsFile} {
+			meta := filepath.Join(dir, name)
+			if _, err := os.Stat(meta); err == nil {
+				fmt.Printf("remove %s\n", meta)
+				if !*dry {
+					os.Remove(meta)
+				}
+				removed++
+			}
+		}
+		ghMeta := filepath.Join(dir, GitHubMetaDir)
+		if _, err := os.Stat(ghMeta); err == nil {
+			fmt.Printf("remove %s\n", ghMeta)
+			if !*dry {
+				os.RemoveAll(ghMeta)
+			}
+			removed++
+		}
+
+		if readme, markdown := findReadme(dir); markdown {
+			if data, err := ioutil.ReadFile(readme); err == nil {
+				if cleaned, changed := stripReadmeFooter(string(data)); changed {
+					fmt.Printf("clean  %s\n", readme)
+					removed++
+					if !*dry && strings.TrimSpace(cleaned) == "" {
+						os.Remove(readme)
+					} else if !*dry {
+						ioutil.WriteFile(readme, []byte(cleaned), 0644)
+					}
+				}
+			}
+		}
+
+		gitignore := filepath.Join(dir, ".gitignore")
+		if data, err := ioutil.ReadFile(gitignore); err == nil {
+			cleaned, changed := stripGitmaxSections(string(data))
+			if !changed {
+				continue
+			}
+			fmt.Printf("clean  %s\n", gitignore)
+			removed++
+			if *dry {
+				continue
+			}
+			if strings.TrimSpace(cleaned) == "" {
+				os.Remove(gitignore)
+			} else {
+				ioutil.WriteFile(gitignore, []byte(cleaned), 0644)
+			}
+		}
+	}
+
+	fmt.Printf("\n%d item(s) cleaned, %d repo(s) not created by gitmax kept\n", removed, kept)
+	return 0
+}
+
+// isGitmaxRepo reports whether gitmax initialized the repo: either it
+// carries the gitmax.managed flag, or every commit is a gitmax snapshot
+// (older versions wrote neither the flag nor trailers).
+func isGitmaxRepo(dir string) bool {
+	if out, _ := runGitOutput(dir, "config", "--get", "gitmax.managed"); out == "true" {
+		return true
+	}
+
+	out, err := runGitOutput(dir, "log", "--format=%s")
+	if err != nil || out == "" {
+		return false
+	}
+	for _, subject := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(subject, "Auto commit ") && subject != "Merge remote main" {
+			return false
+		}
+	}
+	return true
+}
+
+// stripGitmaxSections removes gitmax-generated blocks: everything from a
+// begin marker through its end marker, and old-style sections from the
+// header comment to the next blank line.
+func stripGitmaxSections(content string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	var out []string
+	changed := false
+	for i := 0; i < len(lines); i++ {
+		switch {
+		case strings.HasPrefix(lines[i], gitignoreBegin):
+			for i+1 < len(lines) && strings.TrimSpace(lines[i]) != gitignoreEnd {
+				i++
+			}
+		case strings.HasPrefix(lines[i], gitignoreHeader):
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+			}
+		default:
+			out = append(out, lines[i])
+			continue
+		}
+		changed = true
+		// Drop the blank line the section was appended after
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+			out = out[:len(out)-1]
+		}
+	}
+	return strings.Join(out, "\n"), changed
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// subcommands are dispatched on the first argument; anything else is the
+// classic push invocation.
+var subcommands = map[string]func(args []string) int{
+	"clean":          runClean,
+	"config":         runConfig,
+	"coordinator":    runCoordinator,
+	"explain":        runExplain,
+	"git-credential": runGitCredential,
+	"remote-list":    runRemoteList,
+	"restore":        runRestore,
+	"scan":           runScan,
+	"service":        runService,
+	"stats":          runStats,
+	"status":         runStatus,
+	"trash":          runTrash,
+	"undo":           runUndo,
+	"verify":         runVerify,
+	"version":        runVersion,
+	"visibility":     runVisibility,
+}
+
+// commonOptions are flags every subcommand accepts
+type commonOptions struct {
+	tokenFile  string
+	tokenStdin bool
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonOptions {
+	opts := &commonOptions{}
+	fs.StringVar(&opts.tokenFile, "token-file", "", "Read the GitHub token from this file")
+	fs.BoolVar(&opts.tokenStdin, "token-stdin", false, "Read the GitHub token from stdin")
+	fs.BoolVar(&verbose, "v", false, "Verbose output")
+	fs.StringVar(&managedTopic, "marker", managedTopic, "Topic marking gitmax-created repos")
+	fs.StringVar(&githubUser, "user", "", "GitHub account (default: the token's owner)")
+	fs.StringVar(&statePath, "state", "", "State file (default: ~/.gitmax/state.json)")
+	addGitFlags(fs)
+	return opts
+}
+
+// requireToken loads the token for subcommands that can't work without one.
+func (o *commonOptions) requireToken() error {
+	token, err := readToken(o.tokenFile, o.tokenStdin)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		token = getGitHubToken()
+	}
+	ghToken = token
+	if token == "" {
+		ok, why := detectGH()
+		if !ok {
+			return fmt.Errorf("no GitHub token found and %s; run 'gh auth login' or pass -token-file", why)
+		}
+		useGH = true
+	}
+	if err := checkTokenAccess(); err != nil {
+		return err
+	}
+	return requireUser()
+}
+
+// pathMapping rewrites a recorded source prefix to a new root
+type pathMapping struct {
+	From string
+	To   string
+}
+
+// mappingList is a repeatable FROM=TO flag
+type mappingList []pathMapping
+
+func (l *mappingList) String() string {
+	var parts []string
+	for _, m := range *l {
+		parts = append(parts, m.From+"="+m.To)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *mappingList) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected FROM=TO, got %q", v)
+	}
+	*l = append(*l, pathMapping{From: v[:i], To: v[i+1:]})
+	return nil
+}
+
+// apply rewrites a recorded path with the first matching mapping. Paths
+// are compared with forward slashes so Windows sources map onto POSIX roots
+// and back; drive-letter paths compare case-insensitively.
+func (l mappingList) apply(src string) (string, bool) {
+	srcN := strings.ReplaceAll(src, "\\", "/")
+	for _, m := range l {
+		from := strings.TrimSuffix(strings.ReplaceAll(m.From, "\\", "/"), "/")
+		if len(srcN) < len(from) {
+			continue
+		}
+		prefix := srcN[:len(from)]
+		match := prefix == from
+		if !match && len(from) >= 2 && from[1] == ':' {
+			match = strings.EqualFold(prefix, from)
+		}
+		rest := srcN[len(from):]
+		if !match || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		return joinNative(m.To, rest), true
+	}
+	return "", false
+}
+
+// joinNative joins a slash-separated suffix onto a root using the local
+// path separator.
+func joinNative(root, rest string) string {
+	rest = strings.TrimPrefix(rest, "/")
+	if rest == "" {
+		return root
+	}
+	sep := string(os.PathSeparator)
+	return strings.TrimSuffix(root, sep) + sep + strings.ReplaceAll(rest, "/", sep)
+}
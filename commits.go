@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommitPolicy shapes the commits and branches gitmax pushes so that
+// server-side hooks on protected repos, which often demand conventional
+// commits or ticket IDs, accept them. Message replaces the snapshot
+// subject and MergeMessage the one of -merge-remote merges; {date},
+// {name}, {owner}, {branch}, {path}, {host} and {run} are filled in per
+// job. Every generated subject and the target branch are checked
+// against the patterns before anything is committed, so a job that would
+// be rejected fails up front with the reason instead of at push time.
+type CommitPolicy struct {
+	Message        string `yaml:"message"`         // Subject template, default "Auto commit {date}"
+	MergeMessage   string `yaml:"merge_message"`   // Default "Merge remote {branch}"
+	MessagePattern string `yaml:"message_pattern"` // Regexp subjects must match
+	BranchPattern  string `yaml:"branch_pattern"`  // Regexp remote branches must match
+}
+
+const (
+	defaultCommitSubject = "Auto commit {date}"
+	defaultMergeSubject  = "Merge remote {branch}"
+)
+
+var (
+	commitPatternsOnce sync.Once
+	messagePattern     *regexp.Regexp
+	branchPattern      *regexp.Regexp
+)
+
+// commitPatterns compiles the policy's patterns, which checkValues has
+// already validated.
+func commitPatterns() (*regexp.Regexp, *regexp.Regexp) {
+	commitPatternsOnce.Do(func() {
+		if p := config.Commits.MessagePattern; p != "" {
+			messagePattern, _ = regexp.Compile(p)
+		}
+		if p := config.Commits.BranchPattern; p != "" {
+			branchPattern, _ = regexp.Compile(p)
+		}
+	})
+	return messagePattern, branchPattern
+}
+
+// commitSubject is the first line of a snapshot commit.
+func commitSubject(job DirJob) string {
+	return expandSubject(config.Commits.Message, defaultCommitSubject, job)
+}
+
+// mergeSubject is the first line of the commit -merge-remote makes.
+func mergeSubject(job DirJob) string {
+	return expandSubject(config.Commits.MergeMessage, defaultMergeSubject, job)
+}
+
+func expandSubject(template, fallback string, job DirJob) string {
+	if template == "" {
+		template = fallback
+	}
+	return strings.NewReplacer(
+		"{date}", time.Now().Format("2006-01-02 15:04:05"),
+		"{name}", job.RepoName,
+		"{owner}", job.owner(),
+		"{branch}", job.branch(),
+		"{path}", job.Path,
+		"{host}", hostname,
+		"{run}", runID,
+	).Replace(template)
+}
+
+// createsInitialCommit reports whether new repos start with commits of
+// their own, from a template or auto_init, which the push merges.
+func createsInitialCommit() bool {
+	rc := config.Repo
+	return rc.Template != "" || rc.AutoInit || rc.GitignoreTemplate != "" || rc.LicenseTemplate != ""
+}
+
+// lintCommits checks what the job would push against the commit policy.
+func lintCommits(job DirJob) error {
+	message, branch := commitPatterns()
+	if branch != nil && !branch.MatchString(job.branch()) {
+		return fmt.Errorf("branch %q doesn't match commits.branch_pattern %s", job.branch(), branch)
+	}
+	if message == nil {
+		return nil
+	}
+	subjects := []string{commitSubject(job)}
+	if mergeRemote || createsInitialCommit() {
+		subjects = append(subjects, mergeSubject(job))
+	}
+	for _, s := range subjects {
+		if !message.MatchString(s) {
+			return fmt.Errorf("commit message %q doesn't match commits.message_pattern %s", s, message)
+		}
+	}
+	return nil
+}
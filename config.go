@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional ~/.gitmax.yml file
+type Config struct {
+	// Defaults for flags the command line doesn't give
+	Workers   int      `yaml:"workers"`    // -w
+	Depth     int      `yaml:"depth"`      // -depth
+	User      string   `yaml:"user"`       // -user
+	Exclude   []string `yaml:"exclude"`    // -exclude
+	TokenFile string   `yaml:"token_file"` // -token-file
+	TokenEnv  string   `yaml:"token_env"`  // Environment variable holding the token, tried before gh
+
+	Junk []string `yaml:"junk"` // Name globs never committed, replacing defaultJunk
+
+	Repo        RepoConfig                `yaml:"repo"`
+	Remotes     map[string]string         `yaml:"remotes"` // Extra push destinations, name → URL template
+	Directories []DirConfig               `yaml:"directories"`
+	Visibility  VisibilityRules           `yaml:"visibility"` // Per-directory private/public by glob
+	Providers   map[string]ProviderConfig `yaml:"providers"`  // Picked with -provider NAME
+	S3          S3Config                  `yaml:"s3"`         // Where -offload puts oversized files
+	Existing    string                    `yaml:"existing"`   // Policy for repos gitmax didn't create, see existing.go
+	Commits     CommitPolicy              `yaml:"commits"`
+}
+
+// DirConfig holds settings for directories whose path matches Match. The
+// first matching entry wins.
+type DirConfig struct {
+	Match        string   `yaml:"match"`         // Glob, see matchGlob
+	Paths        []string `yaml:"paths"`         // Only push these subpaths, e.g. [src, docs]
+	Existing     string   `yaml:"existing"`      // Overrides the existing policy
+	ReadmeFooter *bool    `yaml:"readme_footer"` // Overrides -readme-footer
+}
+
+// VisibilityRule makes repos for directories matching Match private or
+// public
+type VisibilityRule struct {
+	Match      string
+	Visibility string // "private" or "public"
+}
+
+// VisibilityRules is a glob → visibility mapping kept in file order, since
+// the first matching rule wins.
+type VisibilityRules []VisibilityRule
+
+func (r *VisibilityRules) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: visibility must map globs to private or public", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		*r = append(*r, VisibilityRule{Match: node.Content[i].Value, Visibility: node.Content[i+1].Value})
+	}
+	return nil
+}
+
+// RepoConfig is the payload used when creating repos. Unset fields keep
+// GitHub's defaults; an empty description falls back to the source path.
+type RepoConfig struct {
+	Description       string `yaml:"description"`
+	Homepage          string `yaml:"homepage"`
+	Private           bool   `yaml:"private"`
+	AutoInit          bool   `yaml:"auto_init"`
+	HasIssues         *bool  `yaml:"has_issues"`
+	HasWiki           *bool  `yaml:"has_wiki"`
+	HasProjects       *bool  `yaml:"has_projects"`
+	IsTemplate        bool   `yaml:"is_template"`
+	TeamID            int64  `yaml:"team_id"` // Only honored for organization repos
+	GitignoreTemplate string `yaml:"gitignore_template"`
+	LicenseTemplate   string `yaml:"license_template"`
+	Template          string `yaml:"template"` // owner/name of a template repo to generate from
+}
+
+// starterConfig is written by gitmax config init
+const starterConfig = `# gitmax configuration. Command line flags override these values.
+# Values may reference the environment as ${VAR} or ${VAR:-default}.
+
+# Defaults for -w, -depth, -user, -exclude and -token-file
+# workers: 20
+# depth: 20
+# user: octocat               # Default: the token's owner
+# exclude:                    # Directories not made into repos, nor any below them
+#   - "**/node_modules"
+#   - "**/tmp"
+# token_file: ~/.config/gitmax/token
+# token_env: GITMAX_TOKEN     # Read the token from this variable instead
+
+# OS junk never committed or counted, matched by name at any depth and
+# without regard to case; this list replaces the built-in one
+# (Thumbs.db, .DS_Store, desktop.ini, $RECYCLE.BIN, System Volume
+# Information and a few more), and [] commits everything
+# junk: [Thumbs.db, .DS_Store, desktop.ini, "*.tmp"]
+
+# Payload for newly created repos; private sets the default visibility
+repo:
+  # description: ""          # Defaults to "gitmax backup of <host>:<path>"
+  # homepage: ""
+  private: false
+  auto_init: false
+  # has_issues: true
+  # has_wiki: true
+  # has_projects: true
+  # is_template: false
+  # team_id: 0               # Organization repos only
+  # gitignore_template: Go
+  # license_template: mit
+  # template: owner/template-repo
+
+# Extra push destinations; {owner} and {name} are replaced per repo
+# remotes:
+#   backup: git@gitlab.com:{owner}/{name}.git
+
+# Other forges to push to, picked per run with -provider NAME
+# providers:
+#   work:
+#     type: bitbucket
+#     workspace: acme
+#     project: BAK             # Project key, optional
+#     username: ${BITBUCKET_USER}
+#     app_password: ${BITBUCKET_APP_PASSWORD}
+#     private: true
+#     ssh: false
+#   corp:
+#     type: azure
+#     organization: contoso
+#     project: Backups
+#     token: ${AZURE_DEVOPS_PAT}
+#   aws:
+#     type: codecommit       # Pushes need git-remote-codecommit
+#     region: eu-west-1
+#     profile: backup        # From ~/.aws/credentials; default uses AWS_* variables
+#   nas:
+#     type: ssh              # Bare repos created with git init over SSH
+#     host: git@nas.local
+#     path: /srv/git
+#   hut:
+#     type: sourcehut        # Runs the gitmax-provider-sourcehut plugin from PATH
+#     settings:              # Passed to the plugin as is
+#       token: ${SRHT_TOKEN}
+
+# Bucket for files over -max-file-size, used with -offload
+# s3:
+#   bucket: gitmax-large-files
+#   region: us-east-1
+#   prefix: backups
+#   endpoint: https://minio.local:9000   # For S3-compatible stores
+#   path_style: true
+#   access_key: ${S3_ACCESS_KEY}         # Default: AWS_* variables or profile
+#   secret_key: ${S3_SECRET_KEY}
+
+# Visibility of new repos by directory glob, overriding repo.private; the
+# first matching rule wins
+# visibility:
+#   "**/work/**": private
+#   "**/oss/**": public
+
+# Per-directory settings; the first entry whose glob matches wins
+# directories:
+#   - match: "**/monorepo"
+#     paths: [src, docs]     # Push only these subpaths
+#   - match: "**/shared/**"
+#     existing: suffix       # Overrides the top-level existing
+#     readme_footer: true    # Overrides -readme-footer
+
+# What to do when a repo by that name exists but gitmax didn't create it:
+# adopt (push into it, the default), skip, suffix (push to name-2) or fail
+# existing: adopt
+
+# Commit messages and branches for repos whose server-side hooks enforce a
+# format; jobs that wouldn't match fail before committing anything
+# commits:
+#   message: "chore(backup): snapshot {name} {date}"  # Also {owner}, {branch}, {path}, {host}, {run}
+#   merge_message: "chore(backup): merge remote {branch}"
+#   message_pattern: '^(feat|fix|chore)(\(.+\))?: .+'
+#   branch_pattern: '^(main|backup/.+)$'
+`
+
+var config Config
+
+// configPath is the config file in effect, empty when there is none
+var configPath string
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".gitmax.yml"
+	}
+	return filepath.Join(home, ".gitmax.yml")
+}
+
+// loadConfig reads the config file. A missing default file is fine; a
+// missing file named with -config is not.
+func loadConfig(path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg, errs := parseConfig(path, data)
+	if len(errs) > 0 {
+		return errors.New(joinErrors(errs))
+	}
+	config = cfg
+	configPath = path
+
+	// -remote flags were parsed first and take precedence
+	for name, url := range config.Remotes {
+		if _, ok := extraRemotes[name]; !ok {
+			extraRemotes[name] = url
+		}
+	}
+	return nil
+}
+
+// parseConfig decodes strictly: unknown keys, type mismatches and invalid
+// values are all reported with their file position.
+func parseConfig(path string, data []byte) (Config, []error) {
+	var cfg Config
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return cfg, []error{fmt.Errorf("%s: %v", path, err)}
+	}
+	if len(root.Content) == 0 {
+		return cfg, nil
+	}
+	doc := root.Content[0]
+
+	errs := checkKeys(path, doc, reflect.TypeOf(cfg), "")
+	errs = append(errs, expandNode(path, doc)...)
+	if err := doc.Decode(&cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, e := range typeErr.Errors {
+				errs = append(errs, fmt.Errorf("%s: %s", path, e))
+			}
+		} else {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+	errs = append(errs, checkValues(path, doc, cfg)...)
+	return cfg, errs
+}
+
+// envRe matches ${NAME} and ${NAME:-default} references in config values
+var envRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandNode replaces environment references in every scalar value so one
+// config file works across machines and CI. An unset variable without a
+// default is an error rather than a silent empty string.
+func expandNode(path string, node *yaml.Node) []error {
+	if node.Kind != yaml.ScalarNode {
+		var errs []error
+		for i, child := range node.Content {
+			// Mapping keys are names, not values
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			errs = append(errs, expandNode(path, child)...)
+		}
+		return errs
+	}
+	if !strings.Contains(node.Value, "${") {
+		return nil
+	}
+
+	var errs []error
+	node.Value = envRe.ReplaceAllStringFunc(node.Value, func(ref string) string {
+		m := envRe.FindStringSubmatch(ref)
+		if v, ok := os.LookupEnv(m[1]); ok && v != "" {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		errs = append(errs, fmt.Errorf("%s:%d:%d: environment variable %s is not set", path, node.Line, node.Column, m[1]))
+		return ""
+	})
+	// Let the expanded text pick its own type, e.g. private: ${PRIVATE}
+	if node.Style == 0 {
+		node.Tag = ""
+	}
+	return errs
+}
+
+// checkKeys walks a mapping node and reports keys that don't correspond to
+// a field of t.
+func checkKeys(path string, node *yaml.Node, t reflect.Type, prefix string) []error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice {
+		var errs []error
+		for i, item := range node.Content {
+			errs = append(errs, checkKeys(path, item, t.Elem(), fmt.Sprintf("%s%d.", prefix, i))...)
+		}
+		return errs
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if t.Kind() == reflect.Map {
+			errs = append(errs, checkKeys(path, value, t.Elem(), prefix+key.Value+".")...)
+			continue
+		}
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		field, ok := yamlField(t, key.Value)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s:%d:%d: unknown key %q", path, key.Line, key.Column, prefix+key.Value))
+			continue
+		}
+		errs = append(errs, checkKeys(path, value, field.Type, prefix+key.Value+".")...)
+	}
+	return errs
+}
+
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// nodeAt finds the value node for a dotted key path, for error positions.
+// Sequence items are addressed by index.
+func nodeAt(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, k := range keys {
+		if node != nil && node.Kind == yaml.SequenceNode {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return nil
+			}
+			node = node.Content[i]
+			continue
+		}
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == k {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// checkValues validates field values beyond their types.
+func checkValues(path string, doc *yaml.Node, cfg Config) []error {
+	var errs []error
+	fail := func(msg string, keys ...string) {
+		pos := path
+		if n := nodeAt(doc, keys...); n != nil {
+			pos = fmt.Sprintf("%s:%d:%d", path, n.Line, n.Column)
+		}
+		errs = append(errs, fmt.Errorf("%s: %s: %s", pos, strings.Join(keys, "."), msg))
+	}
+
+	if t := cfg.Repo.Template; t != "" && len(strings.Split(t, "/")) != 2 {
+		fail("expected owner/name", "repo", "template")
+	}
+	for name, url := range cfg.Remotes {
+		if name == "origin" || name == incrementalRemote {
+			fail(name+" is the GitHub remote and can't be redefined", "remotes", name)
+		}
+		if !strings.Contains(url, "{name}") {
+			fail("URL template should contain {name}", "remotes", name)
+		}
+	}
+	for name, p := range cfg.Providers {
+		if name == "origin" || name == incrementalRemote || cfg.Remotes[name] != "" {
+			fail("provider names share the remote namespace and must be unique", "providers", name)
+		}
+		for _, msg := range providerProblems(p) {
+			fail(msg, "providers", name)
+		}
+	}
+	for _, rule := range cfg.Visibility {
+		if rule.Visibility != "private" && rule.Visibility != "public" {
+			fail(fmt.Sprintf("expected private or public, got %q", rule.Visibility), "visibility", rule.Match)
+		}
+	}
+	for key, pattern := range map[string]string{"message_pattern": cfg.Commits.MessagePattern, "branch_pattern": cfg.Commits.BranchPattern} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fail(err.Error(), "commits", key)
+		}
+	}
+	if !validExistingPolicy(cfg.Existing) {
+		fail(fmt.Sprintf("expected adopt, skip, suffix or fail, got %q", cfg.Existing), "existing")
+	}
+	for i, p := range cfg.Junk {
+		if _, err := filepath.Match(p, ""); err != nil || p == "" || strings.ContainsAny(p, `/\`) {
+			fail(fmt.Sprintf("%q is not a file or directory name glob", p), "junk", strconv.Itoa(i))
+		}
+	}
+	if cfg.Workers < 0 {
+		fail("must be positive", "workers")
+	}
+	if cfg.Depth < 0 {
+		fail("must be positive", "depth")
+	}
+	for i, d := range cfg.Directories {
+		n := strconv.Itoa(i)
+		if d.Match == "" {
+			fail("match is required", "directories", n)
+		}
+		if !validExistingPolicy(d.Existing) {
+			fail(fmt.Sprintf("expected adopt, skip, suffix or fail, got %q", d.Existing), "directories", n, "existing")
+		}
+		for _, p := range d.Paths {
+			clean := filepath.ToSlash(filepath.Clean(p))
+			if filepath.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") {
+				fail(fmt.Sprintf("%q must be relative to the directory", p), "directories", n, "paths")
+			}
+		}
+	}
+	return errs
+}
+
+func joinErrors(errs []error) string {
+	var b bytes.Buffer
+	for i, e := range errs {
+		if i > 0 {
+			b.WriteString("\n  ")
+		}
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// applyConfigDefaults sets the flags of fs the command line didn't give
+// from the config file.
+func applyConfigDefaults(fs *flag.FlagSet) {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	defaults := map[string]string{"user": config.User}
+	if config.Workers > 0 {
+		defaults["w"] = strconv.Itoa(config.Workers)
+	}
+	if config.Depth > 0 {
+		defaults["depth"] = strconv.Itoa(config.Depth)
+	}
+	if config.TokenFile != "" {
+		defaults["token-file"] = normalizePath(config.TokenFile)
+	}
+	for name, value := range defaults {
+		if value != "" && !given[name] && fs.Lookup(name) != nil {
+			fs.Set(name, value)
+		}
+	}
+	if !given["exclude"] {
+		excludeDirs = config.Exclude
+	}
+}
+
+// dirConfig returns the first directories entry matching path.
+func dirConfig(path string) (DirConfig, bool) {
+	for _, d := range config.Directories {
+		if matchGlob(d.Match, path) {
+			return d, true
+		}
+	}
+	return DirConfig{}, false
+}
+
+// visibilityRule returns the first visibility rule matching path.
+func visibilityRule(path string) (VisibilityRule, bool) {
+	for _, rule := range config.Visibility {
+		if matchGlob(rule.Match, path) {
+			return rule, true
+		}
+	}
+	return VisibilityRule{}, false
+}
+
+// runConfig implements config validate and config init.
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: gitmax config validate|init [-config <path>]")
+		return 1
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("config "+sub, flag.ExitOnError)
+	path := fs.String("config", defaultConfigPath(), "Config file")
+	force := fs.Bool("force", false, "Overwrite an existing file (init)")
+	fs.Parse(args[1:])
+
+	switch sub {
+	case "validate":
+		data, err := ioutil.ReadFile(*path)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return 1
+		}
+		if _, errs := parseConfig(*path, data); len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Printf("✗ %v\n", e)
+			}
+			return 1
+		}
+		fmt.Printf("✓ %s is valid\n", *path)
+		return 0
+
+	case "init":
+		if _, err := os.Stat(*path); err == nil && !*force {
+			fmt.Printf("✗ %s already exists (use -force to overwrite)\n", *path)
+			return 1
+		}
+		if err := ioutil.WriteFile(*path, []byte(starterConfig), 0644); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return 1
+		}
+		fmt.Printf("✓ Wrote %s\n", *path)
+		return 0
+	}
+
+	fmt.Printf("Unknown config command %q\n", sub)
+	return 1
+}
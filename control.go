@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The control API lets another process inspect a running push and cancel
+// individual directories without aborting the run:
+//
+//	GET  /jobs               in-flight directories
+//	POST /cancel?path=<dir>  kill the directory's git process, mark it skipped
+//
+// Queued directories can be cancelled too; they are skipped when reached.
+var cancelledPaths sync.Map // Path → true
+
+// jobStatus is one entry of GET /jobs
+type jobStatus struct {
+	Path    string    `json:"path"`
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
+}
+
+func startControlServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", handleJobs)
+	mux.HandleFunc("/cancel", handleCancel)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.ListenAndServe() }()
+
+	// Surface bind errors before the run starts
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	var jobs []jobStatus
+	activeJobs.Range(func(_, v interface{}) bool {
+		job := v.(*activeJob)
+		jobs = append(jobs, jobStatus{Path: job.Path, Started: job.Start, Seconds: time.Since(job.Start).Seconds()})
+		return true
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.Before(jobs[j].Started) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path required", http.StatusBadRequest)
+		return
+	}
+
+	cancelledPaths.Store(path, true)
+	if v, ok := activeJobs.Load(path); ok {
+		v.(*activeJob).kill()
+		fmt.Fprintf(w, "cancelled running job %s\n", path)
+		return
+	}
+	fmt.Fprintf(w, "%s will be skipped\n", path)
+}
+
+func isCancelled(path string) bool {
+	_, ok := cancelledPaths.Load(path)
+	return ok
+}
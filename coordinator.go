@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Several machines backing up the same NAS share the work through a
+// coordinator, a small HTTP state server started with gitmax coordinator.
+// Before a directory is processed, its repo is claimed there under a lease
+// that the run keeps renewing; a repo claimed by another run is skipped, so
+// two machines never snapshot the same .git at once, and a repo another run
+// pushed after this one started is skipped too, so it isn't pushed twice.
+// Directories thus partition themselves between the machines as they go.
+// Without the coordinator nothing is pushed: a job that can't claim fails.
+//
+//	POST /claim    {key, holder, host, since, lease_seconds}
+//	POST /renew    {keys, holder, lease_seconds}
+//	POST /release  {key, holder, done}
+//	GET  /claims   every known repo
+//
+// It also queues work for agents; see agent.go.
+//
+// The coordinator keeps its state in memory; after a restart, runs in
+// flight get their claims back when they renew.
+const (
+	coordinatorLease  = 2 * time.Minute
+	coordinatorKeep   = 7 * 24 * time.Hour // How long finished pushes are remembered
+	coordinatorEnvKey = "GITMAX_COORDINATOR_TOKEN"
+)
+
+var (
+	coordinatorURL string
+	heldClaims     sync.Map // Key → true
+	coordClient    = &http.Client{Timeout: 15 * time.Second}
+)
+
+// claimRequest is the body of /claim, /renew and /release
+type claimRequest struct {
+	Key          string    `json:"key,omitempty"`
+	Keys         []string  `json:"keys,omitempty"`
+	Holder       string    `json:"holder"`
+	Host         string    `json:"host,omitempty"`
+	Since        time.Time `json:"since,omitempty"`
+	LeaseSeconds int       `json:"lease_seconds,omitempty"`
+	Done         bool      `json:"done,omitempty"`
+}
+
+// claimReply answers /claim
+type claimReply struct {
+	Granted bool   `json:"granted"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// repoClaim is the coordinator's record of one repo
+type repoClaim struct {
+	Key      string    `json:"key"`
+	Holder   string    `json:"holder,omitempty"` // Run holding the lease, empty once released
+	Host     string    `json:"host,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	DoneAt   time.Time `json:"done_at,omitempty"` // Last successful push
+	DoneBy   string    `json:"done_by,omitempty"`
+	DoneHost string    `json:"done_host,omitempty"`
+}
+
+// coordinating reports whether this run claims repos before pushing.
+func coordinating() bool {
+	return coordinatorURL != "" && !dryRun && exportDir == ""
+}
+
+func claimKey(job DirJob) string {
+	return strings.ToLower(job.owner() + "/" + job.RepoName)
+}
+
+// coordinatorPost sends a request to the coordinator and decodes its reply.
+func coordinatorPost(endpoint string, body claimRequest, reply interface{}) (int, error) {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(coordinatorURL, "/")+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(coordinatorEnvKey); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := coordClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return resp.StatusCode, fmt.Errorf("coordinator: %s", resp.Status)
+	}
+	if reply != nil {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			return resp.StatusCode, fmt.Errorf("coordinator: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// checkCoordinator makes sure the coordinator answers before the run.
+func checkCoordinator() error {
+	_, err := coordinatorPost("/renew", claimRequest{Holder: runID}, nil)
+	return err
+}
+
+// claimRepo claims the job's repo for this run. It returns why not when
+// another run has it.
+func claimRepo(job DirJob) (string, error) {
+	var reply claimReply
+	_, err := coordinatorPost("/claim", claimRequest{Key: claimKey(job), Holder: runID, Host: hostname,
+		Since: stats.StartTime, LeaseSeconds: int(coordinatorLease.Seconds())}, &reply)
+	if err != nil {
+		return "", err
+	}
+	if !reply.Granted {
+		return reply.Reason, nil
+	}
+	heldClaims.Store(claimKey(job), true)
+	return "", nil
+}
+
+// releaseRepo gives the claim back, recording whether the push succeeded.
+func releaseRepo(job DirJob, done bool) {
+	heldClaims.Delete(claimKey(job))
+	if _, err := coordinatorPost("/release", claimRequest{Key: claimKey(job), Holder: runID, Done: done}, nil); err != nil && verbose {
+		fmt.Printf("releasing %s: %v\n", claimKey(job), err)
+	}
+}
+
+// renewClaims keeps this run's leases alive until stop is closed.
+func renewClaims(stop <-chan struct{}) {
+	ticker := time.NewTicker(coordinatorLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var keys []string
+		heldClaims.Range(func(k, _ interface{}) bool {
+			keys = append(keys, k.(string))
+			return true
+		})
+		if len(keys) == 0 {
+			continue
+		}
+		if _, err := coordinatorPost("/renew", claimRequest{Keys: keys, Holder: runID, Host: hostname,
+			LeaseSeconds: int(coordinatorLease.Seconds())}, nil); err != nil && verbose {
+			fmt.Printf("renewing claims: %v\n", err)
+		}
+	}
+}
+
+// coordinator is the server side
+type coordinator struct {
+	mu     sync.Mutex
+	claims map[string]*repoClaim
+	token  string
+	queue  *jobQueue
+}
+
+func runCoordinator(args []string) int {
+	fs := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	listen := fs.String("listen", ":7071", "Address to serve on")
+	queueFile := fs.String("queue", "", "Path list (-f format) to hand out to agents")
+	fs.Parse(args)
+
+	c := &coordinator{claims: map[string]*repoClaim{}, token: os.Getenv(coordinatorEnvKey), queue: newJobQueue()}
+	if *queueFile != "" {
+		f, err := os.Open(*queueFile)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return 1
+		}
+		n, err := c.queue.add(f)
+		f.Close()
+		if err != nil {
+			fmt.Printf("✗ %s: %v\n", *queueFile, err)
+			return 1
+		}
+		fmt.Printf("Queued %d directories for agents\n", n)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/claim", c.handle(c.claim))
+	mux.HandleFunc("/renew", c.handle(c.renew))
+	mux.HandleFunc("/release", c.handle(c.release))
+	mux.HandleFunc("/claims", c.authorize(c.list))
+	c.queue.register(mux, c)
+
+	if c.token == "" {
+		fmt.Printf("⚠ %s is not set; anyone who can reach %s can claim repos\n", coordinatorEnvKey, *listen)
+	}
+	fmt.Printf("Coordinating gitmax runs on %s\n", *listen)
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// authorize rejects requests without the coordinator's token.
+func (c *coordinator) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.token != "" && r.Header.Get("Authorization") != "Bearer "+c.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// handle decodes a POST body and runs fn on it under the lock.
+func (c *coordinator) handle(fn func(claimRequest, time.Time) (int, interface{})) http.HandlerFunc {
+	return c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		var req claimRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Holder == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		status, reply := fn(req, time.Now())
+		c.prune()
+		c.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(reply)
+	})
+}
+
+func (c *coordinator) entry(key string) *repoClaim {
+	rc := c.claims[key]
+	if rc == nil {
+		rc = &repoClaim{Key: key}
+		c.claims[key] = rc
+	}
+	return rc
+}
+
+func (c *coordinator) claim(req claimRequest, now time.Time) (int, interface{}) {
+	if req.Key == "" {
+		return http.StatusBadRequest, claimReply{Reason: "key required"}
+	}
+	rc := c.entry(req.Key)
+	switch {
+	case rc.Holder != "" && rc.Holder != req.Holder && now.Before(rc.Expires):
+		return http.StatusConflict, claimReply{Reason: fmt.Sprintf("being pushed by %s (run %s)", rc.Host, rc.Holder)}
+	case !rc.DoneAt.IsZero() && rc.DoneBy != req.Holder && rc.DoneAt.After(req.Since):
+		return http.StatusConflict, claimReply{Reason: fmt.Sprintf("pushed by %s at %s (run %s)",
+			rc.DoneHost, rc.DoneAt.Local().Format("15:04:05"), rc.DoneBy)}
+	}
+	rc.Holder, rc.Host = req.Holder, req.Host
+	rc.Expires = now.Add(time.Duration(req.LeaseSeconds) * time.Second)
+	return http.StatusOK, claimReply{Granted: true}
+}
+
+// renew extends the holder's leases, taking back ones lost to a restart.
+// Agents' job leases come along, marked by agentLeaseKey.
+func (c *coordinator) renew(req claimRequest, now time.Time) (int, interface{}) {
+	var jobIDs []string
+	for _, key := range req.Keys {
+		if id, ok := strings.CutPrefix(key, agentLeaseKey("")); ok {
+			jobIDs = append(jobIDs, id)
+			continue
+		}
+		rc := c.entry(key)
+		if rc.Holder == req.Holder || rc.Holder == "" || now.After(rc.Expires) {
+			rc.Holder, rc.Host = req.Holder, req.Host
+			rc.Expires = now.Add(time.Duration(req.LeaseSeconds) * time.Second)
+		}
+	}
+	c.queue.renew(req.Holder, jobIDs, time.Duration(req.LeaseSeconds)*time.Second)
+	return http.StatusOK, struct{}{}
+}
+
+func (c *coordinator) release(req claimRequest, now time.Time) (int, interface{}) {
+	rc := c.claims[req.Key]
+	if rc == nil || rc.Holder != req.Holder {
+		return http.StatusOK, struct{}{}
+	}
+	if req.Done {
+		rc.DoneAt, rc.DoneBy, rc.DoneHost = now, rc.Holder, rc.Host
+	}
+	rc.Holder, rc.Host, rc.Expires = "", "", time.Time{}
+	return http.StatusOK, struct{}{}
+}
+
+// prune forgets repos with no lease and no recent push. Callers hold mu.
+func (c *coordinator) prune() {
+	now := time.Now()
+	for key, rc := range c.claims {
+		if now.After(rc.Expires) && now.Sub(rc.DoneAt) > coordinatorKeep {
+			delete(c.claims, key)
+		}
+	}
+}
+
+func (c *coordinator) list(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	list := make([]repoClaim, 0, len(c.claims))
+	for _, rc := range c.claims {
+		list = append(list, *rc)
+	}
+	c.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
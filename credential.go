@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// git authenticates to GitHub through gitmax itself rather than whatever
+// credential helper happens to be configured: every git command gets
+//
+//	-c credential.https://github.com.helper=
+//	-c credential.https://github.com.helper=!'/path/to/gitmax' git-credential
+//
+// The empty value drops any other helper for github.com, and the token
+// reaches the helper through the environment of that one git process, so
+// it is never written to disk, never stored by a credential store and
+// never appears in a command line.
+const credentialTokenEnv = "GITMAX_CREDENTIAL_TOKEN"
+
+const credentialKey = "credential.https://github.com.helper"
+
+var (
+	helperOnce sync.Once
+	helperCmd  string // "" when gitmax can't locate its own binary
+)
+
+// credentialHelper returns the helper command pointing back at gitmax.
+func credentialHelper() string {
+	helperOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Printf("⚠ Can't inject git credentials, falling back to git's helpers: %v\n", err)
+			return
+		}
+		helperCmd = "!" + shellQuote(exe) + " git-credential"
+	})
+	return helperCmd
+}
+
+// credentialArgs returns the git options and environment that make git
+// authenticate with ghToken, or with gh's helper when gh stands in for the
+// token.
+func credentialArgs() ([]string, []string) {
+	if ghToken == "" && useGH {
+		return []string{"-c", credentialKey + "=", "-c", credentialKey + "=!gh auth git-credential"}, nil
+	}
+	if ghToken == "" || credentialHelper() == "" {
+		return nil, nil
+	}
+	args := []string{"-c", credentialKey + "=", "-c", credentialKey + "=" + credentialHelper()}
+	return args, []string{credentialTokenEnv + "=" + ghToken}
+}
+
+// runGitCredential implements git's credential helper protocol: git writes
+// key=value lines ending in a blank line and, for get, reads back the
+// username and password. store and erase are no-ops since the token lives
+// in memory only.
+func runGitCredential(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: gitmax git-credential get|store|erase (run by git)")
+		return 1
+	}
+	attrs := map[string]string{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			attrs[key] = value
+		}
+	}
+
+	token := os.Getenv(credentialTokenEnv)
+	if args[0] != "get" || token == "" || attrs["host"] != "github.com" {
+		return 0
+	}
+	fmt.Printf("username=x-access-token\npassword=%s\n", token)
+	return 0
+}
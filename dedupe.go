@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// dedupeJobs drops jobs for a directory that is already planned, so two
+// workers never snapshot the same .git at once. Paths are compared after
+// making them absolute and resolving symlinks, and without case on the
+// case-insensitive filesystems of Windows and macOS. The first entry wins,
+// with its options; dropped entries are reported.
+func dedupeJobs(jobs []DirJob) []DirJob {
+	seen := map[string]string{} // Key → path as first given
+	kept := jobs[:0]
+	for _, job := range jobs {
+		key := dirKey(job.Path)
+		if first, ok := seen[key]; ok {
+			if first == job.Path {
+				fmt.Printf("⚠ %s is listed more than once; processing it once\n", job.Path)
+			} else {
+				fmt.Printf("⚠ %s is the same directory as %s; processing it once\n", job.Path, first)
+			}
+			continue
+		}
+		seen[key] = job.Path
+		kept = append(kept, job)
+	}
+	return kept
+}
+
+// dirKey identifies the directory at path.
+func dirKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		path = strings.ToLower(path)
+	}
+	return path
+}
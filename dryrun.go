@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Dry runs perform the read-only half of a push: token validation happens at
+// startup, then each directory's repo name is validated and looked up so the
+// report says what would be created and what would be updated.
+var (
+	dryRunNames   = map[string]string{}
+	dryRunNamesMu sync.Mutex
+)
+
+func dryRunCheck(job DirJob, result Result) Result {
+	result.RepoURL = fmt.Sprintf("https://github.com/%s/%s", job.owner(), job.RepoName)
+
+	if err := validRepoName(job.RepoName); err != nil {
+		result.Message = "Dry run - " + err.Error()
+		return result
+	}
+
+	// Two directories mapping to one repo would overwrite each other
+	dryRunNamesMu.Lock()
+	other, dup := dryRunNames[job.RepoName]
+	if !dup {
+		dryRunNames[job.RepoName] = job.Path
+	}
+	dryRunNamesMu.Unlock()
+	if dup {
+		result.Message = fmt.Sprintf("Dry run - repo name %s also used by %s", job.RepoName, other)
+		return result
+	}
+
+	if !hasAPI() {
+		result.Success = true
+		result.Message = "Dry run - would push (no token, remote not checked)"
+		return result
+	}
+
+	exists, err := repoExists(job.owner(), job.RepoName)
+	if err != nil {
+		result.Message = "Dry run - " + err.Error()
+		return result
+	}
+
+	result.Success = true
+	result.Created = !exists
+	if exists {
+		result.Message = "Dry run - would update " + job.RepoName
+	} else {
+		result.Message = "Dry run - would create " + job.RepoName
+	}
+	return result
+}
+
+// printDryRunPlan summarizes what a real run would do.
+func printDryRunPlan() {
+	var create, update int
+	for _, r := range results {
+		if !r.Success {
+			continue
+		}
+		if r.Created {
+			create++
+		} else {
+			update++
+		}
+	}
+	fmt.Printf("\nDry run plan: %d repo(s) to create, %d to update\n", create, update)
+}
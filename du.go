@@ -0,0 +1,73 @@
+package main
+
+import "os"
+
+// fileID identifies a file's storage independent of its path
+type fileID struct {
+	dev, ino uint64
+}
+
+// extent is a physical range of a file's data on disk
+type extent struct {
+	physical, length uint64
+	shared           bool
+}
+
+// reflinkMinSize is the smallest file checked for shared extents
+const reflinkMinSize = 64 * 1024
+
+type extentID struct {
+	dev, physical uint64
+}
+
+// sizeCounter sums file sizes the way they occupy disk: hardlinks to the
+// same inode count once, and extents shared between reflinked clones
+// count once. Each counter is one scope, typically one directory tree.
+type sizeCounter struct {
+	inodes  map[fileID]bool
+	extents map[extentID]bool
+}
+
+func newSizeCounter() *sizeCounter {
+	return &sizeCounter{inodes: map[fileID]bool{}, extents: map[extentID]bool{}}
+}
+
+// add returns how many bytes the file adds to the total.
+func (c *sizeCounter) add(path string, info os.FileInfo) int64 {
+	id, links, ok := fileIdentity(info)
+	if !ok {
+		return info.Size()
+	}
+	if links > 1 {
+		if c.inodes[id] {
+			return 0
+		}
+		c.inodes[id] = true
+	}
+
+	// Reflinking small files saves too little to be worth an open + ioctl
+	if info.Size() < reflinkMinSize {
+		return info.Size()
+	}
+	exts, ok := fileExtents(path)
+	if !ok {
+		return info.Size()
+	}
+	// Only subtract data already counted; holes in sparse files still
+	// cost their full size once committed.
+	n := info.Size()
+	for _, e := range exts {
+		if !e.shared {
+			continue
+		}
+		key := extentID{id.dev, e.physical}
+		if c.extents[key] {
+			n -= int64(e.length)
+		}
+		c.extents[key] = true
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -events streams one JSON object per line as the run progresses, for
+// orchestrators and UIs:
+//
+//	run_started    total
+//	job_started    path, repo
+//	repo_created   path, repo
+//	push_finished  path, repo, status, message, sha, seconds
+//	run_completed  total, success, failed, skipped, seconds
+var (
+	eventsTarget string
+	eventsMu     sync.Mutex
+	eventsOut    io.WriteCloser
+)
+
+// Event is one line of the -events stream
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	RunID   string    `json:"run_id"`
+	Path    string    `json:"path,omitempty"`
+	Repo    string    `json:"repo,omitempty"` // owner/name
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+	SHA     string    `json:"sha,omitempty"`
+	Seconds float64   `json:"seconds,omitempty"`
+	Total   int64     `json:"total,omitempty"`
+	Success int64     `json:"success,omitempty"`
+	Failed  int64     `json:"failed,omitempty"`
+	Skipped int64     `json:"skipped,omitempty"`
+}
+
+// openEvents connects the stream: fd://N writes to an inherited file
+// descriptor (fd://1 is stdout), unix:PATH connects to a listening socket.
+func openEvents(target string) error {
+	switch {
+	case strings.HasPrefix(target, "fd://"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd://"))
+		if err != nil || fd < 1 {
+			return fmt.Errorf("-events: bad file descriptor in %q", target)
+		}
+		eventsOut = os.NewFile(uintptr(fd), "events")
+	case strings.HasPrefix(target, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+		if err != nil {
+			return fmt.Errorf("-events: %v", err)
+		}
+		eventsOut = conn
+	default:
+		return fmt.Errorf("-events must be fd://N or unix:PATH, got %q", target)
+	}
+	return nil
+}
+
+// emitEvent writes an event. A broken stream is dropped rather than
+// failing the run.
+func emitEvent(e Event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventsOut == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	e.RunID = runID
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := eventsOut.Write(append(data, '\n')); err != nil {
+		fmt.Printf("\n⚠ Event stream closed (%v); no more events will be sent\n", err)
+		eventsOut.Close()
+		eventsOut = nil
+	}
+}
+
+func closeEvents() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventsOut != nil {
+		eventsOut.Close()
+		eventsOut = nil
+	}
+}
+
+// pushFinishedEvent describes a finished directory.
+func pushFinishedEvent(job DirJob, result Result) Event {
+	e := Event{
+		Type:    "push_finished",
+		Path:    job.Path,
+		Repo:    job.owner() + "/" + job.RepoName,
+		Status:  "success",
+		SHA:     result.PushedSHA,
+		Seconds: result.Duration.Seconds(),
+	}
+	switch {
+	case result.Skipped:
+		e.Status = "skipped"
+		e.Message = result.Message
+	case !result.Success:
+		e.Status = "failed"
+		e.Message = result.Message
+	}
+	return e
+}
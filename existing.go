@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A repo that already exists but that gitmax didn't create, neither marked
+// with managedTopic nor in the state file, is handled by an existing
+// policy, set with -existing, the top-level existing key or per directory
+// in directories[].existing:
+//
+//	adopt   push into it, replacing its history (the default)
+//	skip    leave it alone and skip the directory
+//	suffix  push to the first free name-2, name-3, ... instead
+//	fail    fail the directory
+var existingPolicy string
+
+var existingPolicies = []string{"adopt", "skip", "suffix", "fail"}
+
+// maxSuffix bounds the names suffix tries
+const maxSuffix = 20
+
+var (
+	suffixMu    sync.Mutex
+	suffixTaken = map[string]string{} // owner/name → path, names suffix picked this run
+)
+
+func validExistingPolicy(p string) bool {
+	return p == "" || containsString(existingPolicies, p)
+}
+
+// existingPolicyFor picks the policy for a directory: its directories
+// entry, then the flag, then the config file.
+func existingPolicyFor(path string) string {
+	if d, ok := dirConfig(path); ok && d.Existing != "" {
+		return d.Existing
+	}
+	if existingPolicy != "" {
+		return existingPolicy
+	}
+	if config.Existing != "" {
+		return config.Existing
+	}
+	return "adopt"
+}
+
+// managedByUs reports whether gitmax created or pushed the repo before.
+func managedByUs(owner, name string) (bool, error) {
+	stateMu.Lock()
+	_, known := state.Repos[owner+"/"+name]
+	stateMu.Unlock()
+	if known {
+		return true, nil
+	}
+	return isManagedRepo(owner, name)
+}
+
+// checkExisting applies the existing policy to the job's repo. It returns
+// the job to push, renamed under suffix, and a non-empty result when the
+// directory stops here.
+func checkExisting(job DirJob, result Result) (DirJob, Result, bool) {
+	policy := existingPolicyFor(job.Path)
+	if policy == "adopt" || !hasAPI() || exportDir != "" {
+		return job, result, true
+	}
+	foreign, err := foreignRepo(job.owner(), job.RepoName)
+	if err != nil {
+		result.Message = fmt.Sprintf("checking %s/%s failed: %v", job.owner(), job.RepoName, err)
+		return job, result, false
+	}
+	if !foreign {
+		return job, result, true
+	}
+
+	switch policy {
+	case "skip":
+		result.Skipped = true
+		result.Message = fmt.Sprintf("%s/%s exists and wasn't made by gitmax (existing policy: skip)", job.owner(), job.RepoName)
+		return job, result, false
+	case "fail":
+		result.Message = fmt.Sprintf("%s/%s exists and wasn't made by gitmax (existing policy: fail)", job.owner(), job.RepoName)
+		return job, result, false
+	}
+
+	for n := 2; n <= maxSuffix; n++ {
+		name := fmt.Sprintf("%s-%d", job.RepoName, n)
+		if !reserveSuffix(job, name) {
+			continue
+		}
+		foreign, err := foreignRepo(job.owner(), name)
+		if err != nil {
+			result.Message = fmt.Sprintf("checking %s/%s failed: %v", job.owner(), name, err)
+			return job, result, false
+		}
+		if !foreign {
+			if verbose {
+				fmt.Printf("%s/%s exists and wasn't made by gitmax; pushing %s to %s\n", job.owner(), job.RepoName, job.Path, name)
+			}
+			job.RepoName, result.RepoName = name, name
+			return job, result, true
+		}
+	}
+	result.Message = fmt.Sprintf("%s/%s through -%d all exist and weren't made by gitmax", job.owner(), job.RepoName, maxSuffix)
+	return job, result, false
+}
+
+// foreignRepo reports whether a repo exists without being gitmax's.
+func foreignRepo(owner, name string) (bool, error) {
+	exists, err := repoExists(owner, name)
+	if err != nil || !exists {
+		return false, err
+	}
+	managed, err := managedByUs(owner, name)
+	return !managed, err
+}
+
+// reserveSuffix keeps two directories from picking the same suffixed name
+// in one run.
+func reserveSuffix(job DirJob, name string) bool {
+	suffixMu.Lock()
+	defer suffixMu.Unlock()
+	key := strings.ToLower(job.owner() + "/" + name)
+	if path, taken := suffixTaken[key]; taken && path != job.Path {
+		return false
+	}
+	suffixTaken[key] = job.Path
+	return true
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// explanation is one decision about a path, with the rule that made it and
+// where that rule comes from.
+type explanation struct {
+	Verdict string // included, excluded, skipped or renamed
+	Rule    string
+	Source  string
+}
+
+func (e explanation) String() string {
+	return fmt.Sprintf("%-9s %s (%s)", e.Verdict, e.Rule, e.Source)
+}
+
+// runExplain reports why a directory would or wouldn't become a repo, and
+// why a file would or wouldn't be committed, in the spirit of
+// git check-ignore -v.
+func runExplain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	root := fs.String("root", "", "Scan root (directories) or backed-up directory (files); default: the path or its parent")
+	depth := fs.Int("depth", 20, "Max directory depth")
+	level := fs.Int("level", 0, "Only directories exactly this many levels below the root")
+	fs.BoolVar(&includeHiddenDirs, "include-hidden", false, "Scan hidden directories")
+	fs.BoolVar(&includeHiddenFiles, "hidden-files", false, "Commit hidden files")
+	maxFileMB := fs.Int("max-file-size", GitHubFileLimitMB, "Max file size in MB before exclusion")
+	warnFileMB := fs.Int("warn-file-size", GitHubWarnLimitMB, "File size in MB above which files are reported as large")
+	fs.BoolVar(&offloadLarge, "offload", false, "Files over -max-file-size are offloaded")
+	configFile := fs.String("config", "", "Config file (default: ~/.gitmax.yml)")
+	var since, before timeBound
+	fs.Var(&since, "modified-since", "Only directories with a file modified since this age or date")
+	fs.Var(&before, "modified-before", "Only directories with no file modified since this age or date")
+	addGitFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: gitmax explain [-root DIR] [flags] <path>")
+		return 1
+	}
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Printf("✗ Could not read config: %v\n", err)
+		return 1
+	}
+	maxFileSize = int64(*maxFileMB) * 1024 * 1024
+	warnFileSize = int64(*warnFileMB) * 1024 * 1024
+
+	path, err := filepath.Abs(normalizePath(fs.Arg(0)))
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+
+	top := *root
+	if top == "" {
+		top = path
+		if !info.IsDir() {
+			top = filepath.Dir(path)
+		}
+	}
+	if top, err = filepath.Abs(normalizePath(top)); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	rel, err := filepath.Rel(top, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		fmt.Printf("✗ %s is not under %s\n", path, top)
+		return 1
+	}
+
+	var steps []explanation
+	if info.IsDir() {
+		steps = explainDir(top, path, rel, *depth, *level, since, before)
+	} else {
+		steps = explainFile(top, rel, info)
+	}
+
+	fmt.Println(path)
+	for _, s := range steps {
+		fmt.Printf("  %s\n", s)
+	}
+	if last := steps[len(steps)-1]; last.Verdict == "excluded" || last.Verdict == "skipped" {
+		return 1
+	}
+	return 0
+}
+
+func explainDir(root, path, rel string, depth, level int, since, before timeBound) []explanation {
+	var steps []explanation
+	segs := strings.Split(rel, string(os.PathSeparator))
+	if rel == "." {
+		segs = nil
+	}
+
+	for _, seg := range segs {
+		if seg == ".git" {
+			return append(steps, explanation{"skipped", "git metadata is never scanned", "built-in"})
+		}
+		if !includeHiddenDirs && isHidden(seg) {
+			return append(steps, explanation{"skipped", fmt.Sprintf("hidden directory %q", seg), "default; enable with -include-hidden"})
+		}
+	}
+	switch {
+	case level > 0 && len(segs) != level:
+		return append(steps, explanation{"skipped", fmt.Sprintf("%d levels below the root, not %d", len(segs), level), "-level"})
+	case level == 0 && len(segs) > depth:
+		return append(steps, explanation{"skipped", fmt.Sprintf("%d levels below the root, deeper than %d", len(segs), depth), "-depth"})
+	}
+
+	if !since.t.IsZero() || !before.t.IsZero() {
+		newest := newestModTime(path)
+		stamp := newest.Format("2006-01-02 15:04")
+		if !since.t.IsZero() && newest.Before(since.t) {
+			return append(steps, explanation{"skipped", "newest file is from " + stamp, "-modified-since " + since.String()})
+		}
+		if !before.t.IsZero() && !newest.Before(before.t) {
+			return append(steps, explanation{"skipped", "newest file is from " + stamp, "-modified-before " + before.String()})
+		}
+	}
+
+	for i, d := range config.Directories {
+		if matchGlob(d.Match, path) {
+			rule := fmt.Sprintf("matches %q", d.Match)
+			if len(d.Paths) > 0 {
+				rule += "; only " + strings.Join(d.Paths, ", ") + " committed"
+			}
+			if d.Existing != "" {
+				rule += "; existing repos: " + d.Existing
+			}
+			steps = append(steps, explanation{"included", rule, fmt.Sprintf("%s: directories.%d", configPath, i)})
+			break
+		}
+	}
+
+	name := pathToRepoName(path)
+	if name != filepath.Base(path) {
+		steps = append(steps, explanation{"renamed", fmt.Sprintf("repo %s: lowercased, spaces to dashes, other characters dropped", name), "built-in"})
+	}
+	return append(steps, explanation{"included", "repo " + name, "scan of " + root})
+}
+
+func explainFile(dir, rel string, info os.FileInfo) []explanation {
+	slash := filepath.ToSlash(rel)
+	for _, seg := range strings.Split(slash, "/") {
+		if seg == ".git" {
+			return []explanation{{"skipped", "git metadata is never committed", "built-in"}}
+		}
+	}
+	for _, name := range alwaysStaged {
+		if slash == name || strings.HasPrefix(slash, name+"/") {
+			return []explanation{{"included", name + " is always committed", "built-in"}}
+		}
+	}
+	if !includeHiddenFiles && hasHiddenSegment(slash) {
+		return []explanation{{"excluded", "hidden path", "default; enable with -hidden-files"}}
+	}
+	for i, d := range config.Directories {
+		if !matchGlob(d.Match, dir) {
+			continue
+		}
+		if !newStageFilter(dir).includes(slash) {
+			return []explanation{{"excluded", "not under " + strings.Join(d.Paths, ", "), fmt.Sprintf("%s: directories.%d.paths", configPath, i)}}
+		}
+		break
+	}
+
+	if info.Mode().IsRegular() {
+		mb := float64(info.Size()) / (1024 * 1024)
+		switch {
+		case info.Size() > maxFileSize && offloadLarge:
+			return []explanation{{"excluded", fmt.Sprintf("%.1f MB, offloaded to s3://%s and listed in %s", mb, config.S3.Bucket, OffloadManifest), "-max-file-size, -offload"}}
+		case info.Size() > maxFileSize:
+			return []explanation{{"excluded", fmt.Sprintf("%.1f MB is over %d MB; added to .gitignore", mb, maxFileSize/(1024*1024)), "-max-file-size"}}
+		}
+	}
+
+	if rule, source := checkIgnore(dir, slash); rule != "" {
+		return []explanation{{"excluded", rule, source}}
+	}
+
+	if info.Size() > warnFileSize {
+		return []explanation{{"included", fmt.Sprintf("%.1f MB, reported as a large file", float64(info.Size())/(1024*1024)), "-warn-file-size"}}
+	}
+	return []explanation{{"included", "no rule excludes it", "default"}}
+}
+
+// checkIgnore asks git which ignore rule, if any, matches rel. A throwaway
+// git dir keeps this working for directories that aren't repos yet.
+func checkIgnore(dir, rel string) (string, string) {
+	gitDir, err := ioutil.TempDir("", "gitmax-explain")
+	if err != nil {
+		return "", ""
+	}
+	defer os.RemoveAll(gitDir)
+	if gitCommand("", "init", "-q", "--bare", gitDir).Run() != nil {
+		return "", ""
+	}
+
+	cmd := gitCommand(dir, "--git-dir="+gitDir, "--work-tree="+dir, "check-ignore", "-v", "--no-index", "--", rel)
+	out, err := cmd.Output()
+	if err != nil {
+		// Exit status 1: nothing matched
+		return "", ""
+	}
+	// <source>:<line>:<pattern>\t<path>
+	match, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	parts := strings.SplitN(match, ":", 3)
+	if len(parts) != 3 {
+		return "", ""
+	}
+	if strings.HasPrefix(parts[2], "!") {
+		return "", ""
+	}
+	source := parts[0]
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(dir, source)
+	}
+	return fmt.Sprintf("ignore pattern %q", parts[2]), fmt.Sprintf("%s:%s", source, parts[1])
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// exportDir switches the run from pushing to writing one git bundle per
+// directory, for air-gapped backups or when no forge is reachable.
+var exportDir string
+
+// exportBundle writes the freshly committed snapshot of job to
+// <exportDir>/<repo>.bundle. git clone works on bundles directly.
+func exportBundle(job DirJob, result Result) Result {
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		result.Message = fmt.Sprintf("export failed: %v", err)
+		return result
+	}
+	dest, err := filepath.Abs(filepath.Join(exportDir, job.RepoName+".bundle"))
+	if err != nil {
+		result.Message = fmt.Sprintf("export failed: %v", err)
+		return result
+	}
+
+	// Write next to the target and rename, so an interrupted run never
+	// leaves a truncated bundle under the final name
+	tmp := dest + ".tmp"
+	if err := runGit(job.Path, "bundle", "create", tmp, "HEAD", "main"); err != nil {
+		os.Remove(tmp)
+		result.Message = fmt.Sprintf("git bundle failed: %v", err)
+		return result
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		result.Message = fmt.Sprintf("export failed: %v", err)
+		return result
+	}
+	if info, err := os.Stat(dest); err == nil {
+		result.Transfer.Bytes = info.Size()
+		atomic.AddInt64(&stats.BytesPushed, info.Size())
+	}
+
+	result.PushedSHA, _ = runGitOutput(job.Path, "rev-parse", "HEAD")
+	result.RepoURL = dest
+	result.Success = true
+	result.Message = "Exported"
+	return result
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every running job keeps a transcript of its git commands with their full
+// output and of the GitHub API calls about its repo that failed. When the
+// job fails, the transcript goes to ~/.gitmax/failures/<repo>.txt with an
+// environment summary, so a failure can be debugged without rerunning
+// with -v. The file is removed once the repo pushes successfully again.
+const maxTranscript = 1 << 20
+
+var (
+	envSummaryOnce sync.Once
+	envSummary     string
+)
+
+func failuresDir() string {
+	return filepath.Join(gitmaxDir(), "failures")
+}
+
+func failurePath(job DirJob) string {
+	return filepath.Join(failuresDir(), job.owner()+"-"+job.RepoName+".txt")
+}
+
+// logJob appends to the transcript of the job working in dir, if any.
+func logJob(dir, text string) {
+	v, ok := activeJobs.Load(dir)
+	if !ok {
+		return
+	}
+	job := v.(*activeJob)
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.log.Len() > maxTranscript {
+		return
+	}
+	job.log.WriteString(text)
+	if job.log.Len() > maxTranscript {
+		job.log.WriteString("\n[transcript truncated]\n")
+	}
+}
+
+// logGit records a git command and everything it printed.
+func logGit(dir string, args []string, output string, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ git %s\n", strings.Join(args, " "))
+	if output = collapseProgress(output); output != "" {
+		b.WriteString(output + "\n")
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "→ %v\n", err)
+	}
+	logJob(dir, b.String()+"\n")
+}
+
+// collapseProgress keeps only the last of each run of progress lines,
+// like "Writing objects:  42% (42/100)", as a terminal would show them.
+func collapseProgress(output string) string {
+	var kept []string
+	prev := ""
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		phase, rest, ok := strings.Cut(line, ":")
+		if ok && strings.Contains(rest, "%") && phase == prev && len(kept) > 0 {
+			kept[len(kept)-1] = line
+			continue
+		}
+		if ok && strings.Contains(rest, "%") {
+			prev = phase
+		} else {
+			prev = ""
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// repoURLRe picks owner/name out of an API URL
+var repoURLRe = regexp.MustCompile(`/repos/([^/]+)/([^/?]+)`)
+
+// logAPIFailure records a failed API response with the jobs pushing to
+// the repo it concerns.
+func logAPIFailure(resp *http.Response, body []byte) {
+	if resp.Request == nil {
+		return
+	}
+	m := repoURLRe.FindStringSubmatch(resp.Request.URL.Path)
+	if m == nil {
+		return
+	}
+	text := fmt.Sprintf("API %s %s → %s\n%s\n\n", resp.Request.Method, resp.Request.URL, resp.Status,
+		strings.TrimSpace(string(body)))
+	activeJobs.Range(func(_, v interface{}) bool {
+		job := v.(*activeJob)
+		if strings.EqualFold(job.Repo, m[1]+"/"+m[2]) {
+			logJob(job.Path, text)
+		}
+		return true
+	})
+}
+
+// environmentSummary describes the machine and tools, for failure reports.
+func environmentSummary() string {
+	envSummaryOnce.Do(func() {
+		info := versionInfo()
+		var b strings.Builder
+		fmt.Fprintf(&b, "gitmax:    %s %s (%s, %s)\n", info.Version, info.Commit, info.GoVersion, info.Platform)
+		fmt.Fprintf(&b, "git:       %s (%s)\n", info.Git, gitBin)
+		if info.GH == "" {
+			info.GH = "not installed"
+		}
+		fmt.Fprintf(&b, "gh:        %s\n", info.GH)
+		fmt.Fprintf(&b, "host:      %s\n", hostname)
+		switch {
+		case ghToken != "":
+			fmt.Fprintf(&b, "auth:      %s token\n", tokenKind(ghToken))
+		case useGH:
+			b.WriteString("auth:      gh CLI\n")
+		default:
+			b.WriteString("auth:      none\n")
+		}
+		fmt.Fprintf(&b, "transport: %s (primary %s)\n", transportFlag, primaryTransport())
+		if userGitConfig {
+			b.WriteString("config:    user's global and system git config\n")
+		} else {
+			fmt.Fprintf(&b, "config:    %s\n", gitConfigPath())
+		}
+		envSummary = b.String()
+	})
+	return envSummary
+}
+
+// saveFailure writes the report for a failed job, or removes a stale one
+// after a success.
+func saveFailure(job DirJob, result Result, transcript string) {
+	path := failurePath(job)
+	if result.Success {
+		os.Remove(path)
+		return
+	}
+	if result.Skipped {
+		return
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "gitmax failure report\n\n")
+	fmt.Fprintf(&b, "Time:   %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Run:    %s\n", runID)
+	fmt.Fprintf(&b, "Path:   %s\n", job.Path)
+	fmt.Fprintf(&b, "Repo:   %s/%s (branch %s)\n", job.owner(), job.RepoName, job.branch())
+	fmt.Fprintf(&b, "Error:  %s\n\n", result.Message)
+	fmt.Fprintf(&b, "Environment\n%s\n", environmentSummary())
+	fmt.Fprintf(&b, "Transcript\n%s", transcript)
+
+	if err := os.MkdirAll(failuresDir(), 0755); err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(path, []byte(redact(b.String())), 0600); err != nil && verbose {
+		fmt.Printf("writing %s failed: %v\n", path, err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// For directories with hundreds of thousands of small files, git add and
+// commit spend most of their time rewriting the index, not hashing. With
+// -fast-import the snapshot commit is instead streamed to git fast-import,
+// which writes the objects and the commit in one pass without an index;
+// the index is then read back from the commit in one go so later steps
+// see the usual state. By default this kicks in from fastImportMinFiles.
+var fastImportMode = "auto"
+
+// fastImportMinFiles is the file count from which auto mode streams.
+const fastImportMinFiles = 100000
+
+func validFastImportMode(m string) bool {
+	return m == "auto" || m == "always" || m == "never"
+}
+
+// commitFastImport makes the snapshot commit with git fast-import. It
+// returns false, having done nothing, when the directory should go through
+// git add instead: below the auto threshold, with .gitattributes whose
+// filters or conversions only git add applies, or with nested repos or
+// paths fast-import can't name.
+func commitFastImport(job DirJob) (bool, error) {
+	if fastImportMode == "never" {
+		return false, nil
+	}
+	spec, err := stagePathspec(job.Path)
+	if err != nil {
+		return false, err
+	}
+	paths, err := lsFilesZ(job.Path, append([]string{"--others", "--exclude-standard", "--"}, spec...)...)
+	if err != nil {
+		return false, nil
+	}
+	if fastImportMode == "auto" && len(paths) < fastImportMinFiles {
+		return false, nil
+	}
+	if keep := existingAlwaysStaged(job.Path); len(keep) > 0 {
+		forced, err := lsFilesZ(job.Path, append([]string{"--others", "--"}, keep...)...)
+		if err != nil {
+			return false, nil
+		}
+		paths = append(paths, forced...)
+	}
+	seen := map[string]bool{}
+	unique := paths[:0]
+	for _, p := range paths {
+		if strings.HasSuffix(p, "/") || strings.ContainsAny(p, "\n\r") || strings.HasPrefix(p, `"`) ||
+			filepath.Base(p) == ".gitattributes" {
+			return false, nil
+		}
+		if !seen[p] {
+			seen[p] = true
+			unique = append(unique, p)
+		}
+	}
+	paths = unique
+	if recordAttrs {
+		if err := writeAttrsFile(job.Path, paths); err != nil {
+			return false, fmt.Errorf("recording file attributes failed: %v", err)
+		}
+		if !seen[AttrsFile] {
+			paths = append(paths, AttrsFile)
+		}
+	}
+	var renames map[string]string
+	if portableNames {
+		renames = portableRenames(paths)
+	}
+	names, err := namesBlob(renames)
+	if err != nil {
+		return false, err
+	}
+
+	args := []string{"fast-import", "--quiet", "--done"}
+	pr, pw := io.Pipe()
+	cmd := gitCommand(job.Path, args...)
+	var output bytes.Buffer
+	cmd.Stdin = pr
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	streamErr := make(chan error, 1)
+	go func() {
+		err := writeFastImport(pw, job, paths, renames, names)
+		pw.CloseWithError(err)
+		streamErr <- err
+	}()
+	err = runTracked(cmd)
+	pr.Close()
+	logGit(job.Path, args, output.String(), err)
+	if serr := <-streamErr; serr != nil && serr != io.ErrClosedPipe {
+		return true, fmt.Errorf("git fast-import failed: %v", serr)
+	}
+	if err != nil {
+		return true, fmt.Errorf("git fast-import failed: %v: %s", err, strings.TrimSpace(output.String()))
+	}
+	if err := runGit(job.Path, "read-tree", "HEAD"); err != nil {
+		return true, fmt.Errorf("git read-tree failed: %v", err)
+	}
+	return true, nil
+}
+
+// writeFastImport streams one commit on main holding paths with their
+// contents inline, under their renames if they have one, and NamesFile
+// when names isn't nil.
+func writeFastImport(w io.Writer, job DirJob, paths []string, renames map[string]string, names []byte) error {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	now := time.Now()
+	message := commitMessage(job)
+	fmt.Fprintf(bw, "commit refs/heads/main\n")
+	name, email := commitIdentity()
+	fmt.Fprintf(bw, "committer %s <%s> %d %s\n", name, email, now.Unix(), now.Format("-0700"))
+	fmt.Fprintf(bw, "data %d\n%s\n", len(message), message)
+	for _, p := range paths {
+		abs := filepath.Join(job.Path, filepath.FromSlash(p))
+		info, err := os.Lstat(abs)
+		if err != nil {
+			return err
+		}
+		mode := "100644"
+		var content []byte
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(abs)
+			if err != nil {
+				return err
+			}
+			mode, content = "120000", []byte(filepath.ToSlash(target))
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 && runtime.GOOS != "windows" {
+				mode = "100755"
+			}
+			if content, err = ioutil.ReadFile(abs); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if to, ok := renames[p]; ok {
+			p = to
+		}
+		fmt.Fprintf(bw, "M %s inline %s\ndata %d\n", mode, p, len(content))
+		bw.Write(content)
+		bw.WriteString("\n")
+	}
+	if names != nil {
+		fmt.Fprintf(bw, "M 100644 inline %s\ndata %d\n", NamesFile, len(names))
+		bw.Write(names)
+		bw.WriteString("\n")
+	}
+	bw.WriteString("\ndone\n")
+	return bw.Flush()
+}
+
+// lsFilesZ runs git ls-files -z and splits its output untrimmed.
+func lsFilesZ(dir string, args ...string) ([]string, error) {
+	args = append([]string{"ls-files", "-z"}, args...)
+	cmd := gitCommand(dir, args...)
+	var output, stderr bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+	err := runTracked(cmd)
+	logGit(dir, args, stderr.String(), err)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range strings.Split(output.String(), "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
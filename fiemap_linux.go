@@ -0,0 +1,57 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fsIocFiemap        = 0xC020660B
+	fiemapExtentLast   = 0x1
+	fiemapExtentShared = 0x2000
+	fiemapMaxExtents   = 64
+	fiemapHeaderSize   = 32
+	fiemapExtentSize   = 56
+)
+
+// fileExtents asks the filesystem for the file's extent map so reflinked
+// clones (btrfs, XFS) can be recognized by their shared extents. Files
+// with more extents than one request returns are counted by size.
+func fileExtents(path string) ([]extent, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	buf := make([]byte, fiemapHeaderSize+fiemapMaxExtents*fiemapExtentSize)
+	binary.NativeEndian.PutUint64(buf[8:], ^uint64(0)) // fm_length: whole file
+	binary.NativeEndian.PutUint32(buf[24:], fiemapMaxExtents)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFiemap, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return nil, false
+	}
+
+	mapped := binary.NativeEndian.Uint32(buf[20:])
+	var exts []extent
+	last := false
+	for i := 0; i < int(mapped); i++ {
+		e := buf[fiemapHeaderSize+i*fiemapExtentSize:]
+		flags := binary.NativeEndian.Uint32(e[40:])
+		exts = append(exts, extent{
+			physical: binary.NativeEndian.Uint64(e[8:]),
+			length:   binary.NativeEndian.Uint64(e[16:]),
+			shared:   flags&fiemapExtentShared != 0,
+		})
+		last = flags&fiemapExtentLast != 0
+	}
+	if mapped > 0 && !last {
+		return nil, false
+	}
+	return exts, true
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func fileExtents(path string) ([]extent, bool) {
+	return nil, false
+}
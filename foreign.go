@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A directory that is a clone of someone else's repo, a fork or a
+// third-party project, is skipped instead of being pushed to a new repo
+// of ours, because the snapshot would replace its git history. -rehost
+// pushes such directories like any other.
+var rehost bool
+
+// foreignOrigin returns the origin URL of the directory's existing repo
+// when it points anywhere other than the job's GitHub owner, or "".
+func foreignOrigin(job DirJob) string {
+	// Without its own .git, git would report the enclosing repo's origin
+	if _, err := os.Stat(filepath.Join(job.Path, ".git")); err != nil {
+		return ""
+	}
+	origin, err := runGitOutput(job.Path, "remote", "get-url", "origin")
+	if err != nil || origin == "" {
+		return ""
+	}
+	if owner, _, ok := githubRepoPath(origin); ok && strings.EqualFold(owner, job.owner()) {
+		return ""
+	}
+	return origin
+}
+
+// githubRepoPath returns the owner and name of a github.com remote URL.
+func githubRepoPath(remote string) (string, string, bool) {
+	if remoteHost(remote) != "github.com" {
+		return "", "", false
+	}
+	var path string
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", false
+		}
+		path = u.Path
+	} else {
+		_, path, _ = strings.Cut(remote, ":")
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	owner, name, ok := strings.Cut(path, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	return owner, name, true
+}
+
+// printForeignOrigins lists the directories skipped for cloning someone
+// else's repo.
+func printForeignOrigins() {
+	var skipped []Result
+	for _, r := range results {
+		if r.Foreign != "" {
+			skipped = append(skipped, r)
+		}
+	}
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf(T("\n⚠ %d clone(s) of other repos skipped; pass -rehost to push your own copies:\n"), len(skipped))
+	for _, r := range skipped {
+		fmt.Printf("   %s (origin %s)\n", r.Path, redact(r.Foreign))
+	}
+}
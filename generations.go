@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// keepGenerations limits how many snapshots -merge-remote keeps on main;
+// 0 keeps all of them.
+var keepGenerations int
+
+// snapshotChain returns up to limit snapshot commits starting at HEAD,
+// newest first. Each merge made by mergeRemoteMain has the previous
+// remote state as its last parent, so following last parents walks one
+// snapshot per step.
+func snapshotChain(dir string, limit int) ([]string, error) {
+	var chain []string
+	sha, err := runGitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	for sha != "" && len(chain) < limit {
+		chain = append(chain, sha)
+		parents, err := runGitOutput(dir, "show", "-s", "--format=%P", sha)
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(parents)
+		sha = ""
+		if len(fields) > 0 {
+			sha = fields[len(fields)-1]
+		}
+	}
+	return chain, nil
+}
+
+// trimGenerations rewrites main so it holds only the newest keep
+// snapshots: the oldest kept one becomes a root commit and the rest are
+// replayed on top with their original messages and dates. It reports
+// whether history was rewritten, which makes the following push a force
+// push.
+func trimGenerations(dir string, keep int) (bool, error) {
+	chain, err := snapshotChain(dir, keep+1)
+	if err != nil || len(chain) <= keep {
+		return false, err
+	}
+
+	parent := ""
+	for i := keep - 1; i >= 0; i-- {
+		sha, err := replayCommit(dir, chain[i], parent, i == keep-1)
+		if err != nil {
+			return false, err
+		}
+		parent = sha
+	}
+	return true, runGit(dir, "update-ref", "refs/heads/main", parent)
+}
+
+// replayCommit creates a commit with the tree, message and dates of sha on
+// top of parent (none for the new root).
+func replayCommit(dir, sha, parent string, root bool) (string, error) {
+	info, err := runGitOutput(dir, "show", "-s", "--format=%aI%x00%cI%x00%B", sha)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(info, "\x00", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unexpected commit format for %s", sha)
+	}
+	message := parts[2]
+	if root {
+		message = "Squashed older snapshots\n\n" + message
+	}
+
+	args := []string{"commit-tree", sha + "^{tree}", "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	cmd := gitCommand(dir, args...)
+	cmd.Env = append(cmd.Env, "GIT_AUTHOR_DATE="+parts[0], "GIT_COMMITTER_DATE="+parts[1])
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := runTracked(cmd); err != nil {
+		return "", fmt.Errorf("commit-tree: %v", err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// Without a token, an authenticated gh CLI stands in for one: every API
+// call goes through gh api and git authenticates with gh's credential
+// helper, so the run behaves as it would with gh's token. gh api -i
+// prints the raw HTTP response, which is parsed back into an
+// http.Response, so callers see GitHub's real status codes and errors
+// either way.
+var useGH bool
+
+// detectGH reports whether gh is installed and logged in to github.com,
+// and why not otherwise.
+func detectGH() (bool, string) {
+	if _, err := exec.LookPath("gh"); err != nil {
+		return false, "gh is not installed"
+	}
+	out, err := exec.Command("gh", "auth", "status", "--hostname", "github.com").CombinedOutput()
+	if err != nil {
+		return false, "gh is not logged in: " + firstLine(string(out))
+	}
+	return true, ""
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// hasAPI reports whether gitmax can call the GitHub API, with a token or
+// through gh.
+func hasAPI() bool {
+	return ghToken != "" || useGH
+}
+
+// ghDo sends req through gh api.
+func ghDo(req *http.Request) (*http.Response, error) {
+	endpoint := strings.TrimPrefix(req.URL.String(), githubAPI+"/")
+	args := []string{"api", "--include", "--method", req.Method, endpoint}
+	for _, name := range []string{"Accept", "Content-Type", "If-None-Match"} {
+		if v := req.Header.Get(name); v != "" {
+			args = append(args, "--header", name+": "+v)
+		}
+	}
+	cmd := exec.Command("gh", args...)
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Args = append(cmd.Args, "--input", "-")
+		cmd.Stdin = bytes.NewReader(body)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// gh exits non-zero on HTTP errors but still prints the response
+	runErr := cmd.Run()
+	resp, err := http.ReadResponse(bufio.NewReader(&stdout), req)
+	if err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("gh api: %v: %s", runErr, firstLine(stderr.String()))
+		}
+		return nil, fmt.Errorf("gh api: unreadable response: %v", err)
+	}
+	return resp, nil
+}
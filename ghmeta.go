@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// With -export-meta, pushing into a repo that already exists on GitHub
+// also saves what lives there outside git: issues (pull requests
+// included, as the API lists them), labels and releases are fetched
+// through the API and committed to GitHubMetaDir alongside the snapshot.
+const GitHubMetaDir = ".github-meta"
+
+var exportMeta bool
+
+// githubMetaLists are the exported lists, each saved as <name>.json
+var githubMetaLists = []struct{ name, path string }{
+	{"issues", "issues?state=all&per_page=100"},
+	{"labels", "labels?per_page=100"},
+	{"releases", "releases?per_page=100"},
+}
+
+// writeGitHubMeta exports the metadata of the job's repo into the
+// directory before staging. Repos that don't exist yet have none.
+func writeGitHubMeta(job DirJob) error {
+	if !exportMeta || !hasAPI() {
+		return nil
+	}
+	exists, err := repoExists(job.owner(), job.RepoName)
+	if err != nil || !exists {
+		return err
+	}
+
+	dir := filepath.Join(job.Path, GitHubMetaDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, list := range githubMetaLists {
+		url := fmt.Sprintf("%s/repos/%s/%s/%s", githubAPI, job.owner(), job.RepoName, list.path)
+		items, err := fetchAllPages(list.name, url)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, list.name+".json"), append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchAllPages collects every item of a paginated list. A feature that's
+// turned off on the repo (HTTP 410, e.g. disabled issues) is an empty list.
+func fetchAllPages(op, url string) ([]json.RawMessage, error) {
+	all := []json.RawMessage{}
+	for url != "" {
+		resp, err := githubRequest("GET", url, nil)
+		if err != nil {
+			return nil, transportError(op+" export", err)
+		}
+		if resp.StatusCode == 410 {
+			resp.Body.Close()
+			return all, nil
+		}
+		if resp.StatusCode != 200 {
+			err := responseError(op+" export", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var page []json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		url = nextPageURL(resp.Header.Get("Link"))
+	}
+	return all, nil
+}
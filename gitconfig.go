@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// gitmax's git commands don't read the user's global or system git config:
+// hooks, credential helpers, filters and aliases set up there for
+// interactive use can stall or rewrite thousands of unattended commits,
+// and a bulk run must never write to them either. GIT_CONFIG_GLOBAL points
+// at gitmax's own file, created with the defaults below on first use and
+// free to edit afterwards, and GIT_CONFIG_SYSTEM at the null device.
+// -user-git-config restores git's normal lookup.
+var (
+	gitBin        = "git"
+	userGitConfig bool
+
+	gitEnvOnce sync.Once
+	gitEnv     []string
+)
+
+const defaultGitConfig = `# Global git config for gitmax's git commands; your ~/.gitconfig and the
+# system config are not read. gitmax creates this file but never rewrites
+# it, so edits here stick.
+[init]
+	defaultBranch = main
+[core]
+	autocrlf = false
+`
+
+// gitConfigPath is the config file gitmax's git commands read as global.
+func gitConfigPath() string {
+	return filepath.Join(gitmaxDir(), "gitconfig")
+}
+
+// addGitFlags registers the flags choosing how git runs.
+func addGitFlags(fs *flag.FlagSet) {
+	fs.StringVar(&gitBin, "git-bin", gitBin, "git executable to run")
+	fs.BoolVar(&userGitConfig, "user-git-config", false, "Let git read your global and system config")
+}
+
+// isolatedGitEnv returns the variables isolating git from the user's config,
+// creating gitmax's config file if needed. Without it git still runs
+// isolated, just without the defaults.
+func isolatedGitEnv() []string {
+	gitEnvOnce.Do(func() {
+		if userGitConfig {
+			return
+		}
+		global := gitConfigPath()
+		if _, err := os.Stat(global); os.IsNotExist(err) {
+			err = os.MkdirAll(filepath.Dir(global), 0755)
+			if err == nil {
+				err = ioutil.WriteFile(global, []byte(defaultGitConfig), 0644)
+			}
+			if err != nil {
+				fmt.Printf("⚠ Could not create %s: %v\n", global, err)
+				global = os.DevNull
+			}
+		}
+		gitEnv = []string{"GIT_CONFIG_GLOBAL=" + global, "GIT_CONFIG_SYSTEM=" + os.DevNull}
+	})
+	return gitEnv
+}
+
+// gitCommand builds a git invocation that never prompts, only sees
+// gitmax's git config and authenticates to GitHub with gitmax's token.
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	credArgs, credEnv := credentialArgs()
+	cmd := exec.Command(gitBin, append(credArgs, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), isolatedGitEnv()...)
+	cmd.Env = append(cmd.Env, credEnv...)
+	if runID != "" {
+		// Lets hooks tag their own logs with the run
+		cmd.Env = append(cmd.Env, "GITMAX_RUN_ID="+runID)
+	}
+	return cmd
+}
@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const githubAPI = "https://api.github.com"
+
+var (
+	apiClient        = &http.Client{Timeout: 10 * time.Second}
+	fineGrainedToken bool
+	createLimiter    = newRateLimiter(1)
+
+	// managedTopic marks repos created by gitmax so later commands can tell
+	// them from hand-made repos. Empty disables marking.
+	managedTopic = "gitmax"
+)
+
+// rateLimiter is a token bucket pacing content-generating requests, as
+// GitHub's secondary rate limit guidance asks for.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second; 0 disables pacing
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{rate: perSecond, tokens: 1, last: time.Now()}
+}
+
+// Wait blocks until a token is available.
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		time.Sleep(wait)
+		l.last = time.Now()
+		l.tokens = 1
+	}
+	l.tokens--
+}
+
+func githubRequest(method, url string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var resp *http.Response
+	if method == "GET" {
+		resp, err = cachedGet(req)
+	} else {
+		resp, err = githubDo(req)
+	}
+	if err == nil && resp.StatusCode >= 500 {
+		outage.noteServerError()
+	}
+	return resp, err
+}
+
+// githubDo sends an API request with the token, or through gh without one.
+func githubDo(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	defer func() { recordAPI(req.URL.Path, time.Since(start)) }()
+	if useGH && ghToken == "" {
+		return ghDo(req)
+	}
+	req.Header.Set("Authorization", "token "+ghToken)
+	return apiClient.Do(req)
+}
+
+// APIError is a failed GitHub API call, carrying what GitHub said about it
+type APIError struct {
+	Op         string // What was attempted, e.g. "repo creation"
+	StatusCode int    // 0 when the request never got a response
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s failed: %s", e.Op, e.Message)
+	}
+	return fmt.Sprintf("%s rejected: %s (HTTP %d)", e.Op, e.Message, e.StatusCode)
+}
+
+func transportError(op string, err error) *APIError {
+	return &APIError{Op: op, Message: err.Error()}
+}
+
+// responseError builds an APIError from a non-2xx response.
+func responseError(op string, resp *http.Response) *APIError {
+	msg := apiMessage(resp)
+	switch resp.StatusCode {
+	case 401:
+		msg = "bad credentials: token is invalid or expired"
+	case 403:
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			msg = "API rate limit exceeded"
+		}
+	}
+	return &APIError{Op: op, StatusCode: resp.StatusCode, Message: msg}
+}
+
+// tokenKind classifies a token by its documented prefix.
+func tokenKind(token string) string {
+	switch {
+	case strings.HasPrefix(token, "github_pat_"):
+		return "fine-grained"
+	case strings.HasPrefix(token, "ghp_"):
+		return "classic"
+	case strings.HasPrefix(token, "gho_"):
+		return "oauth"
+	default:
+		return "unknown"
+	}
+}
+
+// githubUser is the account repos are created under and commits are made
+// as: -user, or else the login the token belongs to.
+var githubUser string
+
+// requireUser fails when neither -user nor the token named the account.
+func requireUser() error {
+	if githubUser == "" {
+		return errors.New("could not tell which GitHub account to use; pass -user")
+	}
+	return nil
+}
+
+// commitIdentity is the name and email commits are made with. Runs that
+// never reach GitHub (-export-bundles, a dry run without a token) may not
+// know the account and commit as gitmax on this host instead.
+func commitIdentity() (string, string) {
+	if githubUser != "" {
+		return githubUser, githubUser + "@users.noreply.github.com"
+	}
+	host := hostname
+	if host == "" {
+		host = "localhost"
+	}
+	return "gitmax", "gitmax@" + host
+}
+
+// checkTokenAccess validates the token before any work starts and prints
+// guidance for the permissions gitmax needs. Without -user, the token's
+// login becomes githubUser.
+func checkTokenAccess() error {
+	fineGrainedToken = tokenKind(ghToken) == "fine-grained"
+
+	resp, err := githubRequest("GET", githubAPI+"/user", nil)
+	if err != nil {
+		// Network problems surface per directory later
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		return fmt.Errorf("GitHub token rejected (401): token is invalid or expired")
+	}
+	var who struct {
+		Login string `json:"login"`
+	}
+	if githubUser == "" && resp.StatusCode == 200 && json.NewDecoder(resp.Body).Decode(&who) == nil {
+		githubUser = who.Login
+	}
+
+	if fineGrainedToken {
+		fmt.Println("ℹ Fine-grained token detected. It needs:")
+		fmt.Println("  - Repository access: All repositories (new repos are not in a selected list)")
+		fmt.Println("  - Administration: read and write (to create repos)")
+		fmt.Println("  - Contents: read and write (to push)")
+		return nil
+	}
+
+	// Classic tokens advertise their scopes
+	if scopes := resp.Header.Get("X-OAuth-Scopes"); scopes != "" {
+		if !strings.Contains(scopes, "repo") {
+			fmt.Printf("⚠ Warning: token scopes (%s) lack 'repo'/'public_repo'; pushes may fail\n", scopes)
+		}
+	}
+	return nil
+}
+
+// verifyPushAccess confirms the token can push to the repo. Fine-grained
+// tokens limited to selected repositories can create a repo they are then
+// unable to see or push to.
+func verifyPushAccess(owner, repoName string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s", githubAPI, owner, repoName)
+	resp, err := githubRequest("GET", url, nil)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 || resp.StatusCode == 403 {
+		return fmt.Errorf("token cannot access %s/%s: fine-grained token is likely limited to selected repositories; grant 'All repositories'%s",
+			owner, repoName, acceptedPermissions(resp))
+	}
+
+	var repo struct {
+		Permissions struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&repo); err != nil {
+		return nil
+	}
+	if !repo.Permissions.Push {
+		return fmt.Errorf("token can create %s/%s but not push to it: grant 'Contents: read and write'%s",
+			owner, repoName, acceptedPermissions(resp))
+	}
+	return nil
+}
+
+// acceptedPermissions formats the permissions GitHub says the request needed.
+func acceptedPermissions(resp *http.Response) string {
+	if p := resp.Header.Get("X-Accepted-GitHub-Permissions"); p != "" {
+		return " (required: " + p + ")"
+	}
+	return ""
+}
+
+// repoExists looks the repo up without changing anything.
+func repoExists(owner, repoName string) (bool, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s", githubAPI, owner, repoName)
+	resp, err := githubRequest("GET", url, nil)
+	if err != nil {
+		return false, transportError("repo lookup", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, responseError("repo lookup", resp)
+	}
+}
+
+// validRepoName applies GitHub's repository naming rules.
+func validRepoName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid repo name %q", name)
+	}
+	if len(name) > 100 {
+		return fmt.Errorf("repo name %q is longer than 100 characters", name)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("repo name %q contains %q", name, c)
+		}
+	}
+	return nil
+}
+
+// createRepoRequest is the body of POST /user/repos
+type createRepoRequest struct {
+	Name              string `json:"name"`
+	Description       string `json:"description,omitempty"`
+	Homepage          string `json:"homepage,omitempty"`
+	Private           bool   `json:"private"`
+	AutoInit          bool   `json:"auto_init"`
+	HasIssues         *bool  `json:"has_issues,omitempty"`
+	HasWiki           *bool  `json:"has_wiki,omitempty"`
+	HasProjects       *bool  `json:"has_projects,omitempty"`
+	IsTemplate        bool   `json:"is_template,omitempty"`
+	TeamID            int64  `json:"team_id,omitempty"`
+	GitignoreTemplate string `json:"gitignore_template,omitempty"`
+	LicenseTemplate   string `json:"license_template,omitempty"`
+}
+
+// newCreateRepoRequest fills the creation payload from config.
+func newCreateRepoRequest(job DirJob) createRepoRequest {
+	rc := config.Repo
+	req := createRepoRequest{
+		Name:              job.RepoName,
+		Description:       rc.Description,
+		Homepage:          rc.Homepage,
+		Private:           rc.Private,
+		AutoInit:          rc.AutoInit,
+		HasIssues:         rc.HasIssues,
+		HasWiki:           rc.HasWiki,
+		HasProjects:       rc.HasProjects,
+		IsTemplate:        rc.IsTemplate,
+		TeamID:            rc.TeamID,
+		GitignoreTemplate: rc.GitignoreTemplate,
+		LicenseTemplate:   rc.LicenseTemplate,
+	}
+	if rule, ok := visibilityRule(job.Path); ok {
+		req.Private = rule.Visibility == "private"
+	}
+	if job.Private != nil {
+		req.Private = *job.Private
+	}
+	if pushWikis && wikiSource(job.Path) != "" {
+		hasWiki := true
+		req.HasWiki = &hasWiki
+	}
+	if req.Description == "" {
+		req.Description = repoDescription(job)
+	}
+	return req
+}
+
+// ensureGitHubRepo creates the repo if it doesn't exist and reports
+// whether it was created by this call.
+func ensureGitHubRepo(job DirJob) (bool, error) {
+	repoName := job.RepoName
+	if !hasAPI() {
+		return false, fmt.Errorf("no GitHub token and no logged-in gh CLI; can't create %s", repoName)
+	}
+
+	// Check if repo exists
+	exists, err := repoExists(job.owner(), repoName)
+	if err != nil || exists {
+		return false, err
+	}
+
+	// Only a few creations at a time, whatever the worker count
+	waitStart := time.Now()
+	createSem <- struct{}{}
+	defer func() { <-createSem }()
+	createLimiter.Wait()
+	recordStage(job.Path, stageWait, time.Since(waitStart))
+
+	if config.Repo.Template != "" {
+		if err := generateFromTemplate(job); err != nil {
+			return false, err
+		}
+		markRepo(job.owner(), repoName)
+		return true, nil
+	}
+
+	// Create repo
+	body, err := json.Marshal(newCreateRepoRequest(job))
+	if err != nil {
+		return false, err
+	}
+	createURL := githubAPI + "/user/repos"
+	if job.Owner != "" {
+		createURL = fmt.Sprintf("%s/orgs/%s/repos", githubAPI, job.Owner)
+	}
+	resp, err := githubRequest("POST", createURL, bytes.NewReader(body))
+	if err != nil {
+		return false, transportError("repo creation", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, responseError("repo creation", resp)
+	}
+
+	markRepo(job.owner(), repoName)
+	return true, nil
+}
+
+// generateFromTemplate creates the repo from config.Repo.Template and waits
+// for GitHub to finish copying the template's content, which happens
+// asynchronously.
+func generateFromTemplate(job DirJob) error {
+	req := newCreateRepoRequest(job)
+	body := map[string]interface{}{
+		"owner":       job.owner(),
+		"name":        job.RepoName,
+		"description": req.Description,
+		"private":     req.Private,
+	}
+	url := fmt.Sprintf("%s/repos/%s/generate", githubAPI, config.Repo.Template)
+	var created struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := apiSend("POST", url, body, &created); err != nil {
+		if apiErr, ok := err.(*APIError); ok {
+			apiErr.Op = "repo generation from " + config.Repo.Template
+		}
+		return err
+	}
+	branch := created.DefaultBranch
+	if branch == "" {
+		branch = "main"
+	}
+
+	// Merging into or pushing over a repo GitHub is still filling would
+	// race the copy, so a copy that doesn't show up fails the directory
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, job.owner(), job.RepoName)
+	for i := 0; i < 20; i++ {
+		if sha, _ := apiBranchSHA(repoAPI, branch); sha != "" {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("%s/%s was generated from %s, but its content didn't appear within 10s",
+		job.owner(), job.RepoName, config.Repo.Template)
+}
+
+// markRepo tags a newly created repo with the gitmax marker topic. A failed
+// marker doesn't fail the push.
+func markRepo(owner, repoName string) {
+	if managedTopic == "" {
+		return
+	}
+	if err := addRepoTopics(owner, repoName, managedTopic); err != nil && verbose {
+		fmt.Printf("marking %s failed: %v\n", repoName, err)
+	}
+}
+
+// repoTopics returns the repo's topics.
+func repoTopics(owner, repoName string) ([]string, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/topics", githubAPI, owner, repoName)
+	resp, err := githubRequest("GET", url, nil)
+	if err != nil {
+		return nil, transportError("topic lookup", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, responseError("topic lookup", resp)
+	}
+
+	var body struct {
+		Names []string `json:"names"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Names, nil
+}
+
+// addRepoTopics merges topics into the repo's existing ones.
+func addRepoTopics(owner, repoName string, topics ...string) error {
+	existing, err := repoTopics(owner, repoName)
+	if err != nil {
+		return err
+	}
+	names := existing
+	for _, t := range topics {
+		if !containsString(names, t) {
+			names = append(names, t)
+		}
+	}
+	if len(names) == len(existing) {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string][]string{"names": names})
+	url := fmt.Sprintf("%s/repos/%s/%s/topics", githubAPI, owner, repoName)
+	resp, err := githubRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return transportError("topic update", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return responseError("topic update", resp)
+	}
+	return nil
+}
+
+// isManagedRepo reports whether the repo carries the gitmax marker topic.
+func isManagedRepo(owner, repoName string) (bool, error) {
+	if managedTopic == "" {
+		return false, nil
+	}
+	topics, err := repoTopics(owner, repoName)
+	if err != nil {
+		return false, err
+	}
+	return containsString(topics, managedTopic), nil
+}
+
+// validTopic applies GitHub's topic rules: lowercase letters, digits and
+// hyphens, starting with a letter or digit, at most 50 characters.
+func validTopic(topic string) error {
+	if topic == "" || len(topic) > 50 || topic[0] == '-' {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	for _, c := range topic {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("invalid topic %q: only lowercase letters, digits and hyphens", topic)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteRepo is the subset of the repository object gitmax uses
+type remoteRepo struct {
+	Name        string    `json:"name"`
+	FullName    string    `json:"full_name"`
+	CloneURL    string    `json:"clone_url"`
+	HTMLURL     string    `json:"html_url"`
+	Private     bool      `json:"private"`
+	Description string    `json:"description"`
+	Topics      []string  `json:"topics"`
+	Size        int64     `json:"size"` // KB
+	PushedAt    time.Time `json:"pushed_at"`
+	Fork        bool      `json:"fork"`
+	Archived    bool      `json:"archived"`
+}
+
+// listUserRepos pages through every repo the authenticated user owns.
+func listUserRepos() ([]remoteRepo, error) {
+	return listRepos(githubAPI + "/user/repos?per_page=100&affiliation=owner")
+}
+
+// listOrgRepos pages through every repo of an organization.
+func listOrgRepos(org string) ([]remoteRepo, error) {
+	return listRepos(fmt.Sprintf("%s/orgs/%s/repos?per_page=100&type=all", githubAPI, org))
+}
+
+func listRepos(url string) ([]remoteRepo, error) {
+	var all []remoteRepo
+	for url != "" {
+		resp, err := githubRequest("GET", url, nil)
+		if err != nil {
+			return nil, transportError("repo listing", err)
+		}
+		if resp.StatusCode != 200 {
+			err := responseError("repo listing", resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var page []remoteRepo
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		url = nextPageURL(resp.Header.Get("Link"))
+	}
+	return all, nil
+}
+
+// nextPageURL extracts rel="next" from a Link header.
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		segs := strings.Split(part, ";")
+		if len(segs) < 2 || strings.TrimSpace(segs[1]) != `rel="next"` {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(segs[0]), "<>")
+	}
+	return ""
+}
+
+// fetchRepoFile returns a file's raw content from the default branch, or
+// nil when it doesn't exist.
+func fetchRepoFile(owner, repoName, path string) ([]byte, error) {
+	return fetchRepoFileAt(owner, repoName, path, "")
+}
+
+// fetchRepoFileAt is fetchRepoFile on a given branch, "" for the default.
+func fetchRepoFileAt(owner, repoName, path, ref string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/contents/%s", githubAPI, owner, repoName, path)
+	if ref != "" {
+		endpoint += "?ref=" + url.QueryEscape(ref)
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.raw")
+	resp, err := githubDo(req)
+	if err != nil {
+		return nil, transportError("file fetch", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, responseError("file fetch", resp)
+	}
+	return io.ReadAll(resp.Body)
+}
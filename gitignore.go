@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// minIgnoreGroup is how many excluded files it takes to write one rule for
+// a directory or an extension instead of one per file
+const minIgnoreGroup = 2
+
+// ignoreTally counts files per directory while createGitignore walks, to
+// tell which directories and extensions hold only excluded files.
+type ignoreTally struct {
+	tree  map[string][2]int // Directory → files below it, excluded ones
+	byExt map[string][2]int // Directory + "\x00" + extension → direct files, excluded ones
+}
+
+func newIgnoreTally() *ignoreTally {
+	return &ignoreTally{tree: map[string][2]int{}, byExt: map[string][2]int{}}
+}
+
+// add counts a file, given as a slash path relative to the directory.
+func (t *ignoreTally) add(rel string, excluded bool) {
+	n := 0
+	if excluded {
+		n = 1
+	}
+	dir := path.Dir(rel)
+	if ext := path.Ext(rel); ext != "" {
+		c := t.byExt[dir+"\x00"+ext]
+		t.byExt[dir+"\x00"+ext] = [2]int{c[0] + 1, c[1] + n}
+	}
+	for ; dir != "."; dir = path.Dir(dir) {
+		c := t.tree[dir]
+		t.tree[dir] = [2]int{c[0] + 1, c[1] + n}
+	}
+}
+
+// rules collapses the excluded files into as few .gitignore rules as
+// exclude exactly them: the highest directory holding nothing else, then
+// dir/*.ext when every file of that extension there is excluded, then the
+// files left one by one. Rules are anchored to the directory.
+func (t *ignoreTally) rules(excluded []string) []string {
+	seen := map[string]bool{}
+	var rules []string
+	add := func(rule string) {
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	for _, rel := range excluded {
+		if dir := t.excludedDir(rel); dir != "" {
+			add("/" + escapeIgnore(dir) + "/")
+			continue
+		}
+		dir, ext := path.Dir(rel), path.Ext(rel)
+		if c := t.byExt[dir+"\x00"+ext]; ext != "" && c[1] >= minIgnoreGroup && c[0] == c[1] {
+			prefix := "/"
+			if dir != "." {
+				prefix += escapeIgnore(dir) + "/"
+			}
+			add(prefix + "*" + escapeIgnore(ext))
+			continue
+		}
+		add("/" + escapeIgnore(rel))
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// excludedDir returns the highest directory above rel whose files are all
+// excluded, or "".
+func (t *ignoreTally) excludedDir(rel string) string {
+	parts := strings.Split(path.Dir(rel), "/")
+	if parts[0] == "." {
+		return ""
+	}
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		if c := t.tree[dir]; c[1] >= minIgnoreGroup && c[0] == c[1] {
+			return dir
+		}
+	}
+	return ""
+}
+
+// escapeIgnore quotes the characters .gitignore patterns treat specially.
+func escapeIgnore(p string) string {
+	var b strings.Builder
+	for i, r := range p {
+		switch {
+		case r == '*' || r == '?' || r == '[' || r == '\\':
+			b.WriteByte('\\')
+		case i == 0 && (r == '#' || r == '!'):
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	if s := b.String(); strings.HasSuffix(s, " ") {
+		return strings.TrimRight(s, " ") + strings.Repeat(`\ `, len(s)-len(strings.TrimRight(s, " ")))
+	}
+	return b.String()
+}
+
+// newIgnoreRules drops rules the .gitignore already has word for word,
+// with or without the leading slash older versions didn't write.
+func newIgnoreRules(existing string, rules []string) []string {
+	have := map[string]bool{}
+	for _, line := range strings.Split(existing, "\n") {
+		have[strings.TrimSpace(line)] = true
+	}
+	var out []string
+	for _, r := range rules {
+		if !have[r] && !have[strings.TrimPrefix(r, "/")] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ignoredPaths asks git, in one call, which paths the directory's own
+// ignore rules already exclude: files, and directories with a trailing
+// slash, relative and with forward slashes. The large-file scan skips
+// them, so gitmax's section doesn't repeat the user's rules and ignored
+// trees like node_modules aren't walked. gitmax's previous section is
+// taken out of the .gitignore first so only the user's rules count. It
+// returns nil when git can't tell.
+func ignoredPaths(dir string) map[string]bool {
+	gitignorePath := filepath.Join(dir, ".gitignore")
+	if data, err := ioutil.ReadFile(gitignorePath); err == nil {
+		if stripped, changed := stripGitmaxSections(string(data)); changed {
+			ioutil.WriteFile(gitignorePath, []byte(stripped), 0644)
+		}
+	}
+
+	out, err := runGitOutput(dir, "ls-files", "--others", "--ignored", "--exclude-standard", "--directory", "-z")
+	if err != nil {
+		return nil
+	}
+	ignored := map[string]bool{}
+	for _, p := range strings.Split(out, "\x00") {
+		if p != "" {
+			ignored[p] = true
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Every real run appends its totals to ~/.gitmax/history.csv, one row per
+// run, for gitmax stats and for spreadsheets.
+var historyHeader = []string{"date", "run_id", "dirs", "success", "failed", "skipped", "bytes_pushed", "duration_seconds"}
+
+// HistoryRow is one run's line in the history
+type HistoryRow struct {
+	Date     time.Time
+	RunID    string
+	Dirs     int64
+	Success  int64
+	Failed   int64
+	Skipped  int64
+	Bytes    int64
+	Duration time.Duration
+}
+
+func historyPath() string {
+	return filepath.Join(gitmaxDir(), "history.csv")
+}
+
+// appendHistory adds this run's totals to the history.
+func appendHistory() error {
+	path := historyPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if os.IsNotExist(statErr) {
+		w.Write(historyHeader)
+	}
+	w.Write([]string{
+		stats.StartTime.UTC().Format(time.RFC3339),
+		runID,
+		strconv.FormatInt(stats.Total, 10),
+		strconv.FormatInt(atomic.LoadInt64(&stats.Success), 10),
+		strconv.FormatInt(atomic.LoadInt64(&stats.Failed), 10),
+		strconv.FormatInt(atomic.LoadInt64(&stats.Skipped), 10),
+		strconv.FormatInt(atomic.LoadInt64(&stats.BytesPushed), 10),
+		strconv.FormatFloat(time.Since(stats.StartTime).Seconds(), 'f', 1, 64),
+	})
+	w.Flush()
+	return w.Error()
+}
+
+// readHistory returns the recorded runs, oldest first. Malformed rows are
+// skipped so a hand-edited file still loads.
+func readHistory() ([]HistoryRow, error) {
+	f, err := os.Open(historyPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rows []HistoryRow
+	for _, rec := range records {
+		if len(rec) < len(historyHeader) || rec[0] == historyHeader[0] {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, rec[0])
+		if err != nil {
+			continue
+		}
+		row := HistoryRow{Date: date, RunID: rec[1]}
+		ints := []*int64{&row.Dirs, &row.Success, &row.Failed, &row.Skipped, &row.Bytes}
+		ok := true
+		for i, p := range ints {
+			if *p, err = strconv.ParseInt(rec[2+i], 10, 64); err != nil {
+				ok = false
+			}
+		}
+		seconds, err := strconv.ParseFloat(rec[7], 64)
+		if !ok || err != nil {
+			continue
+		}
+		row.Duration = time.Duration(seconds * float64(time.Second))
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// perDir is the average wall time per processed directory.
+func (r HistoryRow) perDir() time.Duration {
+	if r.Dirs == 0 {
+		return 0
+	}
+	return r.Duration / time.Duration(r.Dirs)
+}
+
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	n := fs.Int("n", 10, "Number of recent runs to show")
+	fs.Parse(args)
+
+	rows, err := readHistory()
+	if err != nil {
+		fmt.Printf("✗ Could not read %s: %v\n", historyPath(), err)
+		return 1
+	}
+	if len(rows) == 0 {
+		fmt.Println("No runs recorded yet")
+		return 0
+	}
+
+	// The running total covers every recorded run, not just the ones shown
+	cumulative := make([]int64, len(rows))
+	var sum int64
+	for i, r := range rows {
+		sum += r.Bytes
+		cumulative[i] = sum
+	}
+	first := 0
+	if *n > 0 && len(rows) > *n {
+		first = len(rows) - *n
+	}
+
+	fmt.Printf("%-16s %6s %6s %6s %12s %14s %9s %8s\n", "DATE", "DIRS", "OK", "FAILED", "PUSHED", "TOTAL PUSHED", "DURATION", "PER DIR")
+	for i := first; i < len(rows); i++ {
+		r := rows[i]
+		fmt.Printf("%-16s %6d %6d %6d %12s %14s %9s %8s\n", r.Date.Local().Format("2006-01-02 15:04"),
+			r.Dirs, r.Success, r.Failed, formatBytes(r.Bytes), formatBytes(cumulative[i]),
+			r.Duration.Round(time.Second), r.perDir().Round(10*time.Millisecond))
+	}
+
+	shown := rows[first:]
+	if len(shown) < 2 {
+		return 0
+	}
+	before := int64(0)
+	if first > 0 {
+		before = cumulative[first-1]
+	}
+	fmt.Printf("\nOver the last %d runs: total pushed grew %s (%s → %s)\n", len(shown),
+		formatBytes(sum-before), formatBytes(before), formatBytes(sum))
+	half := len(shown) / 2
+	fmt.Printf("Average time per directory: %s (older half) → %s (newer half)\n",
+		averagePerDir(shown[:half]).Round(10*time.Millisecond), averagePerDir(shown[half:]).Round(10*time.Millisecond))
+	return 0
+}
+
+func averagePerDir(rows []HistoryRow) time.Duration {
+	var total time.Duration
+	var dirs int64
+	for _, r := range rows {
+		total += r.Duration
+		dirs += r.Dirs
+	}
+	if dirs == 0 {
+		return 0
+	}
+	return total / time.Duration(dirs)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Every force push leaves the replaced objects unreachable on GitHub's
+// side, and only GitHub Support can garbage collect them. gitmax counts
+// force pushes per repo so it can point out the repos worth asking about.
+const (
+	largeForcePushBytes = 50 * 1024 * 1024
+	gcSuggestPushes     = 20
+	gcSuggestBytes      = 1024 * 1024 * 1024
+)
+
+// housekeeping enables API maintenance after large force pushes
+var housekeeping bool
+
+// GCSuggestion is an entry of ~/.gitmax/gc-suggestions.json
+type GCSuggestion struct {
+	Repo             string    `json:"repo"`
+	ForcePushes      int       `json:"force_pushes"`
+	ForcePushedBytes int64     `json:"force_pushed_bytes"`
+	LastPush         time.Time `json:"last_push"`
+}
+
+// afterForcePush runs remote maintenance for a large force push.
+func afterForcePush(job DirJob, result Result) {
+	if !housekeeping || !result.Forced || result.Transfer.Bytes < largeForcePushBytes {
+		return
+	}
+	if err := refreshSecurityFixes(job.owner(), job.RepoName); err != nil && verbose {
+		fmt.Printf("housekeeping for %s failed: %v\n", job.RepoName, err)
+	}
+}
+
+// refreshSecurityFixes turns automated security fixes off and on again,
+// which makes GitHub re-scan the rewritten default branch. Repos without
+// the feature are left alone.
+func refreshSecurityFixes(owner, repoName string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/automated-security-fixes", githubAPI, owner, repoName)
+	var status struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := apiSend("GET", url, nil, &status); err != nil {
+		if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == 404 {
+			return nil
+		}
+		return err
+	}
+	if !status.Enabled {
+		return nil
+	}
+	if err := apiSend("DELETE", url, nil, nil); err != nil {
+		return err
+	}
+	return apiSend("PUT", url, nil, nil)
+}
+
+// gcSuggestions lists repos whose force push history suggests a lot of
+// unreachable objects, most affected first.
+func gcSuggestions() []GCSuggestion {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	var list []GCSuggestion
+	for key, r := range state.Repos {
+		if r.ForcePushes < gcSuggestPushes && r.ForcePushedBytes < gcSuggestBytes {
+			continue
+		}
+		list = append(list, GCSuggestion{
+			Repo:             key,
+			ForcePushes:      r.ForcePushes,
+			ForcePushedBytes: r.ForcePushedBytes,
+			LastPush:         r.LastPush,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ForcePushedBytes > list[j].ForcePushedBytes })
+	return list
+}
+
+// writeGCSuggestions records the suggestion list and tells the user about
+// it. Nothing is written when no repo qualifies.
+func writeGCSuggestions() error {
+	list := gcSuggestions()
+	if len(list) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(gitmaxDir(), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(gitmaxDir(), "gc-suggestions.json")
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("\n🧹 %d repos have piled up unreachable objects from force pushes;\n", len(list))
+	fmt.Printf("   GitHub Support can garbage collect them. List: %s\n", path)
+	return nil
+}
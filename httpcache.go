@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// GET responses that carry an ETag are kept in the state file, and later
+// runs send it back as If-None-Match. GitHub answers an unchanged resource
+// with 304 Not Modified, which doesn't count against the rate limit, and
+// the cached response is replayed to the caller. Only small responses,
+// such as repo lookups and the token check, are worth keeping; entries
+// not used for cacheMaxAge are dropped when the state is saved. Entries
+// are keyed by the credential too, so a 304 never replays a response made
+// for another token, such as its private repo listing.
+const (
+	cacheMaxBody = 64 << 10
+	cacheMaxAge  = 30 * 24 * time.Hour
+)
+
+// CachedResponse is a GET response kept for conditional requests
+type CachedResponse struct {
+	ETag     string      `json:"etag"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	LastUsed time.Time   `json:"last_used"`
+}
+
+func cachedResponse(key string) *CachedResponse {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state.HTTPCache[key]
+}
+
+// cacheKey is the URL prefixed with a fingerprint of the credential: a
+// hash of the token, never the token itself.
+func cacheKey(url string) string {
+	if ghToken == "" {
+		return "gh " + url
+	}
+	sum := sha256.Sum256([]byte(ghToken))
+	return hex.EncodeToString(sum[:8]) + " " + url
+}
+
+// cachedGet sends a GET through the cache.
+func cachedGet(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req.URL.String())
+	cached := cachedResponse(key)
+	if cached != nil {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := githubDo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		stateMu.Lock()
+		cached.LastUsed = time.Now()
+		stateMu.Unlock()
+		// The 304 carries current scope and rate limit headers
+		header := cached.Header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", cached.Status, http.StatusText(cached.Status)),
+			StatusCode: cached.Status,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader(cached.Body)),
+			Request:    req,
+		}, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "" && resp.ContentLength <= cacheMaxBody:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, cacheMaxBody+1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if len(body) > cacheMaxBody {
+			// Too big to keep; hand it on with the part already read
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		} else {
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			stateMu.Lock()
+			if state.HTTPCache == nil {
+				state.HTTPCache = map[string]*CachedResponse{}
+			}
+			state.HTTPCache[key] = &CachedResponse{ETag: resp.Header.Get("ETag"), Status: resp.StatusCode,
+				Header: resp.Header.Clone(), Body: body, LastUsed: time.Now()}
+			stateMu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+// pruneHTTPCache drops entries unused for cacheMaxAge. Callers hold stateMu.
+func pruneHTTPCache() {
+	for key, c := range state.HTTPCache {
+		if time.Since(c.LastUsed) > cacheMaxAge {
+			delete(state.HTTPCache, key)
+		}
+	}
+}
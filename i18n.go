@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Run output is translated by looking up the English text in a message
+// catalog; text missing from a catalog is printed in English, so a partial
+// translation is always safe. Counts, decimals and durations follow the
+// language's conventions. The language comes from -lang, else from
+// LC_ALL, LC_MESSAGES or LANG, and defaults to English.
+var lang = "en"
+
+// numberFormat is a language's thousands and decimal separators
+type numberFormat struct {
+	group, decimal string
+}
+
+var numberFormats = map[string]numberFormat{
+	"en": {",", "."},
+	"he": {",", "."},
+	"es": {".", ","},
+}
+
+// durationUnits names hours, minutes and seconds. English keeps Go's
+// compact 1h2m3s.
+var durationUnits = map[string][3]string{
+	"he": {"שע׳", "דק׳", "שנ׳"},
+	"es": {"h", "min", "s"},
+}
+
+var catalogs = map[string]map[string]string{
+	"he": {
+		"GitMax - Ultra-Fast Parallel GitHub Pusher": "GitMax - דחיפה מקבילית מהירה ל-GitHub",
+		"FINAL RESULTS":                   "תוצאות סופיות",
+		"Directories":                     "תיקיות",
+		"Roots":                           "שורשים",
+		"Workers":                         "עובדים",
+		"Dry Run":                         "הרצת ניסיון",
+		"Run ID":                          "מזהה הרצה",
+		"Export To":                       "ייצוא אל",
+		"Agent of":                        "סוכן של",
+		"Total Directories":               "סה״כ תיקיות",
+		"Successful":                      "הצליחו",
+		"Failed":                          "נכשלו",
+		"Time Elapsed":                    "זמן שחלף",
+		"Average Speed":                   "מהירות ממוצעת",
+		"Data Pushed":                     "נתונים שנדחפו",
+		"%s dirs/sec":                     "%s תיקיות/שנ׳",
+		"yes":                             "כן",
+		"no":                              "לא",
+		"calculating...":                  "מחשב...",
+		"ETA":                             "נותר",
+		"No directories found to process": "לא נמצאו תיקיות לעיבוד",
+		"\n✗ Failures:\n":                 "\n✗ כשלונות:\n",
+		"   Full git output and API responses: %s\n":                                           "   פלט git מלא ותגובות ה-API: %s\n",
+		"\n⚠ %d file(s) larger than %dMB were pushed:\n":                                       "\n⚠ נדחפו %d קבצים גדולים מ-%dMB:\n",
+		"\n⏸ Paused %d time(s):\n":                                                             "\n⏸ הושהה %d פעמים:\n",
+		"\n⚡ Performance: %sx faster than one directory at a time\n":                           "\n⚡ ביצועים: מהיר פי %s מתיקייה אחת בכל פעם\n",
+		"   Sequential would take: ~%s (%s per directory, measured)\n":                         "   הרצה סדרתית הייתה נמשכת: ~%s (%s לתיקייה, לפי מדידה)\n",
+		"   Worker utilization: %s%% of %d workers\n":                                          "   ניצולת עובדים: %s%% מתוך %d עובדים\n",
+		"\n⚠ %d clone(s) of other repos skipped; pass -rehost to push your own copies:\n":      "\n⚠ דולגו %d שכפולים של מאגרים של אחרים; ‎-rehost דוחף עותקים משלך:\n",
+		"\n⏱ Where the workers' time went (%d workers × %s):\n":                                "\n⏱ לאן הלך זמן העובדים (%d עובדים × %s):\n",
+		"   Workers busy: %s%% min, %s%% median, %s%% max (-v for each worker)\n":              "   עומס עובדים: מינימום %s%%, חציון %s%%, מקסימום %s%% (‎-v לכל עובד)\n",
+		"API-bound: GitHub calls dominate; more workers mostly add rate limiting":              "חסום API: קריאות GitHub שולטות; עוד עובדים בעיקר יגבירו הגבלת קצב",
+		"Network-bound: pushes dominate; -max-pushes or a faster link helps more than workers": "חסום רשת: הדחיפות שולטות; ‎-max-pushes או קו מהיר יותר יעזרו יותר מעובדים",
+		"Disk-bound: local git work dominates; more workers help until the disk saturates":     "חסום דיסק: עבודת git מקומית שולטת; עוד עובדים יעזרו עד שהדיסק יתמלא",
+		"Limit-bound: workers mostly wait on -api-concurrency, -max-pushes or pauses":          "חסום מגבלות: העובדים בעיקר ממתינים ל-‎-api-concurrency, ‎-max-pushes או להשהיות",
+	},
+	"es": {
+		"GitMax - Ultra-Fast Parallel GitHub Pusher": "GitMax - Envío paralelo ultrarrápido a GitHub",
+		"FINAL RESULTS":                   "RESULTADOS FINALES",
+		"Directories":                     "Directorios",
+		"Roots":                           "Raíces",
+		"Workers":                         "Trabajadores",
+		"Dry Run":                         "Simulación",
+		"Run ID":                          "ID de ejecución",
+		"Export To":                       "Exportar a",
+		"Agent of":                        "Agente de",
+		"Total Directories":               "Total de directorios",
+		"Successful":                      "Correctos",
+		"Failed":                          "Fallidos",
+		"Time Elapsed":                    "Tiempo transcurrido",
+		"Average Speed":                   "Velocidad media",
+		"Data Pushed":                     "Datos enviados",
+		"%s dirs/sec":                     "%s dir/s",
+		"yes":                             "sí",
+		"no":                              "no",
+		"calculating...":                  "calculando...",
+		"ETA":                             "Restante",
+		"No directories found to process": "No se encontraron directorios para procesar",
+		"\n✗ Failures:\n":                 "\n✗ Fallos:\n",
+		"   Full git output and API responses: %s\n":                                           "   Salida completa de git y respuestas de la API: %s\n",
+		"\n⚠ %d file(s) larger than %dMB were pushed:\n":                                       "\n⚠ Se enviaron %d archivo(s) de más de %dMB:\n",
+		"\n⏸ Paused %d time(s):\n":                                                             "\n⏸ En pausa %d vez/veces:\n",
+		"\n⚡ Performance: %sx faster than one directory at a time\n":                           "\n⚡ Rendimiento: %sx más rápido que un directorio cada vez\n",
+		"   Sequential would take: ~%s (%s per directory, measured)\n":                         "   En secuencia tardaría: ~%s (%s por directorio, medido)\n",
+		"   Worker utilization: %s%% of %d workers\n":                                          "   Uso de trabajadores: %s%% de %d\n",
+		"\n⚠ %d clone(s) of other repos skipped; pass -rehost to push your own copies:\n":      "\n⚠ Se omitieron %d clon(es) de repositorios ajenos; usa -rehost para enviar tus propias copias:\n",
+		"\n⏱ Where the workers' time went (%d workers × %s):\n":                                "\n⏱ En qué se fue el tiempo de los trabajadores (%d trabajadores × %s):\n",
+		"   Workers busy: %s%% min, %s%% median, %s%% max (-v for each worker)\n":              "   Ocupación: %s%% mín., %s%% mediana, %s%% máx. (-v para cada trabajador)\n",
+		"API-bound: GitHub calls dominate; more workers mostly add rate limiting":              "Limitado por la API: dominan las llamadas a GitHub; más trabajadores sobre todo añaden límites de tasa",
+		"Network-bound: pushes dominate; -max-pushes or a faster link helps more than workers": "Limitado por la red: dominan los envíos; -max-pushes o una conexión más rápida ayudan más que más trabajadores",
+		"Disk-bound: local git work dominates; more workers help until the disk saturates":     "Limitado por el disco: domina el trabajo local de git; más trabajadores ayudan hasta saturar el disco",
+		"Limit-bound: workers mostly wait on -api-concurrency, -max-pushes or pauses":          "Limitado por los topes: los trabajadores esperan sobre todo a -api-concurrency, -max-pushes o pausas",
+	},
+}
+
+// detectLang picks the language from the locale environment, e.g.
+// he_IL.UTF-8 → he.
+func detectLang() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			return normalizeLang(v)
+		}
+	}
+	return "en"
+}
+
+// normalizeLang reduces a locale name to a supported language, falling
+// back to English.
+func normalizeLang(locale string) string {
+	code := strings.ToLower(locale)
+	if i := strings.IndexAny(code, "_-.@"); i >= 0 {
+		code = code[:i]
+	}
+	if code == "iw" {
+		code = "he"
+	}
+	if _, ok := numberFormats[code]; ok {
+		return code
+	}
+	return "en"
+}
+
+// T translates a message, which may be a format string.
+func T(msg string) string {
+	if t, ok := catalogs[lang][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// yesNo translates a bool.
+func yesNo(b bool) string {
+	if b {
+		return T("yes")
+	}
+	return T("no")
+}
+
+// formatInt renders n with the language's thousands separator.
+func formatInt(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, s = "-", s[1:]
+	}
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteString(numberFormats[lang].group)
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+// formatFloat renders f with prec decimals in the language's notation.
+func formatFloat(f float64, prec int) string {
+	s := strconv.FormatFloat(f, 'f', prec, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	n, _ := strconv.ParseInt(whole, 10, 64)
+	out := formatInt(n)
+	if strings.HasPrefix(whole, "-") && n == 0 {
+		out = "-" + out
+	}
+	if frac != "" {
+		out += numberFormats[lang].decimal + frac
+	}
+	return out
+}
+
+// formatDuration renders d rounded to the second, or to 10ms under a
+// second.
+func formatDuration(d time.Duration) string {
+	units, ok := durationUnits[lang]
+	if d > 0 && d < time.Second-5*time.Millisecond {
+		d = d.Round(10 * time.Millisecond)
+		if !ok {
+			return d.String()
+		}
+		return formatFloat(d.Seconds(), 2) + " " + units[2]
+	}
+	d = d.Round(time.Second)
+	if !ok {
+		return d.String()
+	}
+	h, m, s := int64(d/time.Hour), int64(d%time.Hour/time.Minute), int64(d%time.Minute/time.Second)
+	var parts []string
+	if h > 0 {
+		parts = append(parts, formatInt(h)+" "+units[0])
+	}
+	if m > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", m, units[1]))
+	}
+	if s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", s, units[2]))
+	}
+	return strings.Join(parts, " ")
+}
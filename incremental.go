@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Snapshot runs replace a directory's .git every time, so the remote holds
+// one orphan commit per run and anything committed by hand is lost. With
+// -incremental an existing repo is kept instead: the working tree is staged
+// on top of its HEAD, a commit is made only when something besides
+// MetadataFile changed, and the push goes without force. A remote that
+// moved on since stops the push rather than being overwritten, unless
+// -merge-remote merges it first. Pushes go through a remote of their own,
+// leaving origin and branch tracking as they were. Directories without a
+// repo are initialized as usual and kept from then on.
+var incremental bool
+
+// incrementalRemote is the remote -incremental pushes through, so a kept
+// repo's origin keeps pointing wherever its owner set it.
+const incrementalRemote = "gitmax"
+
+// pushRemote is the remote a directory's repo is pushed through.
+func pushRemote() string {
+	if incremental {
+		return incrementalRemote
+	}
+	return "origin"
+}
+
+// mirrorPushArgs pushes the snapshot to an extra remote or provider:
+// forced like origin's in snapshot mode, as a fast-forward of HEAD, which
+// a kept repo may not have on main, with -incremental.
+func mirrorPushArgs(remote, branch string) []string {
+	if incremental {
+		return []string{"push", remote, "HEAD:refs/heads/" + branch}
+	}
+	return []string{"push", "--force", remote, "main:" + branch}
+}
+
+// reuseRepo reports whether dir's repository is kept for this run.
+// linkedCheckout has already skipped directories whose .git is a file.
+func reuseRepo(dir string) bool {
+	if !incremental {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil && info.IsDir()
+}
+
+// untrackExcluded drops files now above the size limit from a kept repo's
+// index; the .gitignore rules alone leave tracked files staged.
+func untrackExcluded(dir string, excluded []string) error {
+	if len(excluded) == 0 {
+		return nil
+	}
+	args := []string{"rm", "--cached", "-q", "--ignore-unmatch", "--"}
+	for _, p := range excluded {
+		args = append(args, ":(literal)"+p)
+	}
+	return runGit(dir, args...)
+}
+
+// commitChanges commits what's staged in a kept repo. It returns false when
+// only MetadataFile, rewritten on every run, differs from HEAD; that change
+// is unstaged so the next run doesn't see it either.
+func commitChanges(job DirJob) (bool, error) {
+	_, headErr := runGitOutput(job.Path, "rev-parse", "-q", "--verify", "HEAD")
+	if headErr == nil {
+		out, err := runGitOutput(job.Path, "diff", "--cached", "--name-only", "-z")
+		if err != nil {
+			return false, err
+		}
+		changed := false
+		for _, p := range strings.Split(out, "\x00") {
+			if p != "" && p != MetadataFile {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return false, runGit(job.Path, "reset", "-q", "--", MetadataFile)
+		}
+	}
+
+	// The user's own repos may have no identity, as gitmax runs git
+	// without the global config, or hooks that expect an editor session
+	var args []string
+	if email, _ := runGitOutput(job.Path, "config", "user.email"); email == "" {
+		name, email := commitIdentity()
+		args = append(args, "-c", "user.name="+name, "-c", "user.email="+email)
+	}
+	args = append(args, "commit", "-q", "--no-verify", "--allow-empty", "-m", commitMessage(job))
+	if err := runGit(job.Path, args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkFastForward fails when the remote branch, at prevSHA, has commits
+// HEAD doesn't contain, so a push without force would be rejected.
+func checkFastForward(job DirJob, prevSHA string) error {
+	if prevSHA == "" {
+		return nil
+	}
+	if runGit(job.Path, "merge-base", "--is-ancestor", prevSHA, "HEAD") != nil {
+		return fmt.Errorf("%s/%s has commits on %s that %s lacks; pass -merge-remote to merge them, or drop -incremental to overwrite them",
+			job.owner(), job.RepoName, job.branch(), job.Path)
+	}
+	return nil
+}
+
+// keptBranchSpecs returns the refspecs pushing a kept repo's other
+// branches selected by -branches, and its tags. Unlike a restored bundle's
+// they aren't forced.
+func keptBranchSpecs(job DirJob) []string {
+	if branchesMode == "" {
+		return nil
+	}
+	out, _ := runGitOutput(job.Path, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	current, _ := runGitOutput(job.Path, "symbolic-ref", "--short", "-q", "HEAD")
+	var specs []string
+	for _, branch := range strings.Fields(out) {
+		if branch != current && branch != job.branch() && selectBranch(branch, current) {
+			specs = append(specs, "refs/heads/"+branch+":refs/heads/"+branch)
+		}
+	}
+	if tags, _ := runGitOutput(job.Path, "tag", "--list"); tags != "" {
+		specs = append(specs, "--tags")
+	}
+	return specs
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// activeJob is an in-flight directory and the git process it is running
+type activeJob struct {
+	Path   string
+	Repo   string // owner/name
+	Worker int
+	Start  time.Time
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	killed int32        // Set when the job was killed as stuck
+	log    bytes.Buffer // Transcript for failure reports
+}
+
+var (
+	activeJobs sync.Map // Path → *activeJob
+
+	durationsMu sync.Mutex
+	durations   []time.Duration
+
+	stuckFactor float64 = 5
+	stuckMin            = 30 * time.Second
+	killStuck   bool
+)
+
+func startJob(path, repo string, worker int) *activeJob {
+	job := &activeJob{Path: path, Repo: repo, Worker: worker, Start: time.Now()}
+	activeJobs.Store(path, job)
+	return job
+}
+
+func finishJob(job *activeJob, d time.Duration) {
+	activeJobs.Delete(job.Path)
+	durationsMu.Lock()
+	durations = append(durations, d)
+	durationsMu.Unlock()
+}
+
+// trackCmd records the process a job is running so it can be killed.
+func trackCmd(dir string, cmd *exec.Cmd) {
+	if v, ok := activeJobs.Load(dir); ok {
+		job := v.(*activeJob)
+		job.mu.Lock()
+		job.cmd = cmd
+		job.mu.Unlock()
+	}
+}
+
+func untrackCmd(dir string, cmd *exec.Cmd) {
+	if v, ok := activeJobs.Load(dir); ok {
+		job := v.(*activeJob)
+		job.mu.Lock()
+		if job.cmd == cmd {
+			job.cmd = nil
+		}
+		job.mu.Unlock()
+	}
+}
+
+// kill terminates the job's current git process.
+func (j *activeJob) kill() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cmd != nil && j.cmd.Process != nil {
+		j.cmd.Process.Kill()
+	}
+}
+
+// jobStopped reports whether the job in path was cancelled or killed as
+// stuck, so a failed command must not be retried.
+func jobStopped(path string) bool {
+	if isCancelled(path) {
+		return true
+	}
+	v, ok := activeJobs.Load(path)
+	return ok && atomic.LoadInt32(&v.(*activeJob).killed) == 1
+}
+
+// runTracked runs a git command, registering it with the job working in
+// cmd.Dir and charging its time to the job's worker.
+func runTracked(cmd *exec.Cmd) error {
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	trackCmd(cmd.Dir, cmd)
+	err := cmd.Wait()
+	untrackCmd(cmd.Dir, cmd)
+	recordStage(cmd.Dir, gitStage(cmd.Args[1:]), time.Since(start))
+	return err
+}
+
+// medianDuration of completed jobs, 0 until enough have finished.
+func medianDuration() time.Duration {
+	durationsMu.Lock()
+	defer durationsMu.Unlock()
+	if len(durations) < 5 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// stuckJobs returns jobs running longer than stuckFactor times the median
+// (and at least stuckMin), longest first.
+func stuckJobs() []*activeJob {
+	median := medianDuration()
+	if median == 0 {
+		return nil
+	}
+	limit := time.Duration(float64(median) * stuckFactor)
+	if limit < stuckMin {
+		limit = stuckMin
+	}
+
+	var stuck []*activeJob
+	activeJobs.Range(func(_, v interface{}) bool {
+		job := v.(*activeJob)
+		if time.Since(job.Start) > limit {
+			stuck = append(stuck, job)
+		}
+		return true
+	})
+	sort.Slice(stuck, func(i, j int) bool { return stuck[i].Start.Before(stuck[j].Start) })
+	return stuck
+}
+
+// checkStuck is called on every progress tick. It kills stuck jobs when
+// -kill-stuck is set and returns a short status for the progress line.
+func checkStuck() string {
+	stuck := stuckJobs()
+	if len(stuck) == 0 {
+		return ""
+	}
+	if killStuck {
+		for _, job := range stuck {
+			if atomic.CompareAndSwapInt32(&job.killed, 0, 1) {
+				job.kill()
+			}
+		}
+	}
+	oldest := stuck[0]
+	return fmt.Sprintf(" | ⚠ %d stuck (%s %s)", len(stuck), truncatePath(oldest.Path, 30),
+		time.Since(oldest.Start).Round(time.Second))
+}
+
+// truncatePath shortens a path from the left to at most n characters.
+func truncatePath(p string, n int) string {
+	r := []rune(p)
+	if len(r) <= n {
+		return p
+	}
+	return "…" + string(r[len(r)-n+1:])
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Operating systems scatter thumbnail caches, folder settings and
+// recycle bins through every tree they touch. Files and directories
+// whose name matches junkNames are never committed and don't count
+// toward any directory's size. The config's junk list replaces the
+// built-in one; -keep-junk turns the skipping off.
+var defaultJunk = []string{
+	"Thumbs.db", "ehthumbs.db", "desktop.ini", "$RECYCLE.BIN", "System Volume Information",
+	".DS_Store", ".Spotlight-V100", ".Trashes", ".fseventsd",
+}
+
+var keepJunk bool
+
+// junkNames are the name globs skipped, matched without regard to case.
+func junkNames() []string {
+	if keepJunk {
+		return nil
+	}
+	if config.Junk != nil {
+		return config.Junk
+	}
+	return defaultJunk
+}
+
+// isJunk reports whether a file or directory name is on the skip list.
+func isJunk(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range junkNames() {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasJunkSegment reports whether any element of a slash path is junk.
+func hasJunkSegment(rel string) bool {
+	for _, seg := range strings.Split(rel, "/") {
+		if isJunk(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+// junkPathspec excludes the skip list from git add, at any depth.
+func junkPathspec() []string {
+	var spec []string
+	for _, pattern := range junkNames() {
+		spec = append(spec, ":(exclude,glob,icase)**/"+pattern, ":(exclude,glob,icase)**/"+pattern+"/**")
+	}
+	return spec
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A running push keeps its progress in ~/.gitmax/live/<run id>.json so
+// gitmax status can show it from another terminal, e.g. for scheduled runs
+// that have no TTY of their own.
+
+const (
+	liveEventsKept = 20
+	liveStaleAfter = 10 * time.Second // A run that stopped updating is gone
+	liveKeepFor    = 24 * time.Hour   // Finished runs stay visible this long
+)
+
+// LiveProgress is the live progress file of a run
+type LiveProgress struct {
+	RunID       string      `json:"run_id"`
+	PID         int         `json:"pid"`
+	StartedAt   time.Time   `json:"started_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Finished    bool        `json:"finished"`
+	Total       int64       `json:"total"`
+	Completed   int64       `json:"completed"`
+	Success     int64       `json:"success"`
+	Failed      int64       `json:"failed"`
+	Skipped     int64       `json:"skipped"`
+	BytesPushed int64       `json:"bytes_pushed"`
+	Active      []jobStatus `json:"active"`
+	Events      []LiveEvent `json:"events"`
+}
+
+// LiveEvent is a finished directory
+type LiveEvent struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Status  string    `json:"status"` // success, failed or skipped
+	Message string    `json:"message,omitempty"`
+}
+
+var (
+	liveMu     sync.Mutex
+	liveEvents []LiveEvent
+)
+
+func liveDir() string {
+	return filepath.Join(gitmaxDir(), "live")
+}
+
+// recordEvent adds a finished result to the recent events.
+func recordEvent(result Result) {
+	event := LiveEvent{Time: time.Now(), Path: result.Path, Status: "success"}
+	switch {
+	case result.Skipped:
+		event.Status = "skipped"
+	case !result.Success:
+		event.Status = "failed"
+		event.Message = result.Message
+	}
+
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	liveEvents = append(liveEvents, event)
+	if len(liveEvents) > liveEventsKept {
+		liveEvents = liveEvents[len(liveEvents)-liveEventsKept:]
+	}
+}
+
+// writeLiveProgress snapshots the run into its live progress file. Errors
+// are ignored: the file is a convenience and must not fail the run.
+func writeLiveProgress(finished bool) {
+	p := LiveProgress{
+		RunID:       runID,
+		PID:         os.Getpid(),
+		StartedAt:   stats.StartTime,
+		UpdatedAt:   time.Now(),
+		Finished:    finished,
+		Total:       stats.Total,
+		Completed:   atomic.LoadInt64(&stats.Completed),
+		Success:     atomic.LoadInt64(&stats.Success),
+		Failed:      atomic.LoadInt64(&stats.Failed),
+		Skipped:     atomic.LoadInt64(&stats.Skipped),
+		BytesPushed: atomic.LoadInt64(&stats.BytesPushed),
+		Active:      []jobStatus{},
+	}
+	activeJobs.Range(func(_, v interface{}) bool {
+		job := v.(*activeJob)
+		p.Active = append(p.Active, jobStatus{Path: job.Path, Started: job.Start, Seconds: time.Since(job.Start).Seconds()})
+		return true
+	})
+	sort.Slice(p.Active, func(i, j int) bool { return p.Active[i].Started.Before(p.Active[j].Started) })
+	liveMu.Lock()
+	p.Events = append([]LiveEvent{}, liveEvents...)
+	liveMu.Unlock()
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(liveDir(), 0755) != nil {
+		return
+	}
+	// Write and rename so readers never see a partial file
+	path := filepath.Join(liveDir(), runID+".json")
+	if ioutil.WriteFile(path+".tmp", data, 0644) == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+// pruneLiveProgress removes files of runs that finished or died long ago.
+func pruneLiveProgress() {
+	for _, p := range readLiveProgress() {
+		if time.Since(p.UpdatedAt) > liveKeepFor {
+			os.Remove(filepath.Join(liveDir(), p.RunID+".json"))
+		}
+	}
+}
+
+// readLiveProgress returns every run's progress, most recently updated
+// first.
+func readLiveProgress() []LiveProgress {
+	files, _ := filepath.Glob(filepath.Join(liveDir(), "*.json"))
+	var runs []LiveProgress
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var p LiveProgress
+		if json.Unmarshal(data, &p) == nil && p.RunID != "" {
+			runs = append(runs, p)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].UpdatedAt.After(runs[j].UpdatedAt) })
+	return runs
+}
+
+func (p LiveProgress) state() string {
+	switch {
+	case p.Finished:
+		return "finished"
+	case time.Since(p.UpdatedAt) > liveStaleAfter:
+		return "stopped responding"
+	default:
+		return "running"
+	}
+}
+
+// findLiveRun picks the run to show: the given one, else the newest
+// running one, else the newest at all.
+func findLiveRun(id string) (LiveProgress, bool) {
+	runs := readLiveProgress()
+	for _, p := range runs {
+		if id != "" && strings.HasPrefix(p.RunID, id) {
+			return p, true
+		}
+	}
+	if id != "" {
+		return LiveProgress{}, false
+	}
+	for _, p := range runs {
+		if p.state() == "running" {
+			return p, true
+		}
+	}
+	if len(runs) > 0 {
+		return runs[0], true
+	}
+	return LiveProgress{}, false
+}
+
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	attach := fs.Bool("attach", false, "Follow the run's progress until it finishes")
+	run := fs.String("run", "", "Run ID or prefix (default: the current run)")
+	events := fs.Int("events", 5, "Recent events to show")
+	fs.Parse(args)
+
+	p, ok := findLiveRun(*run)
+	if !ok {
+		fmt.Println("No gitmax run in progress")
+		return 1
+	}
+	if !*attach {
+		printLiveProgress(p, *events)
+		return 0
+	}
+
+	// Redraw in place: move the cursor back over the previous frame
+	lines := 0
+	for {
+		if lines > 0 {
+			fmt.Printf("\033[%dA\033[J", lines)
+		}
+		lines = printLiveProgress(p, *events)
+		if p.state() != "running" {
+			return 0
+		}
+		time.Sleep(500 * time.Millisecond)
+		if next, ok := findLiveRun(p.RunID); ok {
+			p = next
+		}
+	}
+}
+
+// printLiveProgress prints a run's progress and returns the line count.
+func printLiveProgress(p LiveProgress, events int) int {
+	width := max(0, terminalWidth()-1)
+	lines := 0
+	line := func(format string, a ...interface{}) {
+		fmt.Printf(format+"\n", a...)
+		lines++
+	}
+
+	line("Run %s (pid %d) %s, started %s", p.RunID, p.PID, p.state(), p.StartedAt.Format("15:04:05"))
+	if p.Total > 0 {
+		elapsed := p.UpdatedAt.Sub(p.StartedAt)
+		line("%s", truncateEnd(progressLine(p.Completed, p.Success, p.Failed, p.Total, p.BytesPushed, elapsed, width), width))
+	}
+	for _, j := range p.Active {
+		line("  … %s (%s)", truncatePath(j.Path, 60), (time.Duration(j.Seconds) * time.Second).Round(time.Second))
+	}
+	if events > len(p.Events) {
+		events = len(p.Events)
+	}
+	if events < 0 {
+		events = 0
+	}
+	for _, e := range p.Events[len(p.Events)-events:] {
+		switch e.Status {
+		case "success":
+			line("  ✓ %s", truncatePath(e.Path, 60))
+		case "skipped":
+			line("  - %s", truncatePath(e.Path, 60))
+		default:
+			msg, _, _ := strings.Cut(e.Message, "\n")
+			if r := []rune(msg); len(r) > 60 {
+				msg = string(r[:59]) + "…"
+			}
+			line("  ✗ %s: %s", truncatePath(e.Path, 60), msg)
+		}
+	}
+	return lines
+}
@@ -2,136 +2,533 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 const (
 	GitHubFileLimitMB = 100
-	GitHubFileLimit   = GitHubFileLimitMB * 1024 * 1024
+	GitHubWarnLimitMB = 50
 	DefaultWorkers    = 20
-	GitHubUsername    = "Michaelunkai"
 )
 
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 // Stats for tracking progress
 type Stats struct {
-	Total      int64
-	Completed  int64
-	Success    int64
-	Failed     int64
-	Skipped    int64
-	StartTime  time.Time
+	Total       int64
+	Completed   int64
+	Success     int64
+	Failed      int64
+	Skipped     int64
+	BytesPushed int64
+	StartTime   time.Time
 }
 
 // DirJob represents a directory to process
 type DirJob struct {
 	Path     string
 	RepoName string
+	Root     string // Scan root, empty for paths read from a file
+	Owner    string // Organization from the path list, empty for githubUser
+	Private  *bool  // Visibility from the path list, nil for the config default
+	Branch   string // Remote branch from the path list, empty for main
+}
+
+// owner is the account the job's repo lives under.
+func (j DirJob) owner() string {
+	if j.Owner != "" {
+		return j.Owner
+	}
+	return githubUser
+}
+
+// branch is the remote branch the job's snapshot is pushed to.
+func (j DirJob) branch() string {
+	if j.Branch != "" {
+		return j.Branch
+	}
+	return "main"
 }
 
 // Result of processing a directory
 type Result struct {
-	Path    string
-	Success bool
-	Message string
-	RepoURL string
+	Path       string
+	RepoName   string
+	Success    bool
+	Skipped    bool
+	Created    bool // The repo was (or in a dry run, would be) created
+	Message    string
+	RepoURL    string
+	LargeFiles []string // Files above the warning tier but below the limit
+	PrevSHA    string   // Remote main before the push, empty if there was none
+	PushedSHA  string
+	Forced     bool   // Remote history was replaced rather than extended
+	Transport  string // "https" or "ssh" for git engine pushes
+	Root       string // DirJob.Root
+	Owner      string // DirJob.owner()
+	Branch     string // DirJob.branch()
+	Foreign    string // Origin of a clone of someone else's repo, skipped without -rehost
+	Transfer   pushTransfer
+	Duration   time.Duration
 }
 
 var (
-	stats       Stats
-	ghToken     string
-	verbose     bool
-	dryRun      bool
-	statsMutex  sync.Mutex
+	stats        Stats
+	ghToken      string
+	verbose      bool
+	dryRun       bool
+	mergeRemote  bool
+	apiEngine    bool
+	createSem    chan struct{}
+	statsMutex   sync.Mutex
+	maxFileSize  int64
+	warnFileSize int64
+	results      []Result
+
+	apiEngineMaxSize int64
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+		if os.Args[1] == "plan" || os.Args[1] == "apply" {
+			args, err := planArgs(os.Args[1], os.Args[2:])
+			if err != nil {
+				fmt.Printf("✗ %v\n", err)
+				os.Exit(1)
+			}
+			os.Args = append([]string{os.Args[0]}, args...)
+		}
+	}
+
 	// Parse flags
-	inputFile := flag.String("f", "", "File containing directory paths (one per line)")
-	inputDir := flag.String("d", "", "Single directory to process recursively")
+	inputFile := flag.String("f", "", "File containing directory paths, one per line with optional repo=, org=, branch=, private/public")
+	var inputDirs stringList
+	flag.Var(&inputDirs, "d", "Directory to process recursively (repeatable)")
 	workers := flag.Int("w", DefaultWorkers, "Number of parallel workers")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
 	flag.BoolVar(&dryRun, "dry-run", false, "Dry run (don't actually push)")
+	flag.BoolVar(&mergeRemote, "merge-remote", false, "Merge existing remote commits instead of force pushing")
+	flag.BoolVar(&incremental, "incremental", false, "Keep existing repos and commit only changes on top of their history, pushing without force")
 	depth := flag.Int("depth", 20, "Max directory depth for recursive scan")
+	level := flag.Int("level", 0, "Only create repos from directories exactly this many levels below the root")
+	maxFileMB := flag.Int("max-file-size", GitHubFileLimitMB, "Max file size in MB before exclusion")
+	warnFileMB := flag.Int("warn-file-size", GitHubWarnLimitMB, "File size in MB above which files are reported as large")
+	flag.BoolVar(&apiEngine, "api-engine", false, "Push small directories through the GitHub Git Data API")
+	apiEngineKB := flag.Int("api-engine-max-kb", 512, "Max directory size in KB for the API engine")
+	summaryFile := flag.String("summary", "gitmax-summary.json", "Write a JSON run summary here (empty to disable)")
+	flag.StringVar(&managedTopic, "marker", managedTopic, "Topic marking gitmax-created repos (empty to disable)")
+	flag.Var(&runLabels, "label", "Label recorded for this run's repos in the state file (repeatable)")
+	flag.BoolVar(&labelTags, "label-topics", false, "Also add -label values as repo topics")
+	noTrash := flag.Bool("no-trash", false, "Don't keep overwritten history in gitmax-trash/ branches")
+	flag.IntVar(&trashDays, "trash-days", trashDays, "Delete a repo's gitmax-trash/ branches older than this many days when adding one (0 keeps them)")
+	noMetadata := flag.Bool("no-metadata", false, "Don't commit "+MetadataFile+" source metadata")
+	flag.BoolVar(&keepJunk, "keep-junk", false, "Also commit OS junk like Thumbs.db and .DS_Store (junk: in the config)")
+	flag.BoolVar(&portableNames, "portable-names", false, "Commit names Windows rejects under safe ones, mapped back in "+NamesFile)
+	flag.BoolVar(&recordAttrs, "file-attrs", false, "Commit file permissions, owners and xattrs in "+AttrsFile+" for restore")
+	flag.BoolVar(&readmeFooter, "readme-footer", false, "End each README with a footer saying where the backup comes from")
+	flag.StringVar(&statePath, "state", "", "State file (default: ~/.gitmax/state.json)")
+	flag.StringVar(&webhookURL, "webhook", "", "Webhook URL (Slack compatible) for mid-run alerts")
+	flag.Float64Var(&alertFailureRate, "alert-failure-rate", 0, "Alert when this fraction of jobs has failed, e.g. 0.2")
+	flag.Var(&criticalPaths, "critical", "Path pattern whose failure alerts immediately (repeatable)")
+	tokenFile := flag.String("token-file", "", "Read the GitHub token from this file")
+	tokenStdin := flag.Bool("token-stdin", false, "Read the GitHub token from stdin")
+	apiConcurrency := flag.Int("api-concurrency", 3, "Max concurrent repo creation requests")
+	flag.Float64Var(&createLimiter.rate, "create-rate", 1, "Max repo creations per second (0 = unlimited)")
+	configFile := flag.String("config", "", "Config file (default: ~/.gitmax.yml)")
+	flag.Var(&providerFlags, "provider", "Also push to this provider from the config file (repeatable)")
+	flag.Var(remoteFlag{}, "remote", "Also push to NAME=URL, with {owner} and {name} placeholders (repeatable)")
+	flag.Float64Var(&stuckFactor, "stuck-factor", stuckFactor, "Flag jobs running this many times the median duration as stuck")
+	flag.BoolVar(&killStuck, "kill-stuck", false, "Kill and retry stuck jobs once")
+	controlAddr := flag.String("control", "", "Serve the control API (jobs, cancel) on this address, e.g. localhost:7070")
+	templateRepo := flag.String("template-repo", "", "Create repos from this template repo (owner/name)")
+	flag.BoolVar(&offloadLarge, "offload", false, "Upload files over -max-file-size to the config's s3 bucket")
+	flag.StringVar(&exportDir, "export-bundles", "", "Write a git bundle per directory into this folder instead of pushing")
+	flag.IntVar(&maxPushes, "max-pushes", 0, "Max concurrent pushes across all destinations (0 = one per worker)")
+	flag.IntVar(&maxPushesPerHost, "max-pushes-per-host", 0, "Max concurrent pushes to any one host, e.g. a slow mirror (0 = unlimited)")
+	flag.Int64Var(&abortAfterFailures, "abort-after-failures", 0, "Skip the remaining jobs once this many have failed (0 = never)")
+	flag.IntVar(&breaker.threshold, "breaker", breaker.threshold, "Pause the run after this many consecutive failures (0 = never)")
+	flag.DurationVar(&breaker.pause, "breaker-pause", breaker.pause, "How long the first -breaker pause lasts; doubles while probes fail")
+	flag.BoolVar(&statusCheck, "status-check", true, "Pause while githubstatus.com reports an outage")
+	checkpointMB := flag.Int64("checkpoint-size", 1024, "Push snapshots larger than this many MB in resumable segments of this size (0 = off)")
+	flag.IntVar(&hashWorkers, "hash-workers", hashWorkers, "Goroutines hashing files within one large directory")
+	flag.StringVar(&fastImportMode, "fast-import", fastImportMode, "Commit snapshots with git fast-import: auto (from 100000 files), always or never")
+	addGitFlags(flag.CommandLine)
+	flag.StringVar(&agentURL, "agent", "", "Pull directories from this gitmax coordinator's queue instead of scanning")
+	flag.StringVar(&coordinatorURL, "coordinator", "", "Share directories with other machines through this gitmax coordinator URL")
+	flag.StringVar(&lang, "lang", detectLang(), "Output language: en, he or es (default from LANG)")
+	flag.StringVar(&branchesMode, "branches", "", "Also push branches of existing repos: all, current or a glob, plus their tags")
+	flag.BoolVar(&rehost, "rehost", false, "Push directories cloned from other people's repos to repos of your own")
+	flag.Var(&excludeDirs, "exclude", "Don't make repos of directories matching this glob or below them (repeatable)")
+	flag.StringVar(&githubUser, "user", "", "GitHub account to push to (default: the token's owner)")
+	flag.StringVar(&existingPolicy, "existing", "", "For repos that exist but weren't made by gitmax: adopt, skip, suffix or fail")
+	flag.BoolVar(&exportMeta, "export-meta", false, "Commit issues, labels and releases of repos that already exist to "+GitHubMetaDir+"/")
+	flag.BoolVar(&pushWikis, "wiki", false, "Also push each directory's .wiki sibling or docs/ folder to its GitHub wiki")
+	flag.StringVar(&eventsTarget, "events", "", "Stream NDJSON events to fd://N or unix:PATH")
+	flag.StringVar(&transportFlag, "transport", "https", "Primary push transport, https or ssh; the other is the fallback")
+	flag.BoolVar(&housekeeping, "housekeeping", false, "Refresh repo security features after large force pushes")
+	flag.IntVar(&keepGenerations, "keep-generations", 0, "With -merge-remote, squash history older than this many snapshots (git engine)")
+	includeHidden := flag.Bool("include-hidden", false, "Scan hidden directories and commit dotfiles (sets both toggles below)")
+	flag.BoolVar(&includeHiddenDirs, "hidden-dirs", false, "Create repos from hidden directories like .config")
+	flag.BoolVar(&includeHiddenFiles, "hidden-files", false, "Commit hidden files and directories inside repos")
+	var modifiedSince, modifiedBefore timeBound
+	flag.Var(&modifiedSince, "modified-since", "Only directories with a file modified since this age or date, e.g. 30d")
+	flag.Var(&modifiedBefore, "modified-before", "Only directories with no file modified since this age or date, e.g. 2023-01-01")
 	flag.Parse()
+	if !validFastImportMode(fastImportMode) {
+		fmt.Println("✗ -fast-import must be auto, always or never")
+		os.Exit(1)
+	}
+	if !validExistingPolicy(existingPolicy) {
+		fmt.Println("✗ -existing must be adopt, skip, suffix or fail")
+		os.Exit(1)
+	}
+	if transportFlag != "https" && transportFlag != "ssh" {
+		fmt.Println("✗ -transport must be https or ssh")
+		os.Exit(1)
+	}
+	if keepGenerations > 0 && !mergeRemote {
+		fmt.Println("✗ -keep-generations needs -merge-remote")
+		os.Exit(1)
+	}
+	if *includeHidden {
+		includeHiddenDirs, includeHiddenFiles = true, true
+	}
+	if code := normalizeLang(lang); code != lang && !strings.HasPrefix(strings.ToLower(lang), code) {
+		fmt.Printf("⚠ -lang %s is not available, using English\n", lang)
+	}
+	lang = normalizeLang(lang)
+	if err := validBranchesMode(branchesMode); err != nil {
+		fmt.Printf("✗ -branches: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Also accept positional argument
-	if *inputDir == "" && *inputFile == "" && len(flag.Args()) > 0 {
-		*inputDir = flag.Args()[0]
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Printf("✗ Could not read config: %v\n", err)
+		os.Exit(1)
+	}
+	applyConfigDefaults(flag.CommandLine)
+	if offloadLarge && config.S3.Bucket == "" {
+		fmt.Println("✗ -offload needs an s3: bucket in the config file")
+		os.Exit(1)
+	}
+	if *templateRepo != "" {
+		config.Repo.Template = *templateRepo
+	}
+	if err := setupProviders(providerFlags); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		os.Exit(1)
+	}
+
+	maxFileSize = int64(*maxFileMB) * 1024 * 1024
+	warnFileSize = int64(*warnFileMB) * 1024 * 1024
+	apiEngineMaxSize = int64(*apiEngineKB) * 1024
+	checkpointSize = *checkpointMB * 1024 * 1024
+	writeMetadata = !*noMetadata
+	useTrash = !*noTrash
+	if managedTopic != "" {
+		if err := validTopic(managedTopic); err != nil {
+			fmt.Printf("✗ -marker: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if labelTags {
+		for _, l := range runLabels {
+			if err := validTopic(l); err != nil {
+				fmt.Printf("✗ -label: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		os.Exit(1)
+	}
+	if *apiConcurrency < 1 {
+		*apiConcurrency = 1
+	}
+	createSem = make(chan struct{}, *apiConcurrency)
+	if maxPushes > 0 {
+		pushSem = make(chan struct{}, maxPushes)
 	}
 
-	if *inputDir == "" && *inputFile == "" {
+	// Also accept positional roots
+	inputDirs = normalizePaths(append(inputDirs, flag.Args()...))
+
+	if len(inputDirs) == 0 && *inputFile == "" && agentURL == "" {
 		fmt.Println("GitMax - Ultra-fast parallel git push to GitHub")
 		fmt.Println()
 		fmt.Println("Usage:")
-		fmt.Println("  gitmax -d <directory>     Process directory recursively")
+		fmt.Println("  gitmax -d <directory>     Process directory recursively (repeatable)")
 		fmt.Println("  gitmax -f <file>          Process paths from file")
-		fmt.Println("  gitmax <directory>        Process directory recursively")
+		fmt.Println("  gitmax <directory>...     Process directories recursively")
+		fmt.Println("  gitmax scan [-o json] <root>  List the directories a push would process")
+		fmt.Println("  gitmax remote-list [-org <name>] [-o json|csv]  List the repos on the account")
+		fmt.Println("  gitmax explain [-root <dir>] <path>  Show which rule includes, skips or renames a path")
+		fmt.Println("  gitmax restore [-map FROM=TO] [-into <dir>] [-match <glob>]  Clone backups back to disk")
+		fmt.Println("  gitmax verify [-match <glob>] [-label <name>]  Compare backups with local content")
+		fmt.Println("  gitmax plan -plan <file> <flags>  Save what a push would create, update, rename and delete")
+		fmt.Println("  gitmax apply -plan <file>  Run a saved plan")
+		fmt.Println("  gitmax clean [-dry-run] <root>  Remove .git dirs and files gitmax created")
+		fmt.Println("  gitmax undo [-run <id>] [-delete-created]  Reset remotes to before a run")
+		fmt.Println("  gitmax trash list|restore|purge  Manage overwritten history and deleted repos")
+		fmt.Println("  gitmax visibility -private|-public -match <glob>  Change visibility in bulk")
+		fmt.Println("  gitmax config validate|init  Check or create ~/.gitmax.yml")
+		fmt.Println("  gitmax status [-attach] [-run <id>]  Show the progress of a running push")
+		fmt.Println("  gitmax stats [-n 10]  Show totals and trends of recent runs")
+		fmt.Println("  gitmax coordinator [-listen :7071] [-queue <file>]  Share work between machines backing up the same data")
+		fmt.Println("  gitmax version [-o json]  Show version, build and tool details")
+		fmt.Println("  gitmax service install|uninstall|status [-every 1h] -- <flags>  Scheduled backups")
 		fmt.Println()
 		fmt.Println("Flags:")
 		fmt.Println("  -w <num>     Number of parallel workers (default: 20)")
 		fmt.Println("  -depth <num> Max directory depth (default: 20)")
+		fmt.Println("  -level <num> Only directories exactly num levels below the root (1 = immediate children)")
+		fmt.Println("  -max-file-size <mb>   Exclude files larger than this (default: 100)")
+		fmt.Println("  -warn-file-size <mb>  Report files larger than this (default: 50)")
+		fmt.Println("  -token-file <path>  Read the GitHub token from a file")
+		fmt.Println("  -token-stdin        Read the GitHub token from stdin")
+		fmt.Println("  -summary <path>     JSON run summary (default: gitmax-summary.json, none with -dry-run)")
+		fmt.Println("  -marker <topic>     Topic marking gitmax-created repos (default: gitmax)")
+		fmt.Println("  -label <name>       Label this run's repos in the state file (repeatable)")
+		fmt.Println("  -label-topics       Also add labels as repo topics")
+		fmt.Println("  -state <path>       State file (default: ~/.gitmax/state.json)")
+		fmt.Println("  -no-metadata        Don't commit .gitmax.json source metadata")
+		fmt.Println("  -no-trash           Don't keep overwritten history in gitmax-trash/ branches")
+		fmt.Println("  -trash-days <n>     Drop a repo's trash branches older than n days on each force push (default 30, 0 keeps them)")
+		fmt.Println("  -webhook <url>      Send mid-run alerts to a webhook")
+		fmt.Println("  -alert-failure-rate <f>  Alert when the failure rate reaches f (0-1)")
+		fmt.Println("  -critical <pattern>      Alert when a matching path fails (repeatable)")
+		fmt.Println("  -config <path>      Config file (default: ~/.gitmax.yml)")
+		fmt.Println("  -template-repo <owner/name>  Create repos from a template repository")
+		fmt.Println("  -provider <name>    Also push to a provider defined in the config (repeatable)")
+		fmt.Println("  -remote <name=url>  Also push to another remote, e.g. backup=git@host:{name}.git")
+		fmt.Println("  -stuck-factor <n>   Flag jobs running n× the median as stuck (default: 5)")
+		fmt.Println("  -kill-stuck         Kill and retry stuck jobs once")
+		fmt.Println("  -control <addr>     Control API for listing and cancelling jobs")
+		fmt.Println("  -include-hidden     Scan hidden directories and commit dotfiles")
+		fmt.Println("  -hidden-dirs        Only scan hidden directories")
+		fmt.Println("  -hidden-files       Only commit dotfiles")
+		fmt.Println("  -modified-since <age|date>   Only directories changed since, e.g. 30d")
+		fmt.Println("  -modified-before <age|date>  Only directories unchanged since, e.g. 2023-01-01")
+		fmt.Println("  -agent <url>        Push directories handed out by a coordinator's queue")
+		fmt.Println("  -coordinator <url>  Claim repos on a gitmax coordinator shared with other machines")
+		fmt.Println("  -lang <code>        Output language: en, he or es (default: from LANG)")
 		fmt.Println("  -v           Verbose output")
 		fmt.Println("  -dry-run     Don't actually push")
+		fmt.Println("  -merge-remote  Merge existing remote commits instead of force pushing")
+		fmt.Println("  -incremental   Keep existing repos, commit only changes and push without force")
+		fmt.Println("  -offload            Upload files over the size limit to S3 (s3: in the config)")
+		fmt.Println("  -export-bundles <dir>  Write git bundles instead of pushing (no network)")
+		fmt.Println("  -transport https|ssh  Primary push transport; the other is tried on failure")
+		fmt.Println("  -branches all|current|<glob>  Keep and push existing repos' branches and tags")
+		fmt.Println("  -rehost             Also push clones of other people's repos (skipped by default)")
+		fmt.Println("  -exclude <glob>     Make no repos of matching directories or those below them (repeatable)")
+		fmt.Println("  -user <name>        GitHub account to push to (default: the token's owner)")
+		fmt.Println("  -existing adopt|skip|suffix|fail  For repos by that name gitmax didn't create (default adopt)")
+		fmt.Println("  -export-meta        Commit existing repos' issues, labels and releases to .github-meta/")
+		fmt.Println("  -wiki               Also push <dir>.wiki or <dir>/docs to the repo's wiki")
+		fmt.Println("  -keep-junk          Commit Thumbs.db, .DS_Store, desktop.ini and other OS junk too")
+		fmt.Println("  -portable-names     Commit Windows-safe names for files Windows can't restore")
+		fmt.Println("  -file-attrs         Record permissions, owners and xattrs so restore brings them back")
+		fmt.Println("  -readme-footer      Add a provenance footer (host, path, time, run) to each README")
+		fmt.Println("  -fast-import auto|always|never  Commit via git fast-import (auto: 100000+ files)")
+		fmt.Println("  -git-bin <path>     git executable to run (default: git from PATH)")
+		fmt.Println("  -user-git-config    Let git read your ~/.gitconfig (default: ~/.gitmax/gitconfig only)")
+		fmt.Println("  -housekeeping       Refresh repo security features after large force pushes")
+		fmt.Println("  -keep-generations <n>  With -merge-remote, keep only the last n snapshots")
+		fmt.Println("  -api-engine    Push small directories via the GitHub API (no local git)")
+		fmt.Println("  -api-engine-max-kb <kb>  Size limit for the API engine (default: 512)")
+		fmt.Println("  -api-concurrency <num>   Max concurrent repo creations (default: 3)")
+		fmt.Println("  -create-rate <num>       Max repo creations per second (default: 1)")
 		os.Exit(1)
 	}
 
-	// Get GitHub token from gh CLI
-	ghToken = getGitHubToken()
-	if ghToken == "" {
-		fmt.Println("⚠ Warning: No GitHub token found. Run 'gh auth login' first.")
-		fmt.Println("  Continuing without token (repo creation may fail)...")
+	// Get GitHub token from a file, stdin or gh CLI; exports need none
+	if exportDir == "" {
+		var err error
+		ghToken, err = readToken(*tokenFile, *tokenStdin)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		if ghToken == "" {
+			ghToken = getGitHubToken()
+		}
+		if ghToken == "" {
+			var why string
+			if useGH, why = detectGH(); useGH {
+				fmt.Println("ℹ No GitHub token found; using the gh CLI for API calls and git credentials")
+			} else {
+				fmt.Printf("⚠ Warning: No GitHub token found and %s. Run 'gh auth login' first.\n", why)
+				fmt.Println("  Continuing without token (repo creation will fail)...")
+			}
+		}
+		if hasAPI() {
+			if err := checkTokenAccess(); err != nil {
+				fmt.Printf("✗ %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	// The account is needed to push or call the API; bundles and token-less
+	// dry runs only commit locally
+	if exportDir == "" && (hasAPI() || !dryRun) {
+		if err := requireUser(); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Collect directories to process
-	var dirs []string
-	if *inputFile != "" {
-		dirs = readDirsFromFile(*inputFile)
+	var planned []DirJob
+	if planMode == "apply" {
+		var err error
+		if planned, err = applyPlan(); err != nil {
+			fmt.Printf("✗ %v\n", redact(err.Error()))
+			os.Exit(1)
+		}
+		if len(planned) == 0 {
+			fmt.Println("Nothing left to push")
+			os.Exit(0)
+		}
 	} else {
-		dirs = scanDirectories(*inputDir, *depth)
+		if *inputFile != "" {
+			listed, err := readPathList(normalizePath(*inputFile))
+			if err != nil {
+				fmt.Printf("✗ %v\n", err)
+				os.Exit(1)
+			}
+			planned = append(planned, listed...)
+		}
+		planned = append(planned, collectRoots(inputDirs, *depth, *level)...)
+		planned = dedupeJobs(planned)
+		planned = withoutExcluded(planned)
+		planned = filterJobsByAge(planned, modifiedSince.t, modifiedBefore.t)
+		if pushWikis {
+			planned = withoutWikiDirs(planned)
+		}
 	}
 
-	if len(dirs) == 0 {
-		fmt.Println("No directories found to process")
+	if agentURL != "" && dryRun {
+		fmt.Println("✗ -agent reports results to the queue and can't be combined with -dry-run")
+		os.Exit(1)
+	}
+	if agentURL != "" && planMode != "" {
+		fmt.Println("✗ -agent takes its directories from the queue and can't be planned")
+		os.Exit(1)
+	}
+	if planMode == "plan" {
+		if err := writePlan(os.Args[1:], planned, inputDirs); err != nil {
+			fmt.Printf("✗ %v\n", redact(err.Error()))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if agentURL != "" {
+		// Agents take their directories from the queue, and claim them too
+		planned = nil
+		coordinatorURL = agentURL
+	}
+	if len(planned) == 0 && agentURL == "" {
+		fmt.Println(T("No directories found to process"))
 		os.Exit(1)
 	}
 
+	runID = newRunID()
+	pruneLiveProgress()
+
 	// Initialize stats
 	stats = Stats{
-		Total:     int64(len(dirs)),
+		Total:     int64(len(planned)),
 		StartTime: time.Now(),
 	}
 
 	fmt.Printf("\n")
-	fmt.Printf("╔══════════════════════════════════════════════════════════════╗\n")
-	fmt.Printf("║  GitMax - Ultra-Fast Parallel GitHub Pusher                  ║\n")
-	fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
-	fmt.Printf("║  Directories: %-46d ║\n", len(dirs))
-	fmt.Printf("║  Workers:     %-46d ║\n", *workers)
-	fmt.Printf("║  Dry Run:     %-46v ║\n", dryRun)
-	fmt.Printf("╚══════════════════════════════════════════════════════════════╝\n")
+	boxTop()
+	boxTitle("GitMax - Ultra-Fast Parallel GitHub Pusher", false)
+	boxDivider()
+	if agentURL != "" {
+		boxRow(13, "Agent of", agentURL)
+	} else {
+		boxRow(13, "Directories", formatInt(int64(len(planned))))
+	}
+	if len(inputDirs) > 1 {
+		boxRow(13, "Roots", formatInt(int64(len(inputDirs))))
+	}
+	boxRow(13, "Workers", formatInt(int64(*workers)))
+	boxRow(13, "Dry Run", yesNo(dryRun))
+	boxRow(13, "Run ID", runID)
+	if exportDir != "" {
+		boxRow(13, "Export To", exportDir)
+	}
+	boxBottom()
 	fmt.Printf("\n")
 
+	if apiEngine {
+		loadBlobCache()
+	}
+
+	if eventsTarget != "" {
+		if err := openEvents(eventsTarget); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		emitEvent(Event{Type: "run_started", Total: stats.Total})
+	}
+
+	if *controlAddr != "" {
+		if err := startControlServer(*controlAddr); err != nil {
+			fmt.Printf("✗ Control API: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stopWatch := make(chan struct{})
+	if statusCheck && !dryRun && exportDir == "" {
+		outage.check()
+		go outage.watch(stopWatch)
+	}
+	if coordinating() {
+		if err := checkCoordinator(); err != nil {
+			fmt.Printf("✗ Coordinator %s: %v\n", coordinatorURL, err)
+			os.Exit(1)
+		}
+		go renewClaims(stopWatch)
+	}
+
 	// Create job channel
-	jobs := make(chan DirJob, len(dirs))
-	results := make(chan Result, len(dirs))
+	jobs := make(chan DirJob, len(planned))
+	resultCh := make(chan Result, len(planned))
 
 	// Start workers
+	initWorkerClocks(*workers)
 	var wg sync.WaitGroup
 	for i := 0; i < *workers; i++ {
 		wg.Add(1)
-		go worker(i, jobs, results, &wg)
+		go worker(i, jobs, resultCh, &wg)
 	}
 
 	// Start progress reporter
@@ -139,30 +536,106 @@ func main() {
 	go progressReporter(done)
 
 	// Queue jobs
-	for _, dir := range dirs {
-		repoName := pathToRepoName(dir)
-		jobs <- DirJob{Path: dir, RepoName: repoName}
+	if agentURL != "" {
+		go feedAgentJobs(jobs)
+	} else {
+		for _, job := range planned {
+			jobs <- job
+		}
+		close(jobs)
 	}
-	close(jobs)
 
 	// Collect results in background
+	collected := make(chan bool)
 	go func() {
-		for range results {
-			// Results processed by worker
+		for result := range resultCh {
+			results = append(results, result)
+			if agentURL != "" {
+				reportAgentResult(result)
+			}
 		}
+		collected <- true
 	}()
 
 	// Wait for workers
 	wg.Wait()
-	close(results)
+	close(stopWatch)
+	close(resultCh)
+	<-collected
 	done <- true
+	writeLiveProgress(true)
+	emitEvent(Event{Type: "run_completed", Total: stats.Total, Success: stats.Success, Failed: stats.Failed,
+		Skipped: stats.Skipped, Seconds: time.Since(stats.StartTime).Seconds()})
+	closeEvents()
+	saveBlobCache()
+	if !dryRun && exportDir == "" {
+		if err := saveState(); err != nil {
+			fmt.Printf("⚠ Could not save state: %v\n", err)
+		}
+		if err := saveRunRecord(); err != nil {
+			fmt.Printf("⚠ Could not save run record: %v\n", err)
+		}
+		if err := appendHistory(); err != nil {
+			fmt.Printf("⚠ Could not update run history: %v\n", err)
+		}
+		if err := writeGCSuggestions(); err != nil {
+			fmt.Printf("⚠ Could not write GC suggestions: %v\n", err)
+		}
+	}
+	waitAlerts()
 
 	// Print final stats
-	printFinalStats()
+	printFinalStats(*workers)
+
+	// A dry run leaves nothing behind unless a summary path was asked for
+	summaryGiven := false
+	flag.Visit(func(f *flag.Flag) { summaryGiven = summaryGiven || f.Name == "summary" })
+	if *summaryFile != "" && (!dryRun || summaryGiven) {
+		if err := writeSummary(*summaryFile); err != nil {
+			fmt.Printf("⚠ Could not write summary: %v\n", err)
+		}
+	}
+}
+
+// readToken reads a token passed out of band, so CI secrets stay out of the
+// environment and the process listing.
+func readToken(file string, stdin bool) (string, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("reading token file: %v", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	if stdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading token from stdin: %v", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	return "", nil
+}
+
+// redact masks the token wherever it might leak into output.
+func redact(s string) string {
+	if ghToken == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, ghToken, "***")
 }
 
 func getGitHubToken() string {
-	// Try gh CLI first
+	// A variable named in the config file comes first
+	if config.TokenEnv != "" {
+		if token := os.Getenv(config.TokenEnv); token != "" {
+			return token
+		}
+	}
+
+	// Then gh CLI
 	cmd := exec.Command("gh", "auth", "token")
 	output, err := cmd.Output()
 	if err == nil {
@@ -177,23 +650,13 @@ func getGitHubToken() string {
 	return ""
 }
 
-func readDirsFromFile(filename string) []string {
-	file, err := os.Open(filename)
+// gitmaxDir is where gitmax keeps caches and state between runs.
+func gitmaxDir() string {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		fmt.Printf("Error opening file: %v\n", err)
-		return nil
+		return ".gitmax"
 	}
-	defer file.Close()
-
-	var dirs []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" && !strings.HasPrefix(line, "#") {
-			dirs = append(dirs, line)
-		}
-	}
-	return dirs
+	return filepath.Join(home, ".gitmax")
 }
 
 func scanDirectories(root string, maxDepth int) []string {
@@ -219,6 +682,9 @@ func scanDirectories(root string, maxDepth int) []string {
 			if info.Name() == ".git" {
 				return filepath.SkipDir
 			}
+			if path != root && (isJunk(info.Name()) || !includeHiddenDirs && isHidden(info.Name())) {
+				return filepath.SkipDir
+			}
 			dirs = append(dirs, path)
 		}
 		return nil
@@ -227,6 +693,22 @@ func scanDirectories(root string, maxDepth int) []string {
 	return dirs
 }
 
+// levelDirectories returns only the directories exactly level steps below
+// root, e.g. level 1 for one repo per immediate child.
+func levelDirectories(root string, level int) []string {
+	var dirs []string
+	for _, dir := range scanDirectories(root, level) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." {
+			continue
+		}
+		if len(strings.Split(rel, string(os.PathSeparator))) == level {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
 func pathToRepoName(path string) string {
 	// Get the folder name
 	name := filepath.Base(path)
@@ -255,21 +737,126 @@ func worker(id int, jobs <-chan DirJob, results chan<- Result, wg *sync.WaitGrou
 	defer wg.Done()
 
 	for job := range jobs {
-		result := processDirectory(job)
+		paused := time.Now()
+		breaker.wait()
+		outage.wait()
+		if d := time.Since(paused); d > time.Millisecond {
+			recordWorkerStage(id, stageWait, d)
+			recordBusy(id, d)
+		}
+		start := time.Now()
+		var result Result
+		if reason := abortReason(); reason != "" {
+			result = Result{Path: job.Path, RepoName: job.RepoName, Skipped: true, Message: reason}
+		} else {
+			emitEvent(Event{Type: "job_started", Path: job.Path, Repo: job.owner() + "/" + job.RepoName})
+			result = runJob(id, job)
+		}
+		result.Duration = time.Since(start)
+		recordBusy(id, result.Duration)
+		result.Root = job.Root
+		result.Owner = job.owner()
+		result.Branch = job.branch()
+		if result.Success && !dryRun && exportDir == "" {
+			finishPush(job, result)
+		}
+		result.Message = redact(result.Message)
+		emitEvent(pushFinishedEvent(job, result))
 		results <- result
 
 		// Update stats
 		atomic.AddInt64(&stats.Completed, 1)
-		if result.Success {
+		if result.Skipped {
+			atomic.AddInt64(&stats.Skipped, 1)
+		} else if result.Success {
 			atomic.AddInt64(&stats.Success, 1)
 		} else {
 			atomic.AddInt64(&stats.Failed, 1)
 		}
+		checkAlerts(result)
+		breaker.record(result)
+		recordEvent(result)
 	}
 }
 
+// runJob processes a directory while it is visible to stuck detection. A
+// job killed for being stuck is retried once.
+func runJob(worker int, job DirJob) Result {
+	var result Result
+	if isCancelled(job.Path) {
+		return Result{Path: job.Path, RepoName: job.RepoName, Skipped: true, Message: "cancelled"}
+	}
+	if coordinating() {
+		reason, err := claimRepo(job)
+		if err != nil {
+			return Result{Path: job.Path, RepoName: job.RepoName, Message: fmt.Sprintf("can't claim the repo: %v", err)}
+		}
+		if reason != "" {
+			return Result{Path: job.Path, RepoName: job.RepoName, Skipped: true, Message: reason}
+		}
+		defer func() { releaseRepo(job, result.Success) }()
+	}
+	var transcript strings.Builder
+	for attempt := 0; attempt < 2; attempt++ {
+		active := startJob(job.Path, job.owner()+"/"+job.RepoName, worker)
+		start := time.Now()
+		result = safeProcessDirectory(job)
+		finishJob(active, time.Since(start))
+		active.mu.Lock()
+		transcript.Write(active.log.Bytes())
+		active.mu.Unlock()
+
+		// A push that finished before the cancel or kill landed is kept and
+		// recorded, so undo can still revert it
+		if isCancelled(job.Path) && !result.Success {
+			result.Skipped = true
+			result.Message = "cancelled"
+			break
+		}
+		if result.Success || atomic.LoadInt32(&active.killed) == 0 {
+			break
+		}
+		result.Message = "killed after running too long: " + result.Message
+	}
+	if !dryRun {
+		saveFailure(job, result, transcript.String())
+	}
+	return result
+}
+
+// maxPanicRetries is how often a job that panicked is run again
+const maxPanicRetries = 1
+
+// safeProcessDirectory keeps a panic in one job from killing its worker,
+// which would leave the WaitGroup waiting forever. The job is retried once,
+// then reported as failed.
+func safeProcessDirectory(job DirJob) Result {
+	var result Result
+	for attempt := 0; attempt <= maxPanicRetries; attempt++ {
+		var panicked bool
+		result, panicked = recoverProcessDirectory(job)
+		if !panicked {
+			break
+		}
+	}
+	return result
+}
+
+func recoverProcessDirectory(job DirJob) (result Result, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			result = Result{Path: job.Path, RepoName: job.RepoName, Message: fmt.Sprintf("panic: %v", r)}
+			if verbose {
+				fmt.Printf("panic processing %s: %v\n%s\n", job.Path, r, debug.Stack())
+			}
+		}
+	}()
+	return processDirectory(job), false
+}
+
 func processDirectory(job DirJob) Result {
-	result := Result{Path: job.Path}
+	result := Result{Path: job.Path, RepoName: job.RepoName}
 
 	// Check if directory exists
 	if _, err := os.Stat(job.Path); os.IsNotExist(err) {
@@ -277,74 +864,339 @@ func processDirectory(job DirJob) Result {
 		return result
 	}
 
-	if dryRun {
-		result.Success = true
-		result.Message = "Dry run - would push"
-		result.RepoURL = fmt.Sprintf("https://github.com/%s/%s", GitHubUsername, job.RepoName)
+	if linked := linkedCheckout(job.Path); linked != "" {
+		result.Skipped = true
+		result.Message = linked
+		return result
+	}
+	if !rehost {
+		if origin := foreignOrigin(job); origin != "" {
+			result.Skipped = true
+			result.Foreign = origin
+			result.Message = fmt.Sprintf("clone of %s; pass -rehost to push it to %s/%s", redact(origin), job.owner(), job.RepoName)
+			return result
+		}
+	}
+
+	job, result, ok := checkExisting(job, result)
+	if !ok {
+		return result
+	}
+	if err := lintCommits(job); err != nil {
+		result.Message = err.Error()
 		return result
 	}
 
-	// 1. Clean and init git
-	gitDir := filepath.Join(job.Path, ".git")
-	os.RemoveAll(gitDir)
+	if dryRun {
+		return dryRunCheck(job, result)
+	}
 
-	if err := runGit(job.Path, "init", "-b", "main"); err != nil {
-		result.Message = fmt.Sprintf("git init failed: %v", err)
+	if err := writeRepoMetadata(job); err != nil {
+		result.Message = fmt.Sprintf("writing %s failed: %v", MetadataFile, err)
 		return result
 	}
+	if err := writeGitHubMeta(job); err != nil {
+		result.Message = fmt.Sprintf("exporting GitHub metadata failed: %v", err)
+		return result
+	}
+	if err := writeReadmeFooter(job); err != nil {
+		result.Message = fmt.Sprintf("writing the README footer failed: %v", err)
+		return result
+	}
+
+	reused := reuseRepo(job.Path)
+
+	// Small directories skip local git entirely, unless there are other
+	// destinations to push the local commit to
+	if apiEngine && hasAPI() && !reused && exportDir == "" && len(extraRemotes) == 0 && len(providers) == 0 && job.Branch == "" &&
+		branchesMode == "" && !recordAttrs && !portableNames && (!pushWikis || wikiSource(job.Path) == "") {
+		if files, ok := collectAPIFiles(job.Path); ok {
+			return pushViaAPI(job, files, result)
+		}
+	}
+
+	// 1. Clean and init git, unless -incremental keeps the repo
+	var saved string
+	if !reused {
+		var err error
+		if saved, err = saveBranches(job); err != nil {
+			result.Message = fmt.Sprintf("saving branches failed: %v", err)
+			return result
+		}
+		if saved != "" {
+			defer os.Remove(saved)
+		}
+		gitDir := filepath.Join(job.Path, ".git")
+		os.RemoveAll(gitDir)
 
-	// Configure git
-	runGit(job.Path, "config", "user.name", GitHubUsername)
-	runGit(job.Path, "config", "user.email", GitHubUsername+"@users.noreply.github.com")
-	runGit(job.Path, "config", "core.autocrlf", "false")
+		if err := runGit(job.Path, "init", "-b", "main"); err != nil {
+			result.Message = fmt.Sprintf("git init failed: %v", err)
+			return result
+		}
+
+		// Configure git
+		name, email := commitIdentity()
+		runGit(job.Path, "config", "user.name", name)
+		runGit(job.Path, "config", "user.email", email)
+		runGit(job.Path, "config", "core.autocrlf", "false")
+		runGit(job.Path, "config", "gitmax.managed", "true")
+	}
 
 	// 2. Create .gitignore for large files
-	createGitignore(job.Path)
+	var excluded []string
+	result.LargeFiles, excluded = createGitignore(job.Path)
+	if offloadLarge && len(excluded) > 0 {
+		if err := offloadFiles(job, excluded); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	if reused {
+		if err := untrackExcluded(job.Path, excluded); err != nil {
+			result.Message = fmt.Sprintf("untracking large files failed: %v", err)
+			return result
+		}
+	}
 
-	// 3. Stage all files
-	if err := runGit(job.Path, "add", "-A"); err != nil {
-		result.Message = fmt.Sprintf("git add failed: %v", err)
-		return result
+	// 3. Stage and commit all files, streamed for huge directories. A kept
+	// repo's commit goes on top of its HEAD, which fast-import doesn't do.
+	imported := false
+	if !reused {
+		var err error
+		if imported, err = commitFastImport(job); err != nil {
+			result.Message = err.Error()
+			return result
+		}
 	}
+	if !imported {
+		if err := prehashObjects(job.Path); err != nil && verbose {
+			fmt.Printf("parallel hashing in %s failed: %v\n", job.Path, err)
+		}
+		if err := stageFiles(job.Path); err != nil {
+			result.Message = fmt.Sprintf("git add failed: %v", err)
+			return result
+		}
+		if err := writeAttrs(job.Path); err != nil {
+			result.Message = fmt.Sprintf("recording file attributes failed: %v", err)
+			return result
+		}
+		if err := renameStagedUnportable(job.Path); err != nil {
+			result.Message = fmt.Sprintf("renaming unportable files failed: %v", err)
+			return result
+		}
 
-	// 4. Commit
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	runGit(job.Path, "commit", "-m", fmt.Sprintf("Auto commit %s", timestamp), "--allow-empty")
+		// 4. Commit
+		if reused {
+			if _, err := commitChanges(job); err != nil {
+				result.Message = fmt.Sprintf("git commit failed: %v", err)
+				return result
+			}
+		} else {
+			runGit(job.Path, "commit", "-m", commitMessage(job), "--allow-empty")
+		}
+	}
+
+	if exportDir != "" {
+		return exportBundle(job, result)
+	}
 
 	// 5. Create GitHub repo if needed
-	repoURL := fmt.Sprintf("https://github.com/%s/%s.git", GitHubUsername, job.RepoName)
-	ensureGitHubRepo(job.RepoName)
+	created, err := ensureGitHubRepo(job)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	result.Created = created
+	if created {
+		emitEvent(Event{Type: "repo_created", Path: job.Path, Repo: job.owner() + "/" + job.RepoName})
+	}
+	if fineGrainedToken {
+		if err := verifyPushAccess(job.owner(), job.RepoName); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
 
 	// 6. Add remote and push
-	runGit(job.Path, "remote", "remove", "origin")
-	runGit(job.Path, "remote", "add", "origin", repoURL)
-	runGit(job.Path, "branch", "-M", "main")
+	transport, prevSHA, err := connectOrigin(job)
+	if err != nil {
+		result.Message = fmt.Sprintf("configuring remote failed: %v", err)
+		return result
+	}
+	pushArgs := []string{"push", "--set-upstream", pushRemote(), "main:" + job.branch()}
+	if reused {
+		// Without --set-upstream: the kept repo's branches track what
+		// their owner set up
+		pushArgs = append([]string{"push", pushRemote(), "HEAD:refs/heads/" + job.branch()}, keptBranchSpecs(job)...)
+	} else {
+		runGit(job.Path, "branch", "-M", "main")
+		kept, err := restoreBranches(job, saved)
+		if err != nil {
+			result.Message = fmt.Sprintf("restoring branches failed: %v", err)
+			return result
+		}
+		pushArgs = append(pushArgs, kept...)
+	}
+	result.PrevSHA = prevSHA
+	if reused && !created && !mergeRemote {
+		// Kept history is only ever extended
+		if err := checkFastForward(job, prevSHA); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	} else if result.PrevSHA != "" {
+		// A repo we just created should be empty; if GitHub initialized it
+		// (README, license) or the user asked for it, merge instead of
+		// overwriting. Otherwise keep the snapshot semantics.
+		if created || mergeRemote {
+			if err := mergeRemoteMain(job); err != nil {
+				result.Message = fmt.Sprintf("merge with remote failed: %v", err)
+				return result
+			}
+			if keepGenerations > 0 {
+				rewritten, err := trimGenerations(job.Path, keepGenerations)
+				if err != nil {
+					result.Message = fmt.Sprintf("trimming history failed: %v", err)
+					return result
+				}
+				if rewritten {
+					if err := trashRef(job.owner(), job.RepoName, job.branch(), result.PrevSHA); err != nil {
+						result.Message = fmt.Sprintf("saving overwritten history failed: %v", err)
+						return result
+					}
+					pushArgs = append(pushArgs, "--force")
+					result.Forced = true
+				}
+			}
+		} else {
+			if err := trashRef(job.owner(), job.RepoName, job.branch(), result.PrevSHA); err != nil {
+				result.Message = fmt.Sprintf("saving overwritten history failed: %v", err)
+				return result
+			}
+			pushArgs = append(pushArgs, "--force")
+			result.Forced = true
+		}
+	}
 
-	if err := runGit(job.Path, "push", "--set-upstream", "origin", "main", "--force"); err != nil {
+	var segmented pushTransfer
+	var join string
+	if !reused {
+		// Segments are parentless commits of the index, for a first push
+		segmented, transport, join, err = pushCheckpoints(job, transport)
+		if err != nil {
+			result.Transfer = segmented
+			result.Transport = transport
+			result.Message = fmt.Sprintf("git push failed: %v", err)
+			return result
+		}
+	}
+	if join != "" {
+		pushArgs = append(pushArgs, join)
+	}
+	transfer, transport, err := pushOrigin(job, transport, pushArgs...)
+	transfer.Bytes += segmented.Bytes
+	transfer.Objects += segmented.Objects
+	result.Transfer = transfer
+	result.Transport = transport
+	if err != nil {
 		result.Message = fmt.Sprintf("git push failed: %v", err)
 		return result
 	}
+	// GitHub has the new history from here on; the run record keeps it
+	// undoable even if a later destination fails
+	result.PushedSHA, _ = runGitOutput(job.Path, "rev-parse", "HEAD")
+	if join != "" {
+		dropCheckpoints(job)
+	}
+	if pushWikis {
+		if err := pushWiki(job, transport); err != nil {
+			result.Message = err.Error()
+			return result
+		}
+	}
+	if err := pushExtraRemotes(job); err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if err := pushProviders(job); err != nil {
+		result.Message = err.Error()
+		return result
+	}
 
 	result.Success = true
 	result.Message = "Success"
-	result.RepoURL = strings.TrimSuffix(repoURL, ".git")
+	result.RepoURL = fmt.Sprintf("https://github.com/%s/%s", job.owner(), job.RepoName)
 	return result
 }
 
+// finishPush records a successful push in state and tags the repo with the
+// run's labels.
+func finishPush(job DirJob, result Result) {
+	recordPush(job, result)
+	afterForcePush(job, result)
+	if labelTags && len(runLabels) > 0 {
+		if err := addRepoTopics(job.owner(), job.RepoName, runLabels...); err != nil && verbose {
+			fmt.Printf("labelling %s failed: %v\n", job.RepoName, err)
+		}
+	}
+}
+
 func runGit(dir string, args ...string) error {
-	cmd := exec.Command("git", args...)
-	cmd.Dir = dir
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
-	
-	output, err := cmd.CombinedOutput()
+	cmd := gitCommand(dir, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := runTracked(cmd)
+	logGit(dir, args, output.String(), err)
 	if err != nil && verbose {
-		fmt.Printf("git %s in %s: %s\n", strings.Join(args, " "), dir, string(output))
+		fmt.Print(redact(fmt.Sprintf("git %s in %s: %s\n", strings.Join(args, " "), dir, output.String())))
 	}
 	return err
 }
 
-func createGitignore(dir string) {
+func runGitOutput(dir string, args ...string) (string, error) {
+	cmd := gitCommand(dir, args...)
+	var output, stderr bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+
+	err := runTracked(cmd)
+	logGit(dir, args, stderr.String(), err)
+	if err != nil && verbose {
+		fmt.Print(redact(fmt.Sprintf("git %s in %s: %v\n", strings.Join(args, " "), dir, err)))
+	}
+	return strings.TrimSpace(output.String()), err
+}
+
+// mergeRemoteMain pulls in the remote branch (typically a README created
+// by auto_init) so the following push is a fast-forward. Local content wins
+// on conflicts.
+func mergeRemoteMain(job DirJob) error {
+	if err := runGit(job.Path, "fetch", pushRemote(), job.branch()); err != nil {
+		return err
+	}
+	return runGit(job.Path, "merge", "--allow-unrelated-histories", "-X", "ours",
+		"-m", mergeSubject(job)+"\n\n"+commitTrailers(job), "FETCH_HEAD")
+}
+
+// commitMessage is the message of every snapshot commit. The trailers let
+// undo and audits recognize gitmax commits on the remote side.
+func commitMessage(job DirJob) string {
+	return commitSubject(job) + "\n\n" + commitTrailers(job)
+}
+
+func commitTrailers(job DirJob) string {
+	return fmt.Sprintf("Gitmax-Run: %s\nGitmax-Source: %s", runID, job.Path)
+}
+
+// createGitignore excludes files above the size limit. It returns the
+// files above the warning tier that are still pushed, and the excluded ones.
+func createGitignore(dir string) ([]string, []string) {
 	var largeFiles []string
+	var warnFiles []string
+	tally := newIgnoreTally()
+	ignored := ignoredPaths(dir)
 
 	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -352,70 +1204,65 @@ func createGitignore(dir string) {
 		}
 
 		// Skip .git
-		if strings.Contains(path, ".git") {
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, _ := filepath.Rel(dir, path)
+		// Use forward slashes for .gitignore
+		rel = strings.ReplaceAll(rel, "\\", "/")
+		if info.IsDir() {
+			if ignored[rel+"/"] || rel != "." && isJunk(info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored[rel] || isJunk(info.Name()) {
 			return nil
 		}
 
-		if !info.IsDir() && info.Size() > GitHubFileLimit {
-			rel, _ := filepath.Rel(dir, path)
-			// Use forward slashes for .gitignore
-			rel = strings.ReplaceAll(rel, "\\", "/")
+		tally.add(rel, info.Size() > maxFileSize)
+		if info.Size() > maxFileSize {
 			largeFiles = append(largeFiles, rel)
+		} else if info.Size() > warnFileSize {
+			warnFiles = append(warnFiles, rel)
 		}
 		return nil
 	})
 
-	if len(largeFiles) > 0 {
-		gitignorePath := filepath.Join(dir, ".gitignore")
-		content := ""
-		
-		// Read existing
-		if data, err := ioutil.ReadFile(gitignorePath); err == nil {
-			content = string(data)
-		}
-
-		// Append large files
-		content += "\n# gitit: auto-excluded large files (>100MB)\n"
-		for _, f := range largeFiles {
-			content += f + "\n"
-		}
+	writeGitignoreSection(dir, tally.rules(largeFiles))
 
-		ioutil.WriteFile(gitignorePath, []byte(content), 0644)
-	}
+	return warnFiles, largeFiles
 }
 
-func ensureGitHubRepo(repoName string) {
-	if ghToken == "" {
-		// Try using gh CLI
-		exec.Command("gh", "repo", "create", GitHubUsername+"/"+repoName, "--public").Run()
-		return
+// writeGitignoreSection replaces gitmax's section of the directory's
+// .gitignore with rules, minus those the user's part already has, so
+// repeated runs don't stack sections. Without rules the section goes, and
+// so does a .gitignore left empty. An unchanged file isn't rewritten.
+func writeGitignoreSection(dir string, rules []string) {
+	gitignorePath := filepath.Join(dir, ".gitignore")
+	original := ""
+	if data, err := ioutil.ReadFile(gitignorePath); err == nil {
+		original = string(data)
 	}
 
-	// Check if repo exists
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s", GitHubUsername, repoName)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "token "+ghToken)
-	
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return
+	content, _ := stripGitmaxSections(original)
+	if rules = newIgnoreRules(content, rules); len(rules) > 0 {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += fmt.Sprintf("\n%s (>%dMB)\n", gitignoreBegin, maxFileSize/(1024*1024))
+		for _, rule := range rules {
+			content += rule + "\n"
+		}
+		content += gitignoreEnd + "\n"
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 404 {
-		// Create repo
-		createURL := "https://api.github.com/user/repos"
-		body := fmt.Sprintf(`{"name":"%s","private":false}`, repoName)
-		req, _ := http.NewRequest("POST", createURL, strings.NewReader(body))
-		req.Header.Set("Authorization", "token "+ghToken)
-		req.Header.Set("Content-Type", "application/json")
-		
-		resp, err := client.Do(req)
-		if err == nil {
-			resp.Body.Close()
-		}
-		time.Sleep(500 * time.Millisecond) // Rate limit buffer
+	switch {
+	case content == original:
+	case strings.TrimSpace(content) == "":
+		os.Remove(gitignorePath)
+	default:
+		ioutil.WriteFile(gitignorePath, []byte(content), 0644)
 	}
 }
 
@@ -426,71 +1273,155 @@ func progressReporter(done chan bool) {
 	for {
 		select {
 		case <-done:
+			printProgress("")
+			endProgress()
 			return
 		case <-ticker.C:
-			printProgress()
+			printProgress(checkStuck())
+			writeLiveProgress(false)
 		}
 	}
 }
 
-func printProgress() {
-	completed := atomic.LoadInt64(&stats.Completed)
-	success := atomic.LoadInt64(&stats.Success)
-	failed := atomic.LoadInt64(&stats.Failed)
-	total := stats.Total
-	
-	elapsed := time.Since(stats.StartTime)
-	
+func printProgress(status string) {
+	total := atomic.LoadInt64(&stats.Total)
 	if total == 0 {
 		return
 	}
+	width := terminalWidth()
+	room := 0
+	if width > 0 {
+		room = width - 1 - utf8.RuneCountInString(status)
+	}
+	drawProgress(progressLine(atomic.LoadInt64(&stats.Completed), atomic.LoadInt64(&stats.Success),
+		atomic.LoadInt64(&stats.Failed), total, atomic.LoadInt64(&stats.BytesPushed), time.Since(stats.StartTime), room)+status, width)
+}
 
+// progressLine renders the progress bar; status --attach draws it from a
+// run's live progress file. The bar shrinks to fit width characters, down
+// to a minimum; 0 means no limit.
+func progressLine(completed, success, failed, total, pushed int64, elapsed time.Duration, width int) string {
 	percent := float64(completed) / float64(total) * 100
-	barWidth := 40
-	filled := int(float64(barWidth) * float64(completed) / float64(total))
-	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
 
 	// Calculate ETA
-	eta := "calculating..."
+	eta := T("calculating...")
 	if completed > 0 {
 		rate := float64(completed) / elapsed.Seconds()
-		remaining := float64(total - completed) / rate
-		eta = fmt.Sprintf("%v", time.Duration(remaining)*time.Second)
+		remaining := float64(total-completed) / rate
+		eta = formatDuration(time.Duration(remaining) * time.Second)
 	}
 
 	// Speed
 	speed := float64(completed) / elapsed.Seconds()
+	throughput := formatBytes(int64(float64(pushed)/elapsed.Seconds())) + "/s"
 
-	fmt.Printf("\r[%s] %.1f%% | %d/%d | ✓%d ✗%d | %.1f/s | ETA: %s    ",
-		bar, percent, completed, total, success, failed, speed, eta)
+	info := fmt.Sprintf("%s%% | %d/%d | ✓%d ✗%d | %s/s | %s | %s: %s",
+		formatFloat(percent, 1), completed, total, success, failed, formatFloat(speed, 1), throughput, T("ETA"), eta)
+
+	barWidth := 40
+	if width > 0 {
+		barWidth = min(40, max(10, width-3-utf8.RuneCountInString(info)))
+	}
+	filled := int(float64(barWidth) * float64(completed) / float64(total))
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+	return "[" + bar + "] " + info
 }
 
-func printFinalStats() {
+func printFinalStats(workers int) {
 	elapsed := time.Since(stats.StartTime)
 	
 	fmt.Printf("\n\n")
-	fmt.Printf("╔══════════════════════════════════════════════════════════════╗\n")
-	fmt.Printf("║                      FINAL RESULTS                           ║\n")
-	fmt.Printf("╠══════════════════════════════════════════════════════════════╣\n")
-	fmt.Printf("║  Total Directories:  %-40d ║\n", stats.Total)
-	fmt.Printf("║  Successful:         %-40d ║\n", stats.Success)
-	fmt.Printf("║  Failed:             %-40d ║\n", stats.Failed)
-	fmt.Printf("║  Time Elapsed:       %-40s ║\n", elapsed.Round(time.Second))
+	boxTop()
+	boxTitle("FINAL RESULTS", true)
+	boxDivider()
+	boxRow(20, "Total Directories", formatInt(stats.Total))
+	boxRow(20, "Successful", formatInt(stats.Success))
+	boxRow(20, "Failed", formatInt(stats.Failed))
+	boxRow(20, "Time Elapsed", formatDuration(elapsed))
 	
 	if stats.Total > 0 && elapsed.Seconds() > 0 {
 		speed := float64(stats.Total) / elapsed.Seconds()
-		fmt.Printf("║  Average Speed:      %-40s ║\n", fmt.Sprintf("%.2f dirs/sec", speed))
+		boxRow(20, "Average Speed", fmt.Sprintf(T("%s dirs/sec"), formatFloat(speed, 2)))
+		pushed := atomic.LoadInt64(&stats.BytesPushed)
+		boxRow(20, "Data Pushed", fmt.Sprintf("%s (%s/s)", formatBytes(pushed),
+			formatBytes(int64(float64(pushed)/elapsed.Seconds()))))
 	}
 	
-	fmt.Printf("╚══════════════════════════════════════════════════════════════╝\n")
+	boxBottom()
+
+	printRootStats()
+	printPauses()
+	printFailures()
+	printForeignOrigins()
+	if dryRun {
+		printDryRunPlan()
+	}
+
+	// Large-but-allowed files, matching GitHub's own push warning
+	printLargeFiles()
 	
 	// Performance comparison
-	if !dryRun && stats.Total > 100 {
-		seqTime := float64(stats.Total) * 15.0 // ~15 seconds per dir sequentially
-		actualTime := elapsed.Seconds()
-		speedup := seqTime / actualTime
-		
-		fmt.Printf("\n⚡ Performance: %.1fx faster than sequential gitit\n", speedup)
-		fmt.Printf("   Sequential would take: ~%s\n", time.Duration(seqTime)*time.Second)
+	if !dryRun {
+		printPerformance(elapsed, workers)
+	}
+	printUtilization(elapsed, workers)
+}
+
+// printPerformance compares the run with processing its directories one
+// at a time. The baseline is the measured time of every job added up;
+// jobs running side by side slow each other down a little, so it
+// somewhat overstates the sequential time, and worker utilization shows
+// how much of the pool's capacity the run actually used.
+func printPerformance(elapsed time.Duration, workers int) {
+	var busy time.Duration
+	var jobs int64
+	for _, r := range results {
+		if !r.Skipped {
+			busy += r.Duration
+			jobs++
+		}
+	}
+	if jobs < 2 || elapsed <= 0 || workers < 1 {
+		return
+	}
+	speedup := busy.Seconds() / elapsed.Seconds()
+	utilization := 100 * busy.Seconds() / (elapsed.Seconds() * float64(workers))
+	fmt.Printf(T("\n⚡ Performance: %sx faster than one directory at a time\n"), formatFloat(speedup, 1))
+	fmt.Printf(T("   Sequential would take: ~%s (%s per directory, measured)\n"), formatDuration(busy),
+		formatDuration(busy/time.Duration(jobs)))
+	fmt.Printf(T("   Worker utilization: %s%% of %d workers\n"), formatFloat(utilization, 0), workers)
+}
+
+
+func printFailures() {
+	if atomic.LoadInt64(&stats.Failed) == 0 {
+		return
+	}
+
+	fmt.Printf(T("\n✗ Failures:\n"))
+	for _, r := range results {
+		if !r.Success && !r.Skipped {
+			fmt.Printf("   %s: %s\n", r.Path, r.Message)
+		}
+	}
+	if !dryRun {
+		fmt.Printf(T("   Full git output and API responses: %s\n"), failuresDir())
+	}
+}
+
+func printLargeFiles() {
+	var count int
+	for _, r := range results {
+		count += len(r.LargeFiles)
+	}
+	if count == 0 {
+		return
+	}
+
+	fmt.Printf(T("\n⚠ %d file(s) larger than %dMB were pushed:\n"), count, warnFileSize/(1024*1024))
+	for _, r := range results {
+		for _, f := range r.LargeFiles {
+			fmt.Printf("   %s\n", filepath.Join(r.Path, f))
+		}
 	}
 }
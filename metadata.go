@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// MetadataFile is committed into every repo so restore can rebuild the
+// original layout on another machine.
+const MetadataFile = ".gitmax.json"
+
+// RepoMetadata is the content of MetadataFile
+type RepoMetadata struct {
+	SourcePath   string    `json:"source_path"`
+	Root         string    `json:"root,omitempty"`          // Scan root the directory was found under
+	RelativePath string    `json:"relative_path,omitempty"` // Path below Root, slash separated
+	Hostname     string    `json:"hostname"`
+	OS           string    `json:"os"`
+	PushedAt     time.Time `json:"pushed_at"`
+	RunID        string    `json:"run_id,omitempty"`
+}
+
+var (
+	writeMetadata = true
+	hostname, _   = os.Hostname()
+)
+
+func newRepoMetadata(job DirJob) RepoMetadata {
+	meta := RepoMetadata{
+		SourcePath: job.Path,
+		Hostname:   hostname,
+		OS:         runtime.GOOS,
+		PushedAt:   time.Now().UTC(),
+		RunID:      runID,
+	}
+	if job.Root != "" {
+		if rel, err := filepath.Rel(job.Root, job.Path); err == nil {
+			meta.Root = job.Root
+			meta.RelativePath = filepath.ToSlash(rel)
+		}
+	}
+	return meta
+}
+
+// writeRepoMetadata writes MetadataFile into the directory before staging.
+func writeRepoMetadata(job DirJob) error {
+	if !writeMetadata {
+		return nil
+	}
+	data, err := json.MarshalIndent(newRepoMetadata(job), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(job.Path, MetadataFile), append(data, '\n'), 0644)
+}
+
+// readRepoMetadata parses MetadataFile content fetched from a repo.
+func readRepoMetadata(data []byte) (RepoMetadata, error) {
+	var meta RepoMetadata
+	err := json.Unmarshal(data, &meta)
+	return meta, err
+}
+
+// repoDescription is the provenance line used as a new repo's description.
+func repoDescription(job DirJob) string {
+	return "gitmax backup of " + hostname + ":" + job.Path
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files over -max-file-size can't go to GitHub. With -offload they are
+// uploaded to an S3-compatible bucket instead and listed in a manifest
+// committed to the repo, so restore can put them back.
+const OffloadManifest = ".gitmax-offload.json"
+
+var (
+	offloadLarge bool
+	s3Client     = &http.Client{} // Uploads of large files outlive apiClient's timeout
+)
+
+// S3Config is the s3: section of the config file
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // Default https://s3.<region>.amazonaws.com
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	PathStyle bool   `yaml:"path_style"` // Needed by MinIO and most non-AWS stores
+	AccessKey string `yaml:"access_key"` // Default: AWS_* variables or Profile
+	SecretKey string `yaml:"secret_key"`
+	Profile   string `yaml:"profile"`
+}
+
+// OffloadManifestFile is the content of OffloadManifest
+type OffloadManifestFile struct {
+	Version int           `json:"version"`
+	Bucket  string        `json:"bucket"`
+	Files   []OffloadFile `json:"files"`
+}
+
+// OffloadFile is one offloaded file
+type OffloadFile struct {
+	Path   string `json:"path"` // Slash-separated, relative to the repo root
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Key    string `json:"key"`
+}
+
+func (c S3Config) region() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+func (c S3Config) credentials() (awsCredentials, error) {
+	if c.AccessKey != "" {
+		return awsCredentials{AccessKey: c.AccessKey, SecretKey: c.SecretKey}, nil
+	}
+	return loadAWSCredentials(c.Profile)
+}
+
+func (c S3Config) objectURL(key string) string {
+	endpoint := strings.TrimSuffix(c.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region() + ".amazonaws.com"
+	}
+	if c.PathStyle {
+		return endpoint + "/" + c.Bucket + "/" + key
+	}
+	scheme, host := "https://", strings.TrimPrefix(endpoint, "https://")
+	if strings.HasPrefix(endpoint, "http://") {
+		scheme, host = "http://", strings.TrimPrefix(endpoint, "http://")
+	}
+	return scheme + c.Bucket + "." + host + "/" + key
+}
+
+// s3Do signs and sends a request. Bodies are sent unsigned so large files
+// can stream instead of being hashed up front.
+func s3Do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	return s3Send(method, key, nil, nil, body, size)
+}
+
+// s3Send is s3Do with query parameters and extra headers.
+func s3Send(method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	cfg := config.S3
+	creds, err := cfg.credentials()
+	if err != nil {
+		return nil, err
+	}
+	target := cfg.objectURL(key)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	payloadHash := "UNSIGNED-PAYLOAD"
+	if body == nil {
+		payloadHash = sha256Hex(nil)
+	}
+	if err := signV4(req, payloadHash, creds, cfg.region(), "s3", time.Now()); err != nil {
+		return nil, err
+	}
+	return s3Client.Do(req)
+}
+
+func s3Error(op string, resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &APIError{Op: op, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+}
+
+// offloadFiles uploads the excluded files of a directory and writes the
+// manifest. Objects are keyed by content, so unchanged files are only
+// checked, not uploaded again.
+func offloadFiles(job DirJob, rels []string) error {
+	if config.S3.Bucket == "" {
+		return errors.New("-offload needs an s3: bucket in the config file")
+	}
+	manifest := OffloadManifestFile{Version: 1, Bucket: config.S3.Bucket}
+	for _, rel := range rels {
+		abs := filepath.Join(job.Path, filepath.FromSlash(rel))
+		f, err := offloadFile(job, abs)
+		if err != nil {
+			return fmt.Errorf("offloading %s: %v", rel, err)
+		}
+		f.Path = rel
+		manifest.Files = append(manifest.Files, f)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(job.Path, OffloadManifest), data, 0644)
+}
+
+func offloadFile(job DirJob, abs string) (OffloadFile, error) {
+	info, err := os.Stat(abs)
+	if err != nil {
+		return OffloadFile{}, err
+	}
+	sum, err := fileSHA256(abs)
+	if err != nil {
+		return OffloadFile{}, err
+	}
+	key := strings.TrimPrefix(fmt.Sprintf("%s/%s/%s/%s", strings.Trim(config.S3.Prefix, "/"), job.owner(), job.RepoName, sum), "/")
+	entry := OffloadFile{Size: info.Size(), SHA256: sum, Key: key}
+
+	exists := false
+	err = withTransferRetry("checking "+key, func() error {
+		resp, err := s3Do("HEAD", key, nil, 0)
+		if err != nil {
+			return transportError("upload check", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 || resp.StatusCode == 429 {
+			return &APIError{Op: "upload check", StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		exists = resp.StatusCode == 200
+		return nil
+	})
+	if err != nil || exists {
+		return entry, err
+	}
+
+	if info.Size() >= multipartMinSize {
+		return entry, uploadMultipart(key, abs, info.Size())
+	}
+	return entry, withTransferRetry("uploading "+key, func() error {
+		file, err := os.Open(abs)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		resp, err := s3Do("PUT", key, file, info.Size())
+		if err != nil {
+			return transportError("upload", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return s3Error("upload", resp)
+		}
+		return nil
+	})
+}
+
+// restoreOffloaded downloads the files listed in a restored repo's
+// manifest and checks their hashes.
+func restoreOffloaded(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, OffloadManifest))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var manifest OffloadManifestFile
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("reading %s: %v", OffloadManifest, err)
+	}
+	if config.S3.Bucket != manifest.Bucket {
+		return fmt.Errorf("offloaded files are in bucket %q; configure it under s3: in the config file", manifest.Bucket)
+	}
+
+	for _, f := range manifest.Files {
+		if err := downloadOffloaded(dir, f); err != nil {
+			return fmt.Errorf("restoring %s: %v", f.Path, err)
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Offloaded files are mostly media of several GB, where one dropped
+// connection used to restart the whole transfer. Files from
+// multipartMinSize are uploaded in parts with an S3 multipart upload; one
+// cut short by an error or a killed run is found by its key on the next
+// run and continues after the parts the bucket already has. Downloads go
+// to a partial file next to the destination and continue from its size
+// with a Range request. Each request is retried with backoff, so a blip
+// costs one part, not the file.
+const (
+	multipartMinSize = 64 << 20
+	minPartSize      = 32 << 20
+	maxParts         = 10000
+	transferRetries  = 5
+	partialSuffix    = ".gitmax-partial"
+)
+
+// transferBackoff is the wait before the first retry; it doubles after
+// each failure up to maxTransferBackoff.
+var transferBackoff = 2 * time.Second
+
+const maxTransferBackoff = time.Minute
+
+// withTransferRetry runs fn until it succeeds, fails for good or has been
+// tried transferRetries times.
+func withTransferRetry(what string, fn func() error) error {
+	delay := transferBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == transferRetries || !retryableTransfer(err) {
+			return err
+		}
+		if verbose {
+			fmt.Printf("  %s failed (%v), retrying in %s\n", what, redact(err.Error()), delay)
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxTransferBackoff {
+			delay = maxTransferBackoff
+		}
+	}
+}
+
+// retryableTransfer reports whether err is a network failure or a status
+// the store may not return next time.
+func retryableTransfer(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch code := apiErr.StatusCode; {
+	case code == 0, code == 408, code == 429, code >= 500:
+		return true
+	}
+	return false
+}
+
+// partSize keeps parts at least minPartSize and their count within S3's
+// limit. It depends on the size only, so a resumed upload cuts the file
+// the same way.
+func partSize(size int64) int64 {
+	part := int64(minPartSize)
+	if need := (size + maxParts - 1) / maxParts; need > part {
+		part = (need + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	return part
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+// uploadMultipart uploads abs to key in parts, resuming an unfinished
+// upload of the same key.
+func uploadMultipart(key, abs string, size int64) error {
+	uploadID, err := pendingUpload(key)
+	if err != nil {
+		return err
+	}
+	done := map[int]s3Part{}
+	if uploadID == "" {
+		if uploadID, err = startUpload(key); err != nil {
+			return err
+		}
+	} else {
+		parts, err := uploadedParts(key, uploadID)
+		if err != nil {
+			return err
+		}
+		for _, p := range parts {
+			done[p.PartNumber] = p
+		}
+		if verbose && len(parts) > 0 {
+			fmt.Printf("  resuming upload of %s after %d parts\n", key, len(parts))
+		}
+	}
+
+	file, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	step := partSize(size)
+	var parts []s3Part
+	for n, offset := 1, int64(0); offset < size; n, offset = n+1, offset+step {
+		length := step
+		if offset+length > size {
+			length = size - offset
+		}
+		if p, ok := done[n]; ok && p.Size == length {
+			parts = append(parts, s3Part{PartNumber: n, ETag: p.ETag})
+			continue
+		}
+		var etag string
+		err := withTransferRetry(fmt.Sprintf("uploading part %d of %s", n, key), func() error {
+			query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+			resp, err := s3Send("PUT", key, query, nil, io.NewSectionReader(file, offset, length), length)
+			if err != nil {
+				return transportError("part upload", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return s3Error("part upload", resp)
+			}
+			etag = resp.Header.Get("ETag")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, s3Part{PartNumber: n, ETag: etag})
+	}
+	return completeUpload(key, uploadID, parts)
+}
+
+// pendingUpload returns the ID of the newest unfinished upload of key.
+func pendingUpload(key string) (string, error) {
+	var result struct {
+		Uploads []struct {
+			Key       string    `xml:"Key"`
+			UploadID  string    `xml:"UploadId"`
+			Initiated time.Time `xml:"Initiated"`
+		} `xml:"Upload"`
+	}
+	query := url.Values{"uploads": {""}, "prefix": {key}}
+	if err := s3XML("GET", "", query, nil, "listing uploads", &result); err != nil {
+		return "", err
+	}
+	id, newest := "", time.Time{}
+	for _, u := range result.Uploads {
+		if u.Key == key && (id == "" || u.Initiated.After(newest)) {
+			id, newest = u.UploadID, u.Initiated
+		}
+	}
+	return id, nil
+}
+
+func startUpload(key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s3XML("POST", key, url.Values{"uploads": {""}}, nil, "starting upload", &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("starting upload: no upload ID in the response")
+	}
+	return result.UploadID, nil
+}
+
+// uploadedParts lists the parts the bucket has of an unfinished upload.
+func uploadedParts(key, uploadID string) ([]s3Part, error) {
+	var parts []s3Part
+	marker := ""
+	for {
+		var result struct {
+			Parts       []s3Part `xml:"Part"`
+			IsTruncated bool     `xml:"IsTruncated"`
+			NextMarker  string   `xml:"NextPartNumberMarker"`
+		}
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		if err := s3XML("GET", key, query, nil, "listing parts", &result); err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func completeUpload(key, uploadID string, parts []s3Part) error {
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := s3XML("POST", key, url.Values{"uploadId": {uploadID}}, body, "completing upload", &result); err != nil {
+		return err
+	}
+	// S3 can report a failed completion with status 200
+	if result.XMLName.Local == "Error" {
+		return &APIError{Op: "completing upload", StatusCode: 200, Message: result.Code + ": " + result.Message}
+	}
+	return nil
+}
+
+// s3XML sends a request, retrying it, and decodes the XML response.
+func s3XML(method, key string, query url.Values, body []byte, op string, result interface{}) error {
+	return withTransferRetry(op+" for "+key, func() error {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		resp, err := s3Send(method, key, query, nil, reader, int64(len(body)))
+		if err != nil {
+			return transportError(op, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return s3Error(op, resp)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return transportError(op, err)
+		}
+		if err := xml.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("%s: %v", op, err)
+		}
+		return nil
+	})
+}
+
+// downloadOffloaded fetches one file into place, continuing a partial
+// download left by an earlier attempt, and checks its hash.
+func downloadOffloaded(dir string, f OffloadFile) error {
+	dest := filepath.Join(dir, filepath.FromSlash(f.Path))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	partial := dest + partialSuffix
+	err := withTransferRetry("downloading "+f.Key, func() error {
+		return resumeDownload(partial, f)
+	})
+	if err != nil {
+		return err
+	}
+	got, err := fileSHA256(partial)
+	if err != nil {
+		return err
+	}
+	if got != f.SHA256 {
+		os.Remove(partial)
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, f.SHA256)
+	}
+	return os.Rename(partial, dest)
+}
+
+// resumeDownload appends what partial lacks of the object.
+func resumeDownload(partial string, f OffloadFile) error {
+	out, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	info, err := out.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	if offset > f.Size {
+		offset = 0
+	}
+	if offset == f.Size && offset > 0 {
+		return nil
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s3Send("GET", f.Key, nil, header, nil, 0)
+	if err != nil {
+		return transportError("download", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		offset = 0
+	default:
+		return s3Error("download", resp)
+	}
+	if err := out.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return transportError("download", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// During a GitHub incident every push fails, so workers hold off while
+// githubstatus.com reports an outage of the components gitmax uses, or
+// while API calls keep coming back with 5xx. Pauses, including circuit
+// breaker ones, are listed in the final report and the summary.
+const (
+	githubStatusURL    = "https://www.githubstatus.com/api/v2/components.json"
+	statusPollInterval = time.Minute
+	serverErrorBurst   = 5 // 5xx responses within serverErrorWindow that pause the run
+	serverErrorWindow  = 30 * time.Second
+	serverErrorPause   = time.Minute
+)
+
+// statusComponents are the githubstatus.com components a run depends on
+var statusComponents = []string{"Git Operations", "API Requests"}
+
+var (
+	statusCheck bool
+	outage      = &outageMonitor{}
+
+	pausesMu sync.Mutex
+	pauses   []PauseWindow
+)
+
+// PauseWindow is a stretch of the run during which no jobs started
+type PauseWindow struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
+}
+
+func recordPause(start, end time.Time, reason string) {
+	pausesMu.Lock()
+	defer pausesMu.Unlock()
+	pauses = append(pauses, PauseWindow{Start: start, End: end, Reason: reason})
+}
+
+type outageMonitor struct {
+	mu          sync.Mutex
+	incident    string    // From the status page, "" when operational
+	errorsUntil time.Time // Pause after a burst of 5xx responses
+	recent      []time.Time
+	pausedSince time.Time
+	pauseReason string
+}
+
+// reason returns why jobs shouldn't start now, or "". Callers hold mu.
+func (m *outageMonitor) reason() string {
+	if m.incident != "" {
+		return m.incident
+	}
+	if time.Now().Before(m.errorsUntil) {
+		return fmt.Sprintf("%d GitHub 5xx responses within %s", serverErrorBurst, serverErrorWindow)
+	}
+	return ""
+}
+
+// update starts or ends the current pause window. Callers hold mu.
+func (m *outageMonitor) update() string {
+	reason := m.reason()
+	switch {
+	case reason != "" && m.pausedSince.IsZero():
+		m.pausedSince = time.Now()
+		m.pauseReason = reason
+		fmt.Printf("\n⏸ Pausing: %s\n", reason)
+	case reason == "" && !m.pausedSince.IsZero():
+		recordPause(m.pausedSince, time.Now(), m.pauseReason)
+		fmt.Printf("\n▶ Resuming after %s\n", time.Since(m.pausedSince).Round(time.Second))
+		m.pausedSince = time.Time{}
+	}
+	return reason
+}
+
+// wait blocks while GitHub looks unavailable.
+func (m *outageMonitor) wait() {
+	for {
+		m.mu.Lock()
+		reason := m.update()
+		m.mu.Unlock()
+		if reason == "" || abortReason() != "" {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// noteServerError is called for every 5xx GitHub API response.
+func (m *outageMonitor) noteServerError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	kept := m.recent[:0]
+	for _, t := range m.recent {
+		if now.Sub(t) < serverErrorWindow {
+			kept = append(kept, t)
+		}
+	}
+	m.recent = append(kept, now)
+	if len(m.recent) >= serverErrorBurst {
+		m.recent = nil
+		m.errorsUntil = now.Add(serverErrorPause)
+		m.update()
+	}
+}
+
+// check polls the status page. An unreachable status page is not an
+// outage: it says nothing about GitHub itself.
+func (m *outageMonitor) check() {
+	incident, err := githubIncident()
+	if err != nil {
+		if verbose {
+			fmt.Printf("status check failed: %v\n", err)
+		}
+		return
+	}
+	m.mu.Lock()
+	m.incident = incident
+	m.update()
+	m.mu.Unlock()
+}
+
+// watch polls the status page until stop is closed.
+func (m *outageMonitor) watch(stop chan struct{}) {
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			m.mu.Lock()
+			if !m.pausedSince.IsZero() {
+				recordPause(m.pausedSince, time.Now(), m.pauseReason)
+				m.pausedSince = time.Time{}
+			}
+			m.mu.Unlock()
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// githubIncident describes a partial or major outage of statusComponents,
+// or returns "" when they are operational or merely degraded.
+func githubIncident() (string, error) {
+	resp, err := apiClient.Get(githubStatusURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%s: HTTP %d", githubStatusURL, resp.StatusCode)
+	}
+
+	var body struct {
+		Components []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	var down []string
+	for _, c := range body.Components {
+		if containsString(statusComponents, c.Name) && strings.HasSuffix(c.Status, "_outage") {
+			down = append(down, c.Name+" "+strings.ReplaceAll(c.Status, "_", " "))
+		}
+	}
+	if len(down) == 0 {
+		return "", nil
+	}
+	return "githubstatus.com reports " + strings.Join(down, ", "), nil
+}
+
+// printPauses lists the run's pause windows.
+func printPauses() {
+	pausesMu.Lock()
+	defer pausesMu.Unlock()
+	if len(pauses) == 0 {
+		return
+	}
+	fmt.Printf(T("\n⏸ Paused %d time(s):\n"), len(pauses))
+	for _, p := range pauses {
+		fmt.Printf("   %s–%s (%s): %s\n", p.Start.Format("15:04:05"), p.End.Format("15:04:05"),
+			p.End.Sub(p.Start).Round(time.Second), p.Reason)
+	}
+}
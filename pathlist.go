@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readPathList reads the -f file. Each line is a directory optionally
+// followed by options that override what gitmax would derive for it:
+//
+//	/home/me/app repo=my-app private org=acme branch=release
+//
+// Options are taken from the end of the line, so paths containing spaces
+// keep working unquoted; a path can also be wrapped in double quotes.
+// Relative paths are taken from the working directory.
+func readPathList(filename string) ([]DirJob, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var jobs []DirJob
+	var problems []error
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		job, err := parsePathLine(line)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s:%d: %v", filename, n, err))
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(joinErrors(problems))
+	}
+	return jobs, nil
+}
+
+func parsePathLine(line string) (DirJob, error) {
+	var job DirJob
+	rest := line
+	if strings.HasPrefix(rest, `"`) {
+		end := strings.Index(rest[1:], `"`)
+		if end < 0 {
+			return job, fmt.Errorf("unterminated quote")
+		}
+		job.Path = rest[1 : end+1]
+		rest = rest[end+2:]
+		for _, opt := range strings.Fields(rest) {
+			if !isPathOption(opt) {
+				return job, fmt.Errorf("unknown option %q", opt)
+			}
+		}
+	} else {
+		// Peel recognised options off the end; whatever is left is the path
+		fields := strings.Fields(rest)
+		keep := len(fields)
+		for keep > 1 && isPathOption(fields[keep-1]) {
+			keep--
+		}
+		end := pathEnd(rest, fields[:keep])
+		job.Path = strings.TrimSpace(rest[:end])
+		rest = rest[end:]
+	}
+	if job.Path == "" {
+		return job, fmt.Errorf("missing path")
+	}
+	job.Path = normalizePath(job.Path)
+
+	for _, opt := range strings.Fields(rest) {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "repo":
+			if err := validRepoName(value); err != nil {
+				return job, err
+			}
+			job.RepoName = value
+		case "private", "public":
+			private := key == "private"
+			job.Private = &private
+		case "org":
+			if value == "" {
+				return job, fmt.Errorf("org= needs a value")
+			}
+			job.Owner = value
+		case "branch":
+			if value == "" || gitCommand("", "check-ref-format", "--branch", value).Run() != nil {
+				return job, fmt.Errorf("invalid branch %q", value)
+			}
+			job.Branch = value
+		}
+	}
+	if job.RepoName == "" {
+		job.RepoName = pathToRepoName(job.Path)
+	}
+	return job, nil
+}
+
+// pathEnd returns the offset in line just past the given leading fields.
+func pathEnd(line string, fields []string) int {
+	end := 0
+	for _, f := range fields {
+		end = strings.Index(line[end:], f) + end + len(f)
+	}
+	return end
+}
+
+func isPathOption(s string) bool {
+	if s == "private" || s == "public" {
+		return true
+	}
+	key, _, ok := strings.Cut(s, "=")
+	return ok && (key == "repo" || key == "org" || key == "branch")
+}
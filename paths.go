@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// normalizePath turns a path as typed into an absolute, cleaned one: ~
+// is the home directory and relative paths are resolved against the
+// working directory now, so state, run records and results name the same
+// directory whatever directory a later run starts in. Symlinks are kept
+// as given.
+func normalizePath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func normalizePaths(paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = normalizePath(p)
+	}
+	return out
+}
+
+// pathFlags are the push flags whose values are paths
+var pathFlags = map[string]bool{
+	"d": true, "f": true, "state": true, "summary": true, "token-file": true, "export-bundles": true,
+}
+
+// absPathArgs normalizes the paths in a push command line that runs later
+// from another directory, as a service does: the values of pathFlags, and
+// other arguments that start with ~ or name an existing file or directory,
+// like the roots after the flags. Other flag values are left alone.
+func absPathArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	for i := 0; i < len(out); i++ {
+		arg := out[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if _, err := os.Stat(arg); err == nil || strings.HasPrefix(arg, "~") {
+				out[i] = normalizePath(arg)
+			}
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch {
+		case !pathFlags[name]:
+		case hasValue && value != "":
+			out[i] = arg[:len(arg)-len(value)] + normalizePath(value)
+		case !hasValue && i+1 < len(out) && out[i+1] != "":
+			i++
+			out[i] = normalizePath(out[i])
+		}
+	}
+	return out
+}
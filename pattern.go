@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob matches a slash-separated path against a glob pattern where
+// "**" matches any number of path segments. Patterns that aren't absolute
+// match at any depth, so "clients/*" matches "/home/me/clients/acme".
+// Backslashes in either argument are treated as separators.
+func matchGlob(pattern, p string) bool {
+	pattern = strings.ReplaceAll(pattern, "\\", "/")
+	p = strings.ReplaceAll(p, "\\", "/")
+	if !isAbsPattern(pattern) && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(splitPath(pattern), splitPath(p))
+}
+
+func isAbsPattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "/") || (len(pattern) >= 2 && pattern[1] == ':')
+}
+
+func splitPath(p string) []string {
+	var segs []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(rest, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			// Drive letters compare case-insensitively
+			if !(strings.HasSuffix(pattern[0], ":") && strings.EqualFold(pattern[0], segs[0])) {
+				return false
+			}
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gitmax plan takes the arguments of a push run and, instead of pushing,
+// writes what the run would do to the -plan file: repos to create, to
+// update, to rename (a directory whose state entry has another name) and
+// to delete (state entries under a root whose directory is gone). The
+// file is indented JSON in a stable order, to review or diff. gitmax apply
+// -plan runs exactly that plan with the same push flags, after checking
+// that the remote still looks as it did when planned.
+//
+//	gitmax plan -plan plan.json -d ~/code
+//	gitmax apply -plan plan.json
+const planVersion = 1
+
+var (
+	planMode string // "plan" or "apply" when run as such
+	planFile string
+)
+
+// Plan is a reviewed set of changes
+type Plan struct {
+	Version int          `json:"version"`
+	Created time.Time    `json:"created"`
+	Host    string       `json:"host"`
+	Args    []string     `json:"args"` // Push flags the plan was made with, applied as given
+	Actions []PlanAction `json:"actions"`
+}
+
+// PlanAction is one change. Creations, updates and renames push the
+// directory afterwards.
+type PlanAction struct {
+	Op    string  `json:"op"` // create, update, rename or delete
+	Owner string  `json:"owner"`
+	Repo  string  `json:"repo"`
+	From  string  `json:"from,omitempty"` // Rename: the repo's current name
+	Path  string  `json:"path"`
+	Job   *DirJob `json:"job,omitempty"` // The push, absent for deletions
+	Note  string  `json:"note,omitempty"`
+}
+
+var planSymbols = map[string]string{"create": "+", "update": "~", "rename": "→", "delete": "-"}
+
+// planOps orders actions in the file and the summary
+var planOps = []string{"delete", "rename", "create", "update"}
+
+// planArgs takes a plan or apply command line apart. For apply, flags given
+// on the command line, like -dry-run, come first and the plan's push flags
+// after them, so the plan's win.
+func planArgs(mode string, args []string) ([]string, error) {
+	planMode = mode
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || name != "plan" {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("-plan needs a file")
+			}
+			i++
+			value = args[i]
+		}
+		planFile = normalizePath(value)
+	}
+	if planFile == "" {
+		return nil, fmt.Errorf("usage: gitmax %s -plan plan.json [flags]", mode)
+	}
+	if mode == "plan" {
+		return rest, nil
+	}
+	plan, err := readPlan(planFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(rest, plan.Args...), nil
+}
+
+func readPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("%s: plan version %d, expected %d", path, plan.Version, planVersion)
+	}
+	return &plan, nil
+}
+
+// writePlan works out the actions for the planned jobs, saves and shows
+// them.
+func writePlan(args []string, jobs []DirJob, roots []string) error {
+	if !hasAPI() {
+		return fmt.Errorf("planning needs a GitHub token to look at the remote")
+	}
+	plan := Plan{Version: planVersion, Created: time.Now().UTC(), Host: hostname, Args: absPathArgs(args)}
+
+	targeted := map[string]bool{}
+	for i := range jobs {
+		job := jobs[i]
+		action, err := planJob(job)
+		if err != nil {
+			return fmt.Errorf("%s: %v", job.Path, err)
+		}
+		targeted[strings.ToLower(job.owner()+"/"+action.From)] = true
+		targeted[strings.ToLower(job.owner()+"/"+job.RepoName)] = true
+		plan.Actions = append(plan.Actions, action)
+	}
+
+	deletions, err := planDeletions(roots, targeted)
+	if err != nil {
+		return err
+	}
+	plan.Actions = append(plan.Actions, deletions...)
+
+	rank := map[string]int{}
+	for i, op := range planOps {
+		rank[op] = i
+	}
+	sort.SliceStable(plan.Actions, func(i, j int) bool {
+		a, b := plan.Actions[i], plan.Actions[j]
+		if a.Op != b.Op {
+			return rank[a.Op] < rank[b.Op]
+		}
+		return a.Path < b.Path
+	})
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(planFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	printPlan(&plan)
+	fmt.Printf("Saved to %s; run it with gitmax apply -plan %s\n", planFile, planFile)
+	return nil
+}
+
+// planJob decides what pushing one directory would do to its repo.
+func planJob(job DirJob) (PlanAction, error) {
+	action := PlanAction{Owner: job.owner(), Repo: job.RepoName, Path: job.Path, Job: &job}
+	exists, err := repoExists(job.owner(), job.RepoName)
+	if err != nil {
+		return action, err
+	}
+
+	if !exists {
+		action.Op = "create"
+		if old := stateNameFor(job); old != "" {
+			oldExists, err := repoExists(job.owner(), old)
+			if err != nil {
+				return action, err
+			}
+			if oldExists {
+				action.Op, action.From = "rename", old
+			}
+		}
+		return action, nil
+	}
+
+	action.Op = "update"
+	managed, err := managedByUs(job.owner(), job.RepoName)
+	if err != nil {
+		return action, err
+	}
+	if !managed {
+		action.Note = "exists but wasn't made by gitmax; existing policy: " + existingPolicyFor(job.Path)
+	}
+	return action, nil
+}
+
+// stateNameFor returns the name the directory was last pushed under, when
+// that differs from the job's.
+func stateNameFor(job DirJob) string {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for _, r := range state.Repos {
+		if r.Path == job.Path && strings.EqualFold(r.Owner, job.owner()) && r.Name != job.RepoName {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// planDeletions lists recorded repos whose directory under one of the
+// roots is gone. A root that is missing itself, like an unmounted drive,
+// deletes nothing.
+func planDeletions(roots []string, targeted map[string]bool) ([]PlanAction, error) {
+	var live []string
+	for _, root := range roots {
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			live = append(live, root)
+		}
+	}
+
+	stateMu.Lock()
+	var candidates []*RepoState
+	for _, r := range state.Repos {
+		if targeted[strings.ToLower(r.Owner+"/"+r.Name)] || !underAny(r.Path, live) {
+			continue
+		}
+		if _, err := os.Stat(r.Path); os.IsNotExist(err) {
+			copied := *r
+			candidates = append(candidates, &copied)
+		}
+	}
+	stateMu.Unlock()
+
+	var actions []PlanAction
+	for _, r := range candidates {
+		exists, err := repoExists(r.Owner, r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %v", r.Owner, r.Name, err)
+		}
+		if exists {
+			actions = append(actions, PlanAction{Op: "delete", Owner: r.Owner, Repo: r.Name, Path: r.Path,
+				Note: "directory is gone"})
+		}
+	}
+	return actions, nil
+}
+
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+func printPlan(plan *Plan) {
+	counts := map[string]int{}
+	for _, a := range plan.Actions {
+		counts[a.Op]++
+		target := a.Owner + "/" + a.Repo
+		if a.Op == "rename" {
+			target = a.Owner + "/" + a.From + " → " + a.Repo
+		}
+		fmt.Printf("  %s %-6s %s  %s\n", planSymbols[a.Op], a.Op, target, a.Path)
+		if a.Note != "" {
+			fmt.Printf("           %s\n", a.Note)
+		}
+	}
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to rename, %d to delete\n",
+		counts["create"], counts["update"], counts["rename"], counts["delete"])
+}
+
+// applyPlan checks the plan against the remote, makes its renames and
+// deletions and returns the jobs left to push.
+func applyPlan() ([]DirJob, error) {
+	plan, err := readPlan(planFile)
+	if err != nil {
+		return nil, err
+	}
+	if !hasAPI() && !dryRun {
+		return nil, fmt.Errorf("applying a plan needs a GitHub token")
+	}
+	printPlan(plan)
+	fmt.Println()
+
+	if hasAPI() {
+		var stale []string
+		for _, a := range plan.Actions {
+			if why, err := planDrift(a); err != nil {
+				return nil, err
+			} else if why != "" {
+				stale = append(stale, fmt.Sprintf("%s/%s: %s", a.Owner, a.Repo, why))
+			}
+		}
+		if len(stale) > 0 {
+			return nil, fmt.Errorf("the remote changed since %s was made; plan again:\n  %s", planFile, strings.Join(stale, "\n  "))
+		}
+	}
+
+	var jobs []DirJob
+	changed := false
+	for _, a := range plan.Actions {
+		switch {
+		case a.Op == "rename" && !dryRun:
+			if err := apiSend("PATCH", fmt.Sprintf("%s/repos/%s/%s", githubAPI, a.Owner, a.From),
+				map[string]string{"name": a.Repo}, nil); err != nil {
+				return nil, fmt.Errorf("renaming %s/%s: %v", a.Owner, a.From, err)
+			}
+			renameStateRepo(a.Owner, a.From, a.Repo)
+			changed = true
+			fmt.Printf("✓ renamed %s/%s to %s\n", a.Owner, a.From, a.Repo)
+		case a.Op == "delete" && !dryRun:
+			if err := deletePlannedRepo(a); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+		if a.Job != nil {
+			jobs = append(jobs, *a.Job)
+		}
+	}
+	if changed {
+		if err := saveState(); err != nil {
+			return nil, fmt.Errorf("saving state: %v", err)
+		}
+	}
+	return jobs, nil
+}
+
+// planDrift reports how the remote no longer matches what an action
+// expects.
+func planDrift(a PlanAction) (string, error) {
+	expect := map[string]bool{a.Repo: a.Op == "update" || a.Op == "delete"}
+	if a.Op == "rename" {
+		expect[a.From] = true
+	}
+	for name, want := range expect {
+		exists, err := repoExists(a.Owner, name)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case exists && !want:
+			return name + " exists now", nil
+		case !exists && want:
+			return name + " no longer exists", nil
+		}
+	}
+	return "", nil
+}
+
+func deletePlannedRepo(a PlanAction) error {
+	if useTrash {
+		name, err := trashRepo(a.Owner, a.Repo)
+		if err != nil {
+			return fmt.Errorf("deleting %s/%s: %v", a.Owner, a.Repo, err)
+		}
+		fmt.Printf("✓ moved %s/%s to trash as %s\n", a.Owner, a.Repo, name)
+	} else {
+		if err := apiSend("DELETE", fmt.Sprintf("%s/repos/%s/%s", githubAPI, a.Owner, a.Repo), nil, nil); err != nil {
+			return fmt.Errorf("deleting %s/%s: %v", a.Owner, a.Repo, err)
+		}
+		fmt.Printf("✓ deleted %s/%s\n", a.Owner, a.Repo)
+	}
+	stateMu.Lock()
+	delete(state.Repos, a.Owner+"/"+a.Repo)
+	stateMu.Unlock()
+	return nil
+}
+
+func renameStateRepo(owner, from, to string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if entry, ok := state.Repos[owner+"/"+from]; ok {
+		delete(state.Repos, owner+"/"+from)
+		entry.Name = to
+		state.Repos[owner+"/"+to] = entry
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Run policies stop a run from burning through thousands of jobs that all
+// fail for the same reason, such as an expired token:
+//
+//   - -abort-after-failures skips every remaining job once that many
+//     have failed.
+//   - The circuit breaker pauses all workers after -breaker consecutive
+//     failures, then lets a single probe job through. A successful probe
+//     resumes the run; a failed one pauses again for twice as long.
+var (
+	abortAfterFailures int64
+	abortedMsg         atomic.Value // string, set once the run aborts
+
+	breaker = &circuitBreaker{threshold: 10, pause: time.Minute}
+)
+
+const breakerMaxPause = 15 * time.Minute
+
+// abortReason returns why remaining jobs are skipped, or "".
+func abortReason() string {
+	if msg, ok := abortedMsg.Load().(string); ok {
+		return msg
+	}
+	if abortAfterFailures <= 0 || atomic.LoadInt64(&stats.Failed) < abortAfterFailures {
+		return ""
+	}
+	msg := fmt.Sprintf("run aborted after %d failures", abortAfterFailures)
+	if abortedMsg.CompareAndSwap(nil, msg) {
+		fmt.Printf("\n✗ %d jobs failed (-abort-after-failures); skipping the rest\n", abortAfterFailures)
+	}
+	return msg
+}
+
+type circuitBreaker struct {
+	threshold int // Consecutive failures that open the breaker; 0 disables it
+	pause     time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	current     time.Duration // Pause in effect; doubles on failed probes
+	openedAt    time.Time
+	probing     bool
+}
+
+func (b *circuitBreaker) open() bool {
+	return b.threshold > 0 && b.consecutive >= b.threshold
+}
+
+// wait blocks while the breaker is open. Once the pause is over, only one
+// caller proceeds as the probe; the others keep waiting for its result.
+func (b *circuitBreaker) wait() {
+	for {
+		b.mu.Lock()
+		switch {
+		case !b.open():
+			b.mu.Unlock()
+			return
+		case time.Now().After(b.openUntil) && !b.probing:
+			b.probing = true
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+		if abortReason() != "" {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// record feeds a finished job into the breaker.
+func (b *circuitBreaker) record(result Result) {
+	if b.threshold <= 0 || result.Skipped {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if result.Success {
+		if b.open() {
+			recordPause(b.openedAt, time.Now(), fmt.Sprintf("circuit breaker after %d consecutive failures", b.threshold))
+			fmt.Printf("\n✓ %s succeeded; resuming the run\n", truncatePath(result.Path, 40))
+		}
+		b.consecutive = 0
+		b.probing = false
+		b.current = 0
+		return
+	}
+
+	b.consecutive++
+	switch {
+	case b.probing:
+		b.probing = false
+		b.current *= 2
+		if b.current > breakerMaxPause {
+			b.current = breakerMaxPause
+		}
+	case b.consecutive == b.threshold:
+		b.current = b.pause
+		b.openedAt = time.Now()
+	default:
+		return
+	}
+	b.openUntil = time.Now().Add(b.current)
+	msg := fmt.Sprintf("%d consecutive failures (latest: %s: %s); pausing for %s",
+		b.consecutive, result.Path, result.Message, b.current)
+	fmt.Printf("\n⚠ %s\n", msg)
+	if webhookURL != "" {
+		sendAlert("gitmax: " + msg)
+	}
+}
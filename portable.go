@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A tree backed up from Linux or macOS can hold names Windows refuses:
+// reserved characters, trailing dots and spaces, device names like CON,
+// names differing only in case, components over 255 characters. A clone
+// onto Windows then fails halfway. scan reports such names, and with
+// -portable-names the commit holds safe names instead, with NamesFile
+// mapping them back; restores elsewhere than Windows put the original
+// names back. The files on disk are never renamed.
+const NamesFile = ".gitmax-names.json"
+
+// maxPortablePath is the repo-relative path length reported as likely to
+// hit Windows' MAX_PATH once a restore folder is in front of it.
+const maxPortablePath = 260
+
+var portableNames bool
+
+// NameIssue is a name that won't restore on every platform
+type NameIssue struct {
+	Path    string `json:"path"` // Slash path relative to the repo
+	Problem string `json:"problem"`
+}
+
+// NamesManifest is the content of NamesFile
+type NamesManifest struct {
+	Names map[string]string `json:"names"` // Committed path → original path
+}
+
+// nameProblem says why Windows rejects a single path component, or "".
+func nameProblem(name string) string {
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) {
+			return fmt.Sprintf("contains %q, which Windows doesn't allow", r)
+		}
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "ends with a dot or space, which Windows drops"
+	}
+	if reservedDeviceName(name) {
+		return "is a reserved device name on Windows"
+	}
+	if n := len(utf16.Encode([]rune(name))); n > 255 {
+		return fmt.Sprintf("is %d characters long; most filesystems allow 255", n)
+	}
+	return ""
+}
+
+func reservedDeviceName(name string) bool {
+	base := strings.ToUpper(strings.TrimRight(strings.SplitN(name, ".", 2)[0], " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '0' && base[3] <= '9'
+}
+
+// nameChecker finds the unportable names among the paths of one repo,
+// given in walk order.
+type nameChecker struct {
+	folded map[string]string // Lower-cased path → first path seen
+	issues []NameIssue
+}
+
+func newNameChecker() *nameChecker {
+	return &nameChecker{folded: map[string]string{}}
+}
+
+// check looks at the last component of rel, a slash path.
+func (c *nameChecker) check(rel string) {
+	if problem := nameProblem(path.Base(rel)); problem != "" {
+		c.issues = append(c.issues, NameIssue{Path: rel, Problem: problem})
+	} else if first, ok := c.folded[strings.ToLower(rel)]; ok && first != rel {
+		c.issues = append(c.issues, NameIssue{Path: rel, Problem: fmt.Sprintf("differs from %s only in case", first)})
+	} else if len(rel) > maxPortablePath {
+		c.issues = append(c.issues, NameIssue{Path: rel, Problem: fmt.Sprintf("path is %d characters long", len(rel))})
+	}
+	if _, ok := c.folded[strings.ToLower(rel)]; !ok {
+		c.folded[strings.ToLower(rel)] = rel
+	}
+}
+
+// portableRenames maps each path with an unportable component to one
+// every platform accepts. Names that are fine keep priority over renamed
+// ones, and renames get a ~N suffix until no name in the directory
+// matches them in any case.
+func portableRenames(paths []string) map[string]string {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	kept := map[string]bool{}      // Original prefix whose name stays
+	taken := map[string]string{}   // New parent + "/" + lower-cased name → original prefix
+	renamed := map[string]string{} // Original prefix → new prefix
+	for _, p := range sorted {
+		parts := strings.Split(p, "/")
+		for i := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			if nameProblem(parts[i]) != "" {
+				break
+			}
+			key := strings.ToLower(prefix)
+			if first, ok := taken[key]; ok && first != prefix {
+				break
+			}
+			taken[key], kept[prefix] = prefix, true
+		}
+	}
+
+	renames := map[string]string{}
+	for _, p := range sorted {
+		parts := strings.Split(p, "/")
+		parent := ""
+		for i, part := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			next, ok := renamed[prefix]
+			if !ok {
+				name := part
+				if !kept[prefix] {
+					name = uniqueName(parent, safeName(part), prefix, taken)
+				}
+				next = path.Join(parent, name)
+				renamed[prefix] = next
+			}
+			parent = next
+		}
+		if parent != p {
+			renames[p] = parent
+		}
+	}
+	return renames
+}
+
+// safeName replaces what Windows rejects in one component.
+func safeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`<>:"|?*\`, r) {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	name = b.String()
+	if trimmed := strings.TrimRight(name, ". "); trimmed != name {
+		name = trimmed + strings.Repeat("_", len(name)-len(trimmed))
+	}
+	if reservedDeviceName(name) {
+		base, ext, _ := strings.Cut(name, ".")
+		name = base + "_"
+		if ext != "" {
+			name += "." + ext
+		}
+	}
+	for len(utf16.Encode([]rune(name))) > 240 {
+		runes := []rune(name)
+		name = string(runes[:len(runes)-1])
+	}
+	return name
+}
+
+// uniqueName adds ~2, ~3… before the extension until name is free in
+// parent, and claims it for prefix.
+func uniqueName(parent, name, prefix string, taken map[string]string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; ; n++ {
+		key := strings.ToLower(path.Join(parent, candidate))
+		if first, ok := taken[key]; !ok || first == prefix {
+			taken[key] = prefix
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s~%d%s", base, n, ext)
+	}
+}
+
+// namesBlob is NamesFile's content for renames, or nil when there are none.
+func namesBlob(renames map[string]string) ([]byte, error) {
+	if len(renames) == 0 {
+		return nil, nil
+	}
+	manifest := NamesManifest{Names: map[string]string{}}
+	for from, to := range renames {
+		manifest.Names[to] = from
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// renameStagedUnportable gives the staged files with unportable names
+// safe ones in the index and stages NamesFile, leaving the files on disk
+// as they are.
+func renameStagedUnportable(dir string) error {
+	if !portableNames {
+		return nil
+	}
+	staged, err := lsFilesZ(dir, "-s")
+	if err != nil {
+		return err
+	}
+	entries := map[string]string{} // Path → "mode sha"
+	var paths []string
+	for _, line := range staged {
+		info, p, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		entries[p] = fields[0] + " " + fields[1]
+		paths = append(paths, p)
+	}
+	renames := portableRenames(paths)
+	blob, err := namesBlob(renames)
+	if err != nil || blob == nil {
+		return err
+	}
+	sha, err := gitStdin(dir, blob, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+
+	var info bytes.Buffer
+	for from, to := range renames {
+		fmt.Fprintf(&info, "0 %s\t%s\x00", strings.Repeat("0", len(sha)), from)
+		fmt.Fprintf(&info, "%s\t%s\x00", entries[from], to)
+	}
+	fmt.Fprintf(&info, "100644 %s\t%s\x00", sha, NamesFile)
+	_, err = gitStdin(dir, info.Bytes(), "update-index", "-z", "--index-info")
+	return err
+}
+
+// gitStdin runs git with input on stdin and returns its trimmed output.
+func gitStdin(dir string, input []byte, args ...string) (string, error) {
+	cmd := gitCommand(dir, args...)
+	var output, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &stderr
+	err := runTracked(cmd)
+	logGit(dir, args, stderr.String(), err)
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(output.String()), nil
+}
+
+// restoreNames moves a restored checkout's files back to the names in
+// NamesFile, deepest first. On Windows the safe names stay.
+func restoreNames(dir string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, NamesFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var manifest NamesManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("reading %s: %v", NamesFile, err)
+	}
+	if runtime.GOOS == "windows" {
+		fmt.Printf("ℹ %s: %d files keep the Windows-safe names listed in %s\n", dir, len(manifest.Names), NamesFile)
+		return nil
+	}
+
+	committed := make([]string, 0, len(manifest.Names))
+	for p := range manifest.Names {
+		committed = append(committed, p)
+	}
+	sort.Slice(committed, func(i, j int) bool { return committed[i] > committed[j] })
+	for _, p := range committed {
+		from := filepath.Join(dir, filepath.FromSlash(p))
+		to := filepath.Join(dir, filepath.FromSlash(manifest.Names[p]))
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		removeEmptyParents(dir, filepath.Dir(from))
+	}
+	return nil
+}
+
+// removeEmptyParents deletes dir and its parents below root while empty.
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
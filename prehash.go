@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// git add hashes and compresses every file on one thread, which dominates
+// the time spent on huge directories. For those, the objects are written
+// beforehand by several git hash-object processes; git add then finds each
+// object already in the store and skips compressing it, while staying the
+// only writer of the index.
+var hashWorkers = runtime.NumCPU()
+
+// prehashMinBytes is the directory size below which the extra processes
+// cost more than they save.
+const prehashMinBytes = 64 << 20
+
+// prehashObjects writes the objects of the files stageFiles will add. It
+// is an optimisation only; git add writes anything it missed.
+func prehashObjects(dir string) error {
+	if hashWorkers < 2 {
+		return nil
+	}
+
+	filter := newStageFilter(dir)
+	sizes := newSizeCounter()
+	var paths []string
+	var total int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			if info.Name() == ".git" || rel != "." && !filter.includesDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Symlinks are stored as their target, which hash-object would
+		// read through; oversized files are excluded by .gitignore
+		if !info.Mode().IsRegular() || info.Size() > maxFileSize || !filter.includes(rel) || strings.ContainsAny(rel, "\n\r") {
+			return nil
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		total += sizes.add(path, info)
+		return nil
+	})
+	if total < prehashMinBytes {
+		return nil
+	}
+
+	// Deal files round-robin so every worker gets a similar mix of sizes
+	workers := hashWorkers
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	chunks := make([][]string, workers)
+	for i, p := range paths {
+		chunks[i%workers] = append(chunks[i%workers], p)
+	}
+
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			cmd := gitCommand(dir, "hash-object", "-w", "--stdin-paths")
+			cmd.Stdin = strings.NewReader(strings.Join(chunk, "\n") + "\n")
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				errs[i] = fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// provider is a forge or host besides GitHub that gitmax can create repos
+// on and push snapshots to. Providers are defined under providers: in the
+// config file and picked per run with -provider.
+type provider interface {
+	// ensureRepo creates the repo unless it exists and reports whether it
+	// did.
+	ensureRepo(repoName string) (bool, error)
+	remoteURL(repoName string) string
+	// gitEnv is extra environment for git talking to the remote, such as
+	// credentials, so secrets never land in .git/config or argv.
+	gitEnv() []string
+}
+
+// ProviderConfig is one entry of providers: in the config file. Which
+// fields apply depends on Type.
+type ProviderConfig struct {
+	Type    string `yaml:"type"`    // bitbucket, azure, codecommit, ssh, plugin or a plugin name
+	Private *bool  `yaml:"private"` // Defaults to true
+	SSH     bool   `yaml:"ssh"`     // Push over SSH instead of HTTPS
+
+	// Bitbucket Cloud
+	Workspace   string `yaml:"workspace"`
+	Project     string `yaml:"project"` // Bitbucket project key or Azure DevOps project name
+	Username    string `yaml:"username"`
+	AppPassword string `yaml:"app_password"`
+
+	// Azure DevOps
+	Organization string `yaml:"organization"`
+	Token        string `yaml:"token"`
+
+	// AWS CodeCommit; credentials come from the AWS_* variables or the
+	// named profile in ~/.aws/credentials
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+
+	// Bare repos over SSH
+	Host string `yaml:"host"` // user@host
+	Port int    `yaml:"port"`
+	Path string `yaml:"path"` // Directory holding <name>.git on the host
+
+	// Plugins: the binary defaults to gitmax-provider-<type> on PATH
+	Command  string            `yaml:"command"`
+	Settings map[string]string `yaml:"settings"` // Passed to the plugin as is
+}
+
+func (c ProviderConfig) private() bool {
+	return c.Private == nil || *c.Private
+}
+
+// namedProvider is a provider selected for this run
+type namedProvider struct {
+	name string
+	provider
+}
+
+var (
+	providerFlags stringList
+	providers     []namedProvider
+)
+
+func newProvider(cfg ProviderConfig) (provider, error) {
+	switch cfg.Type {
+	case "bitbucket":
+		return newBitbucketProvider(cfg)
+	case "azure":
+		return newAzureProvider(cfg)
+	case "codecommit":
+		return newCodecommitProvider(cfg)
+	case "ssh":
+		return newSSHProvider(cfg)
+	}
+	return newPluginProvider(cfg)
+}
+
+// providerProblems lists configuration mistakes for config validation. It
+// looks at the settings only: finding and configuring a plugin is left to
+// setupProviders, for the providers a run selects.
+func providerProblems(cfg ProviderConfig) []string {
+	switch cfg.Type {
+	case "bitbucket", "azure", "codecommit", "ssh":
+		if _, err := newProvider(cfg); err != nil {
+			return []string{err.Error()}
+		}
+	case "", "plugin":
+		if cfg.Command == "" {
+			return []string{"plugin: command is required"}
+		}
+	}
+	return nil
+}
+
+// setupProviders resolves the -provider names against the config.
+func setupProviders(names []string) error {
+	for _, name := range names {
+		cfg, ok := config.Providers[name]
+		if !ok {
+			var known []string
+			for n := range config.Providers {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("no provider %q in the config (have: %s)", name, strings.Join(known, ", "))
+		}
+		p, err := newProvider(cfg)
+		if err != nil {
+			return fmt.Errorf("provider %s: %v", name, err)
+		}
+		providers = append(providers, namedProvider{name: name, provider: p})
+	}
+	return nil
+}
+
+// pushProviders creates the repo on every selected provider and mirrors
+// main there, replacing what's there as on origin (-incremental only adds
+// to it).
+func pushProviders(job DirJob) error {
+	var failed []string
+	for _, p := range providers {
+		if err := pushProvider(job, p); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", p.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("push to providers failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func pushProvider(job DirJob, p namedProvider) error {
+	if _, err := p.ensureRepo(job.RepoName); err != nil {
+		return err
+	}
+	url := p.remoteURL(job.RepoName)
+	if err := configureRemote(job.Path, p.name, url); err != nil {
+		return err
+	}
+	release := acquirePush(job.Path, remoteHost(url))
+	defer release()
+	cmd := gitCommand(job.Path, mirrorPushArgs(p.name, job.branch())...)
+	cmd.Env = append(cmd.Env, p.gitEnv()...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := runTracked(cmd); err != nil {
+		return fmt.Errorf("git push: %v: %s", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// gitConfigEnv passes config to git through the environment (git 2.31+).
+func gitConfigEnv(pairs ...string) []string {
+	env := []string{fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(pairs)/2)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i/2, pairs[i]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i/2, pairs[i+1]))
+	}
+	return env
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// providerRequest sends a JSON request to a provider API and decodes a
+// 2xx JSON response into out. Failures are *APIError like GitHub's.
+func providerRequest(method, url, auth string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	op := method + " " + url
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return transportError(op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &APIError{Op: op, StatusCode: resp.StatusCode, Message: apiMessage(resp)}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == 404
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+const azureHost = "https://dev.azure.com"
+
+// azureProvider creates repos in an Azure DevOps project, authenticating
+// with a personal access token. Visibility follows the project's.
+type azureProvider struct {
+	cfg  ProviderConfig
+	auth string
+}
+
+func newAzureProvider(cfg ProviderConfig) (provider, error) {
+	switch {
+	case cfg.Organization == "" || cfg.Project == "":
+		return nil, errors.New("azure: organization and project are required")
+	case cfg.Token == "":
+		return nil, errors.New("azure: token (a PAT with Code read & write) is required")
+	}
+	// PATs go in basic auth with an empty user name
+	return &azureProvider{cfg: cfg, auth: basicAuth("", cfg.Token)}, nil
+}
+
+func (a *azureProvider) projectURL() string {
+	return fmt.Sprintf("%s/%s/%s", azureHost, url.PathEscape(a.cfg.Organization), url.PathEscape(a.cfg.Project))
+}
+
+func (a *azureProvider) ensureRepo(repoName string) (bool, error) {
+	repos := a.projectURL() + "/_apis/git/repositories"
+	err := providerRequest("GET", repos+"/"+url.PathEscape(repoName)+"?api-version=7.1", a.auth, nil, nil)
+	if err == nil {
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+	if err := providerRequest("POST", repos+"?api-version=7.1", a.auth, map[string]string{"name": repoName}, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *azureProvider) remoteURL(repoName string) string {
+	if a.cfg.SSH {
+		return fmt.Sprintf("git@ssh.dev.azure.com:v3/%s/%s/%s", a.cfg.Organization, a.cfg.Project, repoName)
+	}
+	return a.projectURL() + "/_git/" + url.PathEscape(repoName)
+}
+
+func (a *azureProvider) gitEnv() []string {
+	if a.cfg.SSH {
+		return nil
+	}
+	return gitConfigEnv("http."+azureHost+"/.extraHeader", "Authorization: "+a.auth)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const bitbucketAPI = "https://api.bitbucket.org/2.0"
+
+// bitbucketProvider creates repos in a Bitbucket Cloud workspace,
+// authenticating with a username and app password.
+type bitbucketProvider struct {
+	cfg  ProviderConfig
+	auth string
+}
+
+func newBitbucketProvider(cfg ProviderConfig) (provider, error) {
+	switch {
+	case cfg.Workspace == "":
+		return nil, errors.New("bitbucket: workspace is required")
+	case cfg.Username == "" || cfg.AppPassword == "":
+		return nil, errors.New("bitbucket: username and app_password are required")
+	}
+	return &bitbucketProvider{cfg: cfg, auth: basicAuth(cfg.Username, cfg.AppPassword)}, nil
+}
+
+func (b *bitbucketProvider) ensureRepo(repoName string) (bool, error) {
+	url := fmt.Sprintf("%s/repositories/%s/%s", bitbucketAPI, b.cfg.Workspace, repoName)
+	err := providerRequest("GET", url, b.auth, nil, nil)
+	if err == nil {
+		return false, nil
+	}
+	if !isNotFound(err) {
+		return false, err
+	}
+
+	body := map[string]interface{}{"scm": "git", "is_private": b.cfg.private()}
+	if b.cfg.Project != "" {
+		// Without a project Bitbucket uses the workspace's default one
+		body["project"] = map[string]string{"key": b.cfg.Project}
+	}
+	if err := providerRequest("POST", url, b.auth, body, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *bitbucketProvider) remoteURL(repoName string) string {
+	if b.cfg.SSH {
+		return fmt.Sprintf("git@bitbucket.org:%s/%s.git", b.cfg.Workspace, repoName)
+	}
+	return fmt.Sprintf("https://bitbucket.org/%s/%s.git", b.cfg.Workspace, repoName)
+}
+
+func (b *bitbucketProvider) gitEnv() []string {
+	if b.cfg.SSH {
+		return nil
+	}
+	return gitConfigEnv("http.https://bitbucket.org/.extraHeader", "Authorization: "+b.auth)
+}
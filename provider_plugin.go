@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Forges gitmax doesn't know natively are served by plugin binaries. A
+// provider of type foo runs gitmax-provider-foo from PATH, or the binary
+// named by command:. Each call starts the binary once, writes one JSON
+// request to its stdin and reads one JSON response from its stdout;
+// stderr is passed through to the error on failure.
+//
+//	{"protocol": 1, "method": "configure", "settings": {...}}
+//	→ {"git_env": ["GIT_CONFIG_COUNT=1", ...]}
+//
+//	{"protocol": 1, "method": "ensure_repo", "settings": {...}, "repo": "name", "private": true}
+//	→ {"created": true, "remote_url": "https://git.example.org/me/name"}
+//
+//	{"protocol": 1, "method": "remote_url", "settings": {...}, "repo": "name"}
+//	→ {"remote_url": "https://git.example.org/me/name"}
+//
+// Any response may carry "error" instead. settings: is passed through
+// verbatim, so plugins define their own configuration.
+const pluginProtocol = 1
+
+const pluginPrefix = "gitmax-provider-"
+
+type pluginProvider struct {
+	cfg     ProviderConfig
+	command string
+	env     []string
+
+	mu   sync.Mutex
+	urls map[string]string // Repo name → remote URL from ensure_repo
+}
+
+type pluginRequest struct {
+	Protocol int               `json:"protocol"`
+	Method   string            `json:"method"`
+	Settings map[string]string `json:"settings"`
+	Repo     string            `json:"repo,omitempty"`
+	Private  bool              `json:"private,omitempty"`
+}
+
+type pluginResponse struct {
+	Error     string   `json:"error"`
+	Created   bool     `json:"created"`
+	RemoteURL string   `json:"remote_url"`
+	GitEnv    []string `json:"git_env"`
+}
+
+func newPluginProvider(cfg ProviderConfig) (provider, error) {
+	command := cfg.Command
+	switch {
+	case command != "":
+	case cfg.Type == "", cfg.Type == "plugin":
+		return nil, errors.New("plugin: command is required")
+	default:
+		command = pluginPrefix + cfg.Type
+	}
+	path, err := exec.LookPath(command)
+	if err != nil && cfg.Command == "" {
+		return nil, fmt.Errorf("unknown provider type %q and no %s plugin on PATH", cfg.Type, command)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %v", command, err)
+	}
+
+	p := &pluginProvider{cfg: cfg, command: path, urls: map[string]string{}}
+	resp, err := p.call(pluginRequest{Method: "configure"})
+	if err != nil {
+		return nil, err
+	}
+	p.env = resp.GitEnv
+	return p, nil
+}
+
+func (p *pluginProvider) call(req pluginRequest) (pluginResponse, error) {
+	var resp pluginResponse
+	req.Protocol = pluginProtocol
+	req.Settings = p.cfg.Settings
+	body, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	cmd := exec.Command(p.command)
+	cmd.Stdin = bytes.NewReader(body)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return resp, fmt.Errorf("%s %s: %v: %s", p.command, req.Method, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return resp, fmt.Errorf("%s %s: bad response: %v", p.command, req.Method, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s %s: %s", p.command, req.Method, resp.Error)
+	}
+	return resp, nil
+}
+
+func (p *pluginProvider) ensureRepo(repoName string) (bool, error) {
+	resp, err := p.call(pluginRequest{Method: "ensure_repo", Repo: repoName, Private: p.cfg.private()})
+	if err != nil {
+		return false, err
+	}
+	if resp.RemoteURL == "" {
+		return false, fmt.Errorf("%s ensure_repo: no remote_url in response", p.command)
+	}
+	p.mu.Lock()
+	p.urls[repoName] = resp.RemoteURL
+	p.mu.Unlock()
+	return resp.Created, nil
+}
+
+func (p *pluginProvider) remoteURL(repoName string) string {
+	p.mu.Lock()
+	url, ok := p.urls[repoName]
+	p.mu.Unlock()
+	if ok {
+		return url
+	}
+	resp, err := p.call(pluginRequest{Method: "remote_url", Repo: repoName})
+	if err != nil {
+		return ""
+	}
+	return resp.RemoteURL
+}
+
+func (p *pluginProvider) gitEnv() []string {
+	return p.env
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sshProvider keeps bare repos on any host reachable over SSH, with no
+// forge API involved: repos are created by running git init there.
+type sshProvider struct {
+	cfg ProviderConfig
+}
+
+func newSSHProvider(cfg ProviderConfig) (provider, error) {
+	switch {
+	case cfg.Host == "":
+		return nil, errors.New("ssh: host is required, e.g. git@nas.local")
+	case !strings.HasPrefix(cfg.Path, "/"):
+		return nil, errors.New("ssh: path must be an absolute directory on the host, e.g. /srv/git")
+	}
+	return &sshProvider{cfg: cfg}, nil
+}
+
+func (s *sshProvider) repoPath(repoName string) string {
+	return path.Join(s.cfg.Path, repoName+".git")
+}
+
+func (s *sshProvider) ensureRepo(repoName string) (bool, error) {
+	dir := shellQuote(s.repoPath(repoName))
+	script := fmt.Sprintf("test -d %s && exit 0; git init -q --bare %s && git --git-dir=%s symbolic-ref HEAD refs/heads/main && echo created",
+		dir, dir, dir)
+
+	args := []string{"-o", "BatchMode=yes"}
+	if s.cfg.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(s.cfg.Port))
+	}
+	args = append(args, s.cfg.Host, script)
+	out, err := exec.Command("ssh", args...).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("ssh %s: %v: %s", s.cfg.Host, err, strings.TrimSpace(string(out)))
+	}
+	return strings.Contains(string(out), "created"), nil
+}
+
+func (s *sshProvider) remoteURL(repoName string) string {
+	host := s.cfg.Host
+	if s.cfg.Port != 0 {
+		host += ":" + strconv.Itoa(s.cfg.Port)
+	}
+	return "ssh://" + host + s.repoPath(repoName)
+}
+
+func (s *sshProvider) gitEnv() []string {
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Push concurrency is capped overall and per destination host, so a slow
+// mirror holds its own slots and not everybody else's. Zero means no limit
+// beyond the worker count.
+var (
+	maxPushes        int
+	maxPushesPerHost int
+
+	pushSem   chan struct{} // nil without -max-pushes
+	hostSemMu sync.Mutex
+	hostSems  = map[string]chan struct{}{}
+)
+
+// acquirePush blocks until a push to host may start and returns the
+// function that releases the slot. The wait is charged to the job in dir.
+func acquirePush(dir, host string) func() {
+	defer func(start time.Time) { recordStage(dir, stageWait, time.Since(start)) }(time.Now())
+	var hostSem chan struct{}
+	if maxPushesPerHost > 0 {
+		hostSemMu.Lock()
+		hostSem = hostSems[host]
+		if hostSem == nil {
+			hostSem = make(chan struct{}, maxPushesPerHost)
+			hostSems[host] = hostSem
+		}
+		hostSemMu.Unlock()
+		// Wait for the host first so a push queued behind a slow host
+		// doesn't sit on a global slot meanwhile
+		hostSem <- struct{}{}
+	}
+	if pushSem != nil {
+		pushSem <- struct{}{}
+	}
+	return func() {
+		if pushSem != nil {
+			<-pushSem
+		}
+		if hostSem != nil {
+			<-hostSem
+		}
+	}
+}
+
+// remoteHost returns the host a git remote URL connects to: URLs with a
+// scheme, scp-like user@host:path, and git-remote-codecommit's
+// codecommit::region://repo.
+func remoteHost(remote string) string {
+	if rest, ok := strings.CutPrefix(remote, "codecommit::"); ok {
+		region, _, _ := strings.Cut(rest, "://")
+		return "git-codecommit." + region + ".amazonaws.com"
+	}
+	if strings.Contains(remote, "://") {
+		if u, err := url.Parse(remote); err == nil {
+			return u.Hostname()
+		}
+		return remote
+	}
+	host, _, _ := strings.Cut(remote, ":")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return host
+}
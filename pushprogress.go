@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// writingRe matches git's push progress, e.g.
+// "Writing objects:  45% (9/20), 1.20 MiB | 2.00 MiB/s"
+var writingRe = regexp.MustCompile(`Writing objects:\s+\d+% \((\d+)/\d+\)(?:, ([\d.]+) (bytes|KiB|MiB|GiB))?`)
+
+var sizeUnits = map[string]float64{
+	"bytes": 1,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+}
+
+// pushTransfer is what one push sent
+type pushTransfer struct {
+	Bytes   int64
+	Objects int64
+}
+
+// pushError is a failed push with what git printed
+type pushError struct {
+	err    error
+	output string
+}
+
+func (e *pushError) Error() string { return e.err.Error() }
+
+// pushRejected reports whether the remote refused a push that got through:
+// a non-fast-forward, a hook or branch protection, or an account without
+// write access. Another transport gets the same answer.
+func pushRejected(err error) bool {
+	var pe *pushError
+	if !errors.As(err, &pe) {
+		return false
+	}
+	out := pe.output
+	return strings.Contains(out, "[rejected]") || strings.Contains(out, "[remote rejected]") ||
+		strings.Contains(out, "Permission to ") && strings.Contains(out, " denied to ")
+}
+
+// runGitPush runs git push with --progress, feeding transferred bytes into
+// the global counter as they are reported.
+func runGitPush(dir string, args ...string) (pushTransfer, error) {
+	var transfer pushTransfer
+
+	args = append([]string{args[0], "--progress"}, args[1:]...)
+	cmd := gitCommand(dir, args...)
+	start := time.Now()
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return transfer, err
+	}
+	if err := cmd.Start(); err != nil {
+		return transfer, err
+	}
+	trackCmd(dir, cmd)
+	defer untrackCmd(dir, cmd)
+
+	// Progress updates are separated by carriage returns
+	var output bytes.Buffer
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanProgressLines)
+	for scanner.Scan() {
+		line := scanner.Text()
+		output.WriteString(line + "\n")
+
+		m := writingRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		transfer.Objects, _ = strconv.ParseInt(m[1], 10, 64)
+		if m[2] != "" {
+			size, _ := strconv.ParseFloat(m[2], 64)
+			sent := int64(size * sizeUnits[m[3]])
+			if sent > transfer.Bytes {
+				atomic.AddInt64(&stats.BytesPushed, sent-transfer.Bytes)
+				transfer.Bytes = sent
+			}
+		}
+	}
+
+	err = cmd.Wait()
+	recordStage(dir, stagePush, time.Since(start))
+	logGit(dir, args, output.String(), err)
+	if err != nil && verbose {
+		fmt.Print(redact(fmt.Sprintf("git %s in %s: %s\n", strings.Join(args, " "), dir, output.String())))
+	}
+	if err != nil {
+		return transfer, &pushError{err: err, output: output.String()}
+	}
+	return transfer, nil
+}
+
+// scanProgressLines splits on \n or \r.
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// formatBytes renders a byte count with a binary unit.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return formatFloat(float64(n)/(1<<30), 2) + " GiB"
+	case n >= 1<<20:
+		return formatFloat(float64(n)/(1<<20), 1) + " MiB"
+	case n >= 1<<10:
+		return formatFloat(float64(n)/(1<<10), 1) + " KiB"
+	default:
+		return formatInt(n) + " B"
+	}
+}
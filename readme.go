@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// With -readme-footer, or readme_footer in a directories entry, the
+// directory's Markdown README ends with a provenance footer saying where
+// the repo is backed up from, rewritten on every push, so anyone browsing
+// it knows it's an automated mirror. Directories without a README get a
+// README.md holding only the footer; ones with a plain-text README are
+// left alone rather than shadowed. gitmax clean takes the footer out.
+const (
+	readmeBegin = "<!-- BEGIN gitmax provenance -->"
+	readmeEnd   = "<!-- END gitmax provenance -->"
+)
+
+var readmeFooter bool
+
+// readmeFooterFor reports whether the directory's README gets a footer.
+func readmeFooterFor(path string) bool {
+	if d, ok := dirConfig(path); ok && d.ReadmeFooter != nil {
+		return *d.ReadmeFooter
+	}
+	return readmeFooter
+}
+
+// findReadme returns the directory's README and whether it's Markdown.
+func findReadme(dir string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	other := ""
+	for _, e := range entries {
+		name := strings.ToLower(e.Name())
+		if e.IsDir() || !strings.HasPrefix(name, "readme") {
+			continue
+		}
+		if name == "readme.md" || name == "readme.markdown" {
+			return filepath.Join(dir, e.Name()), true
+		}
+		other = filepath.Join(dir, e.Name())
+	}
+	return other, false
+}
+
+// writeReadmeFooter puts the current footer into the directory's README.
+func writeReadmeFooter(job DirJob) error {
+	if !readmeFooterFor(job.Path) {
+		return nil
+	}
+	readme, markdown := findReadme(job.Path)
+	if readme != "" && !markdown {
+		return nil
+	}
+
+	content := ""
+	if readme == "" {
+		readme = filepath.Join(job.Path, "README.md")
+	} else {
+		data, err := ioutil.ReadFile(readme)
+		if err != nil {
+			return err
+		}
+		content, _ = stripReadmeFooter(string(data))
+		if strings.TrimSpace(content) == "" {
+			content = ""
+		}
+	}
+
+	section := readmeSection(job, content == "")
+	if content != "" {
+		content = strings.TrimRight(content, "\n") + "\n\n"
+	}
+	return ioutil.WriteFile(readme, []byte(content+section), 0644)
+}
+
+// readmeSection renders the footer; a README made for it gets a title.
+func readmeSection(job DirJob, titled bool) string {
+	var b strings.Builder
+	b.WriteString(readmeBegin + "\n")
+	if titled {
+		fmt.Fprintf(&b, "# %s\n\n", job.RepoName)
+	}
+	b.WriteString("---\n\n")
+	b.WriteString("![backup: gitmax](https://img.shields.io/badge/backup-gitmax-blue)\n\n")
+	fmt.Fprintf(&b, "Automated backup of `%s` on %s, last pushed %s by gitmax %s (run `%s`). ",
+		job.Path, hostname, time.Now().UTC().Format("2006-01-02 15:04 UTC"), version, runID)
+	b.WriteString("Changes made here are overwritten by the next backup.\n")
+	b.WriteString(readmeEnd + "\n")
+	return b.String()
+}
+
+// stripReadmeFooter removes the footer section.
+func stripReadmeFooter(content string) (string, bool) {
+	start := strings.Index(content, readmeBegin)
+	if start < 0 {
+		return content, false
+	}
+	end := strings.Index(content[start:], readmeEnd)
+	if end < 0 {
+		return content[:start], true
+	}
+	rest := strings.TrimPrefix(content[start+end+len(readmeEnd):], "\n")
+	return strings.TrimRight(content[:start], "\n") + "\n" + rest, true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteEntry is one repo of the account, the remote-side counterpart of a
+// ScanEntry
+type RemoteEntry struct {
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Visibility string     `json:"visibility"`
+	Size       int64      `json:"size"` // Bytes, as GitHub reports it (KB precision)
+	Topics     []string   `json:"topics"`
+	PushedAt   *time.Time `json:"pushed_at"` // Nil for a repo never pushed to
+	Fork       bool       `json:"fork"`
+	Archived   bool       `json:"archived"`
+	Managed    bool       `json:"managed"`        // Marked with managedTopic
+	Path       string     `json:"path,omitempty"` // Source directory in the state file
+	LastRun    string     `json:"last_run,omitempty"`
+	URL        string     `json:"url"`
+}
+
+var remoteCSVHeader = []string{"name", "owner", "visibility", "size", "topics", "pushed_at", "fork", "archived", "managed", "path", "last_run", "url"}
+
+// runRemoteList inventories the repos on the account or an organization.
+// It only reads; its output feeds decisions on what to prune or audit.
+func runRemoteList(args []string) int {
+	fs := flag.NewFlagSet("remote-list", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	org := fs.String("org", "", "List this organization's repos instead of your own")
+	output := fs.String("o", "text", "Output format: text, json or csv")
+	outFile := fs.String("out", "", "Write to this file instead of stdout")
+	managedOnly := fs.Bool("managed", false, "Only repos marked as gitmax-managed")
+	fs.Parse(args)
+
+	if *output != "text" && *output != "json" && *output != "csv" {
+		fmt.Printf("Unknown output format %q\n", *output)
+		return 1
+	}
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		return 1
+	}
+
+	var repos []remoteRepo
+	var err error
+	if *org != "" {
+		repos, err = listOrgRepos(*org)
+	} else {
+		repos, err = listUserRepos()
+	}
+	if err != nil {
+		fmt.Printf("✗ %v\n", redact(err.Error()))
+		return 1
+	}
+
+	var entries []RemoteEntry
+	for _, r := range repos {
+		e := remoteEntry(r)
+		if *managedOnly && !e.Managed {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Owner+"/"+entries[i].Name < entries[j].Owner+"/"+entries[j].Name
+	})
+
+	out := io.Writer(os.Stdout)
+	if *outFile != "" {
+		f, err := os.Create(normalizePath(*outFile))
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeRemoteList(out, *output, entries); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if *outFile != "" {
+		fmt.Printf("✓ %d repos written to %s\n", len(entries), *outFile)
+	}
+	return 0
+}
+
+func remoteEntry(r remoteRepo) RemoteEntry {
+	owner, _, _ := strings.Cut(r.FullName, "/")
+	e := RemoteEntry{
+		Name:       r.Name,
+		Owner:      owner,
+		Visibility: "public",
+		Size:       r.Size * 1024,
+		Topics:     r.Topics,
+		Fork:       r.Fork,
+		Archived:   r.Archived,
+		Managed:    managedTopic != "" && containsString(r.Topics, managedTopic),
+		URL:        r.HTMLURL,
+	}
+	if r.Private {
+		e.Visibility = "private"
+	}
+	if !r.PushedAt.IsZero() {
+		pushed := r.PushedAt
+		e.PushedAt = &pushed
+	}
+	if e.Topics == nil {
+		e.Topics = []string{}
+	}
+	stateMu.Lock()
+	for key, s := range state.Repos {
+		if strings.EqualFold(key, r.FullName) {
+			e.Path, e.LastRun = s.Path, s.LastRun
+			break
+		}
+	}
+	stateMu.Unlock()
+	return e
+}
+
+func writeRemoteList(out io.Writer, format string, entries []RemoteEntry) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write(remoteCSVHeader)
+		for _, e := range entries {
+			pushed := ""
+			if e.PushedAt != nil {
+				pushed = e.PushedAt.UTC().Format(time.RFC3339)
+			}
+			w.Write([]string{e.Name, e.Owner, e.Visibility, strconv.FormatInt(e.Size, 10), strings.Join(e.Topics, " "),
+				pushed, strconv.FormatBool(e.Fork), strconv.FormatBool(e.Archived), strconv.FormatBool(e.Managed),
+				e.Path, e.LastRun, e.URL})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	var total int64
+	for _, e := range entries {
+		pushed := "never"
+		if e.PushedAt != nil {
+			pushed = e.PushedAt.Local().Format("2006-01-02")
+		}
+		flags := e.Visibility
+		if e.Managed {
+			flags += ",gitmax"
+		}
+		if e.Archived {
+			flags += ",archived"
+		}
+		fmt.Fprintf(out, "%-40s %-22s %10s  %s  %s\n", e.Owner+"/"+e.Name, flags, formatBytes(e.Size), pushed, e.Path)
+		total += e.Size
+	}
+	fmt.Fprintf(out, "\n%d repos, %s\n", len(entries), formatBytes(total))
+	return nil
+}
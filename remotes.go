@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// extraRemotes are additional push destinations, name → URL template with
+// {owner} and {name} placeholders, from config and -remote flags.
+var extraRemotes = map[string]string{}
+
+// remoteFlag is a repeatable NAME=URL-TEMPLATE flag
+type remoteFlag struct{}
+
+func (remoteFlag) String() string { return "" }
+
+func (remoteFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 || v[:i] == "origin" || v[:i] == incrementalRemote {
+		return fmt.Errorf("expected NAME=URL with a name other than origin or %s, got %q", incrementalRemote, v)
+	}
+	extraRemotes[v[:i]] = v[i+1:]
+	return nil
+}
+
+// configureRemote points a remote at url, adding it only when missing and
+// leaving it alone when already correct.
+func configureRemote(dir, name, url string) error {
+	current, err := runGitOutput(dir, "remote", "get-url", name)
+	if err != nil {
+		return runGit(dir, "remote", "add", name, url)
+	}
+	if current == url {
+		return nil
+	}
+	return runGit(dir, "remote", "set-url", name, url)
+}
+
+func expandRemoteURL(template, owner, repoName string) string {
+	url := strings.ReplaceAll(template, "{owner}", owner)
+	return strings.ReplaceAll(url, "{name}", repoName)
+}
+
+// pushExtraRemotes mirrors main to every additional remote. The snapshot
+// replaces what's there, as it does on origin, except with -incremental.
+func pushExtraRemotes(job DirJob) error {
+	names := make([]string, 0, len(extraRemotes))
+	for name := range extraRemotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed []string
+	for _, name := range names {
+		url := expandRemoteURL(extraRemotes[name], job.owner(), job.RepoName)
+		if err := configureRemote(job.Path, name, url); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		release := acquirePush(job.Path, remoteHost(url))
+		err := runGit(job.Path, mirrorPushArgs(name, job.branch())...)
+		release()
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("push to extra remotes failed: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// restoreJob is one repo to clone back to disk
+type restoreJob struct {
+	Repo remoteRepo
+	Meta RepoMetadata
+	Dest string
+}
+
+// runRestore clones gitmax-managed repos back to their recorded source
+// paths, optionally rewritten onto new roots.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	workers := fs.Int("w", DefaultWorkers, "Number of parallel clones")
+	var maps mappingList
+	fs.Var(&maps, "map", "Rewrite a source prefix onto a new root, FROM=TO (repeatable)")
+	into := fs.String("into", "", "Restore unmapped repos below this directory")
+	var matches stringList
+	fs.Var(&matches, "match", "Only restore repos whose source path matches this glob (repeatable)")
+	var orgs stringList
+	fs.Var(&orgs, "org", "Also restore managed repos of this organization (repeatable; owners in the state file are included)")
+	configFile := fs.String("config", "", "Config file with the s3: bucket for offloaded files (default: ~/.gitmax.yml)")
+	fs.IntVar(&restoreDepth, "depth", 0, "Clone only the last n commits (0: all history)")
+	fs.BoolVar(&restorePartial, "partial", false, "Download old file versions only when git needs them (--filter=blob:none)")
+	fs.Parse(args)
+
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Printf("✗ Could not read config: %v\n", err)
+		return 1
+	}
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		return 1
+	}
+
+	repos, err := listManagedRepos(orgs)
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if len(repos) == 0 {
+		fmt.Printf("No repos with the %q topic found\n", managedTopic)
+		return 1
+	}
+
+	jobs := planRestore(repos, maps, *into, *workers)
+	if len(matches) > 0 {
+		jobs = filterRestore(jobs, matches)
+		if len(jobs) == 0 {
+			fmt.Println("No repos match the given patterns")
+			return 1
+		}
+	}
+	return executeRestore(jobs, *workers)
+}
+
+// listManagedRepos returns the repos carrying the marker topic on the
+// account and on orgs, and on every other owner pushed to per the state
+// file (Owner: entries in path lists).
+func listManagedRepos(orgs []string) ([]remoteRepo, error) {
+	all, err := listUserRepos()
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]bool{}
+	for _, org := range orgs {
+		owners[org] = true
+	}
+	for _, r := range state.Repos {
+		owners[r.Owner] = true
+	}
+	for owner := range owners {
+		if owner == "" || strings.EqualFold(owner, githubUser) {
+			continue
+		}
+		repos, err := listOrgRepos(owner)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %v", owner, err)
+		}
+		all = append(all, repos...)
+	}
+
+	seen := map[string]bool{}
+	var managed []remoteRepo
+	for _, r := range all {
+		if containsString(r.Topics, managedTopic) && !seen[r.FullName] {
+			seen[r.FullName] = true
+			managed = append(managed, r)
+		}
+	}
+	sort.Slice(managed, func(i, j int) bool { return managed[i].Name < managed[j].Name })
+	return managed, nil
+}
+
+// planRestore fetches each repo's metadata in parallel and works out where
+// it goes.
+func planRestore(repos []remoteRepo, maps mappingList, into string, workers int) []restoreJob {
+	jobs := make([]restoreJob, len(repos))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo remoteRepo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			job := restoreJob{Repo: repo}
+			owner := strings.SplitN(repo.FullName, "/", 2)[0]
+			if data, err := fetchRepoFile(owner, repo.Name, MetadataFile); err == nil && data != nil {
+				job.Meta, _ = readRepoMetadata(data)
+			}
+			job.Dest = restoreDest(job, maps, into)
+			jobs[i] = job
+		}(i, repo)
+	}
+	wg.Wait()
+	return jobs
+}
+
+// filterRestore keeps jobs whose recorded source path matches any pattern.
+// Repos without metadata can't be matched and are dropped.
+func filterRestore(jobs []restoreJob, patterns []string) []restoreJob {
+	var kept []restoreJob
+	for _, job := range jobs {
+		if job.Meta.SourcePath == "" {
+			continue
+		}
+		for _, p := range patterns {
+			if matchGlob(p, job.Meta.SourcePath) {
+				kept = append(kept, job)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// restoreDest picks the destination: a matching -map rule, else the
+// relative layout below -into, else the original path.
+func restoreDest(job restoreJob, maps mappingList, into string) string {
+	src := job.Meta.SourcePath
+	if src != "" {
+		if dest, ok := maps.apply(src); ok {
+			return dest
+		}
+	}
+	if into != "" {
+		if job.Meta.RelativePath != "" {
+			return joinNative(into, job.Meta.RelativePath)
+		}
+		return filepath.Join(into, job.Repo.Name)
+	}
+	return src
+}
+
+// executeRestore clones the jobs level by level, shallowest destinations
+// first: a directory pushed along with a nested one gets its clone before
+// the nested repo is cloned into it, since a clone needs an empty target.
+func executeRestore(jobs []restoreJob, workers int) int {
+	var restored, failed int64
+	levels := map[int][]restoreJob{}
+	var depths []int
+	for _, job := range jobs {
+		depth := strings.Count(filepath.Clean(job.Dest), string(filepath.Separator))
+		if _, ok := levels[depth]; !ok {
+			depths = append(depths, depth)
+		}
+		levels[depth] = append(levels[depth], job)
+	}
+	sort.Ints(depths)
+
+	for _, depth := range depths {
+		level := levels[depth]
+		queue := make(chan restoreJob, len(level))
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range queue {
+					if err := restoreRepo(job); err != nil {
+						atomic.AddInt64(&failed, 1)
+						fmt.Printf("✗ %s: %v\n", job.Repo.Name, redact(err.Error()))
+						continue
+					}
+					atomic.AddInt64(&restored, 1)
+					fmt.Printf("✓ %s → %s\n", job.Repo.Name, job.Dest)
+				}
+			}()
+		}
+		for _, job := range level {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+	}
+
+	fmt.Printf("\nRestored %d of %d repos (%d failed)\n", restored, len(jobs), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Restores clone the whole history by default. Checkouts only need the
+// last commit's files, so -depth and -partial cut what a restore of many
+// repos with long snapshot histories downloads.
+var (
+	restoreDepth   int
+	restorePartial bool
+)
+
+func restoreCloneArgs() []string {
+	args := []string{"clone", "--quiet"}
+	if restoreDepth > 0 {
+		args = append(args, "--depth", strconv.Itoa(restoreDepth))
+	}
+	if restorePartial {
+		args = append(args, "--filter=blob:none")
+	}
+	return args
+}
+
+// restoreRepo clones one repo and verifies the checkout.
+func restoreRepo(job restoreJob) error {
+	if job.Dest == "" {
+		return fmt.Errorf("no recorded source path; use -into")
+	}
+	if entries, err := os.ReadDir(job.Dest); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination %s is not empty", job.Dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(job.Dest), 0755); err != nil {
+		return err
+	}
+	if err := runGit(filepath.Dir(job.Dest), append(restoreCloneArgs(), job.Repo.CloneURL, job.Dest)...); err != nil {
+		return fmt.Errorf("clone failed: %v", err)
+	}
+	if err := restoreOffloaded(job.Dest); err != nil {
+		return err
+	}
+	if err := verifyCheckout(job.Dest); err != nil {
+		return err
+	}
+	if err := restoreNames(job.Dest); err != nil {
+		return err
+	}
+	return restoreAttrs(job.Dest)
+}
+
+// verifyCheckout checks that every file in the commit was written out.
+func verifyCheckout(dir string) error {
+	// A clean checkout's index lists exactly the commit's files
+	tree, err := lsFilesZ(dir)
+	if err != nil {
+		return fmt.Errorf("verify failed: %v", err)
+	}
+	status, err := runGitOutput(dir, "status", "--porcelain")
+	if err != nil {
+		return fmt.Errorf("verify failed: %v", err)
+	}
+	if status != "" {
+		return fmt.Errorf("verify failed: checkout differs from commit:\n%s", status)
+	}
+
+	for _, name := range tree {
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return fmt.Errorf("verify failed: %s missing", name)
+		}
+	}
+	return nil
+}
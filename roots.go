@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// collectRoots scans every root concurrently and merges the directories
+// into one job list, in root order. A directory reachable from two
+// overlapping roots is queued once, under the first root.
+func collectRoots(roots []string, depth, level int) []DirJob {
+	found := make([][]string, len(roots))
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		go func(i int, root string) {
+			defer wg.Done()
+			if level > 0 {
+				found[i] = levelDirectories(root, level)
+			} else {
+				found[i] = scanDirectories(root, depth)
+			}
+		}(i, root)
+	}
+	wg.Wait()
+
+	var jobs []DirJob
+	seen := map[string]bool{}
+	for i, dirs := range found {
+		for _, dir := range dirs {
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			jobs = append(jobs, DirJob{Path: dir, RepoName: pathToRepoName(dir), Root: roots[i]})
+		}
+	}
+	return jobs
+}
+
+// excludeDirs are the -exclude globs
+var excludeDirs stringList
+
+// withoutExcluded drops the jobs whose directory, or a directory above
+// it, matches an -exclude glob.
+func withoutExcluded(jobs []DirJob) []DirJob {
+	if len(excludeDirs) == 0 {
+		return jobs
+	}
+	var kept []DirJob
+	for _, job := range jobs {
+		if !excludedDir(job.Path) {
+			kept = append(kept, job)
+		}
+	}
+	return kept
+}
+
+func excludedDir(path string) bool {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		for _, pattern := range excludeDirs {
+			if matchGlob(pattern, dir) {
+				return true
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return false
+		}
+	}
+}
+
+// RootStats are the totals for one scan root; paths read from -f are
+// grouped under an empty root.
+type RootStats struct {
+	Root    string `json:"root"`
+	Total   int64  `json:"total"`
+	Success int64  `json:"success"`
+	Failed  int64  `json:"failed"`
+	Skipped int64  `json:"skipped"`
+	Bytes   int64  `json:"bytes_pushed"`
+}
+
+func rootStats() []RootStats {
+	byRoot := map[string]*RootStats{}
+	for _, r := range results {
+		s, ok := byRoot[r.Root]
+		if !ok {
+			s = &RootStats{Root: r.Root}
+			byRoot[r.Root] = s
+		}
+		s.Total++
+		s.Bytes += r.Transfer.Bytes
+		switch {
+		case r.Skipped:
+			s.Skipped++
+		case r.Success:
+			s.Success++
+		default:
+			s.Failed++
+		}
+	}
+
+	list := make([]RootStats, 0, len(byRoot))
+	for _, s := range byRoot {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Root < list[j].Root })
+	return list
+}
+
+// printRootStats breaks the totals down per root when there was more
+// than one.
+func printRootStats() {
+	list := rootStats()
+	if len(list) < 2 {
+		return
+	}
+	fmt.Printf("\n📂 Per root:\n")
+	for _, s := range list {
+		root := s.Root
+		if root == "" {
+			root = "(path list)"
+		}
+		fmt.Printf("   %s: %d ok, %d failed, %d skipped, %s\n", root, s.Success, s.Failed, s.Skipped, formatBytes(s.Bytes))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runID identifies this invocation across output, state and run records
+var runID string
+
+// RunRecord is everything needed to undo a run, kept in
+// ~/.gitmax/runs/<id>.json.
+type RunRecord struct {
+	ID         string       `json:"id"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Labels     []string     `json:"labels,omitempty"`
+	Repos      []RunRepoRef `json:"repos"`
+}
+
+// RunRepoRef is one repo a run pushed to
+type RunRepoRef struct {
+	Owner     string `json:"owner"`
+	Name      string `json:"name"`
+	Branch    string `json:"branch,omitempty"`
+	Path      string `json:"path"`
+	Created   bool   `json:"created"`
+	PrevSHA   string `json:"prev_sha,omitempty"`
+	PushedSHA string `json:"pushed_sha,omitempty"`
+}
+
+// newRunID returns a random RFC 4122 version 4 UUID.
+func newRunID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func runsDir() string {
+	return filepath.Join(gitmaxDir(), "runs")
+}
+
+func saveRunRecord() error {
+	record := RunRecord{
+		ID:         runID,
+		StartedAt:  stats.StartTime,
+		FinishedAt: time.Now(),
+		Labels:     runLabels,
+		Repos:      []RunRepoRef{},
+	}
+	// A result that failed after its GitHub push still changed the remote
+	for _, r := range results {
+		if !r.Success && r.PushedSHA == "" {
+			continue
+		}
+		record.Repos = append(record.Repos, RunRepoRef{
+			Owner:     r.Owner,
+			Name:      r.RepoName,
+			Branch:    r.Branch,
+			Path:      r.Path,
+			Created:   r.Created,
+			PrevSHA:   r.PrevSHA,
+			PushedSHA: r.PushedSHA,
+		})
+	}
+
+	if err := os.MkdirAll(runsDir(), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(runsDir(), runID+".json"), data, 0644)
+}
+
+func loadRunRecord(id string) (*RunRecord, error) {
+	data, err := ioutil.ReadFile(filepath.Join(runsDir(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var record RunRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// listRunRecords returns all recorded runs, oldest first.
+func listRunRecords() ([]*RunRecord, error) {
+	entries, err := os.ReadDir(runsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*RunRecord
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		record, err := loadRunRecord(e.Name()[:len(e.Name())-len(".json")])
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].StartedAt.Before(records[j].StartedAt) })
+	return records, nil
+}
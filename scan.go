@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScanEntry is one directory of the plan gitmax would push
+type ScanEntry struct {
+	Path     string `json:"path"`
+	RepoName string `json:"repo_name"`
+	Size     int64  `json:"size"` // Hardlinked and reflinked data counted once
+	Files    int    `json:"files"`
+	Language string `json:"language,omitempty"`
+
+	NameIssues []NameIssue `json:"name_issues,omitempty"` // Names some platform can't restore
+}
+
+// languages maps file extensions to the language they indicate
+var languages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".mjs": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".jsx": "JavaScript",
+	".rs": "Rust", ".java": "Java", ".kt": "Kotlin", ".c": "C", ".h": "C",
+	".cpp": "C++", ".cc": "C++", ".hpp": "C++", ".cs": "C#", ".rb": "Ruby",
+	".php": "PHP", ".swift": "Swift", ".sh": "Shell", ".ps1": "PowerShell",
+	".bat": "Batchfile", ".lua": "Lua", ".r": "R", ".scala": "Scala",
+	".html": "HTML", ".css": "CSS", ".vue": "Vue", ".dart": "Dart",
+}
+
+// scanInventory walks root once and describes each of dirs with its
+// recursive size, file count, dominant language and the names other
+// platforms can't restore.
+func scanInventory(root string, dirs []string) []ScanEntry {
+	index := make(map[string]int, len(dirs))
+	entries := make([]ScanEntry, len(dirs))
+	langBytes := make([]map[string]int64, len(dirs))
+	sizes := make([]*sizeCounter, len(dirs))
+	names := make([]*nameChecker, len(dirs))
+	for i, d := range dirs {
+		index[d] = i
+		entries[i] = ScanEntry{Path: d, RepoName: pathToRepoName(d)}
+		langBytes[i] = map[string]int64{}
+		sizes[i] = newSizeCounter()
+		names[i] = newNameChecker()
+	}
+	top := filepath.Clean(root)
+	checkName := func(path string) {
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if i, ok := index[dir]; ok {
+				rel, _ := filepath.Rel(dir, path)
+				names[i].check(filepath.ToSlash(rel))
+			}
+			if dir == top || filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" || path != root && isJunk(info.Name()) {
+				return filepath.SkipDir
+			}
+			if path != root {
+				checkName(path)
+			}
+			return nil
+		}
+		if isJunk(info.Name()) {
+			return nil
+		}
+		checkName(path)
+		lang := languages[strings.ToLower(filepath.Ext(path))]
+
+		// Each directory's repo holds its whole subtree, so the file counts
+		// toward every listed ancestor.
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if i, ok := index[dir]; ok {
+				entries[i].Size += sizes[i].add(path, info)
+				entries[i].Files++
+				if lang != "" {
+					langBytes[i][lang] += info.Size()
+				}
+			}
+			if dir == top || filepath.Dir(dir) == dir {
+				break
+			}
+		}
+		return nil
+	})
+
+	for i := range entries {
+		entries[i].NameIssues = names[i].issues
+		var best int64
+		for lang, n := range langBytes[i] {
+			if n > best || (n == best && lang < entries[i].Language) {
+				best, entries[i].Language = n, lang
+			}
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Path < entries[b].Path })
+	return entries
+}
+
+// runScan prints the inventory without touching git or the network. The
+// text format is one path per line, ready to be edited and passed to -f.
+func runScan(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	depth := fs.Int("depth", 20, "Max directory depth")
+	fs.BoolVar(&includeHiddenDirs, "include-hidden", false, "Scan hidden directories")
+	level := fs.Int("level", 0, "Only directories exactly this many levels below the root")
+	output := fs.String("o", "text", "Output format: text or json")
+	var since, before timeBound
+	fs.Var(&since, "modified-since", "Only directories with a file modified since this age or date")
+	fs.Var(&before, "modified-before", "Only directories with no file modified since this age or date")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: gitmax scan [-depth N] [-level N] [-o text|json] <root>")
+		return 1
+	}
+	root := normalizePath(fs.Arg(0))
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		fmt.Printf("✗ Not a directory: %s\n", root)
+		return 1
+	}
+
+	dirs := scanDirectories(root, *depth)
+	if *level > 0 {
+		dirs = levelDirectories(root, *level)
+	}
+	dirs = filterByAge(dirs, since.t, before.t)
+	entries := scanInventory(root, dirs)
+	switch *output {
+	case "json":
+		data, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(data))
+	case "text":
+		for _, e := range entries {
+			fmt.Println(e.Path)
+		}
+		// On stderr, so the list stays usable with -f
+		for _, e := range entries {
+			for _, issue := range e.NameIssues {
+				fmt.Fprintf(os.Stderr, "⚠ %s: %s %s\n", e.Path, issue.Path, issue.Problem)
+			}
+		}
+	default:
+		fmt.Printf("Unknown output format %q\n", *output)
+		return 1
+	}
+	return 0
+}
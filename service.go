@@ -0,0 +1,247 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Continuous backup is a scheduled push: gitmax service registers the
+// current binary and arguments with the platform scheduler — a systemd user
+// timer, a launchd agent, or a Windows scheduled task.
+const serviceName = "gitmax"
+
+func runService(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: gitmax service install|uninstall|status [-every 1h] [-- push flags]")
+		return 1
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("service "+sub, flag.ExitOnError)
+	every := fs.Duration("every", time.Hour, "Interval between runs")
+	configFile := fs.String("config", "", "Config file the service runs with (default: ~/.gitmax.yml if present)")
+	fs.Parse(args[1:])
+
+	var err error
+	switch sub {
+	case "install":
+		pushArgs := fs.Args()
+		if len(pushArgs) == 0 {
+			fmt.Println("✗ give the push flags after --, e.g. gitmax service install -- -d ~/projects")
+			return 1
+		}
+		err = installService(serviceArgs(*configFile, absPathArgs(pushArgs)), *every)
+	case "uninstall":
+		err = uninstallService()
+	case "status":
+		err = serviceStatus()
+	default:
+		err = fmt.Errorf("unknown service command %q", sub)
+	}
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// serviceArgs is the full command line the scheduler runs.
+func serviceArgs(configFile string, pushArgs []string) []string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "gitmax"
+	}
+	args := []string{exe}
+
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigPath()); err == nil {
+			configFile = defaultConfigPath()
+		}
+	}
+	if configFile != "" {
+		abs := normalizePath(configFile)
+		args = append(args, "-config", abs)
+	}
+	return append(args, pushArgs...)
+}
+
+func installService(args []string, every time.Duration) error {
+	switch runtime.GOOS {
+	case "linux":
+		return installSystemd(args, every)
+	case "darwin":
+		return installLaunchd(args, every)
+	case "windows":
+		return installSchtasks(args, every)
+	}
+	return fmt.Errorf("service install is not supported on %s", runtime.GOOS)
+}
+
+func uninstallService() error {
+	switch runtime.GOOS {
+	case "linux":
+		runCommand("systemctl", "--user", "disable", "--now", serviceName+".timer")
+		os.Remove(filepath.Join(systemdUserDir(), serviceName+".service"))
+		os.Remove(filepath.Join(systemdUserDir(), serviceName+".timer"))
+		return runCommand("systemctl", "--user", "daemon-reload")
+	case "darwin":
+		runCommand("launchctl", "unload", "-w", launchdPlist())
+		return os.Remove(launchdPlist())
+	case "windows":
+		return runCommand("schtasks", "/Delete", "/TN", serviceName, "/F")
+	}
+	return fmt.Errorf("service uninstall is not supported on %s", runtime.GOOS)
+}
+
+func serviceStatus() error {
+	switch runtime.GOOS {
+	case "linux":
+		return runCommand("systemctl", "--user", "status", "--no-pager", serviceName+".timer", serviceName+".service")
+	case "darwin":
+		return runCommand("launchctl", "list", launchdLabel)
+	case "windows":
+		return runCommand("schtasks", "/Query", "/TN", serviceName, "/V", "/FO", "LIST")
+	}
+	return fmt.Errorf("service status is not supported on %s", runtime.GOOS)
+}
+
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func systemdUserDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "systemd", "user")
+}
+
+func installSystemd(args []string, every time.Duration) error {
+	var quoted []string
+	for _, a := range args {
+		quoted = append(quoted, `"`+strings.ReplaceAll(a, `"`, `\"`)+`"`)
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=gitmax backup push
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Join(quoted, " "))
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Run gitmax every %s
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=%ds
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, every, int(every.Seconds()))
+
+	dir := systemdUserDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, serviceName+".service"), []byte(unit), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, serviceName+".timer"), []byte(timer), 0644); err != nil {
+		return err
+	}
+	if err := runCommand("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runCommand("systemctl", "--user", "enable", "--now", serviceName+".timer"); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Installed systemd user timer %s.timer (every %s)\n", serviceName, every)
+	return nil
+}
+
+const launchdLabel = "com.gitmax.backup"
+
+func launchdPlist() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+}
+
+func installLaunchd(args []string, every time.Duration) error {
+	var b strings.Builder
+	for _, a := range args {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(a))
+	}
+	logPath := filepath.Join(gitmaxDir(), "service.log")
+
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+%s	</array>
+	<key>StartInterval</key>
+	<integer>%d</integer>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, b.String(), int(every.Seconds()), xmlEscape(logPath), xmlEscape(logPath))
+
+	if err := os.MkdirAll(filepath.Dir(launchdPlist()), 0755); err != nil {
+		return err
+	}
+	os.MkdirAll(gitmaxDir(), 0755)
+	if err := ioutil.WriteFile(launchdPlist(), []byte(plist), 0644); err != nil {
+		return err
+	}
+	if err := runCommand("launchctl", "load", "-w", launchdPlist()); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Installed launchd agent %s (every %s)\n", launchdLabel, every)
+	return nil
+}
+
+func xmlEscape(s string) string {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	return r.Replace(s)
+}
+
+func installSchtasks(args []string, every time.Duration) error {
+	var quoted []string
+	for _, a := range args {
+		if strings.ContainsAny(a, " \t") {
+			a = `"` + a + `"`
+		}
+		quoted = append(quoted, a)
+	}
+	minutes := int(every.Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	err := runCommand("schtasks", "/Create", "/TN", serviceName, "/TR", strings.Join(quoted, " "),
+		"/SC", "MINUTE", "/MO", fmt.Sprint(minutes), "/F")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Installed scheduled task %s (every %s)\n", serviceName, every)
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Hidden directories are mostly caches and tool state (.cache, .vscode,
+// .venv), so by default they neither become repos nor get committed.
+var (
+	includeHiddenDirs  bool
+	includeHiddenFiles bool
+)
+
+// alwaysStaged are dotfiles and dot-directories gitmax commits regardless
+// of -hidden-files, since they shape the repo itself.
+var alwaysStaged = []string{".gitignore", ".gitattributes", MetadataFile, OffloadManifest, GitHubMetaDir, AttrsFile}
+
+// isAlwaysStaged reports whether rel is, or is inside, an alwaysStaged entry.
+func isAlwaysStaged(rel string) bool {
+	for _, name := range alwaysStaged {
+		if rel == name || strings.HasPrefix(rel, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") && name != "." && name != ".."
+}
+
+// hasHiddenSegment reports whether any element of a relative path is hidden.
+func hasHiddenSegment(rel string) bool {
+	for _, seg := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isHidden(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+// stageFilter decides which files of a directory get committed: no junk,
+// hidden entries only with -hidden-files, and only the configured
+// subpaths when the directory has a paths entry in the config.
+type stageFilter struct {
+	paths []string // Slash-separated subpaths; empty means everything
+}
+
+func newStageFilter(dir string) stageFilter {
+	var f stageFilter
+	if d, ok := dirConfig(dir); ok {
+		for _, p := range d.Paths {
+			f.paths = append(f.paths, filepath.ToSlash(filepath.Clean(p)))
+		}
+	}
+	return f
+}
+
+// includes reports whether the file at rel is committed.
+func (f stageFilter) includes(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if isAlwaysStaged(rel) {
+		return true
+	}
+	if hasJunkSegment(rel) || !includeHiddenFiles && hasHiddenSegment(rel) {
+		return false
+	}
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, p := range f.paths {
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// includesDir reports whether a walk needs to descend into rel.
+func (f stageFilter) includesDir(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if isAlwaysStaged(rel) {
+		return true
+	}
+	if hasJunkSegment(rel) || !includeHiddenFiles && hasHiddenSegment(rel) {
+		return false
+	}
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, p := range f.paths {
+		if rel == p || strings.HasPrefix(rel, p+"/") || strings.HasPrefix(p, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// stageFiles runs git add restricted by the directory's stageFilter.
+func stageFiles(dir string) error {
+	spec, err := stagePathspec(dir)
+	if err != nil {
+		return err
+	}
+	if err := runGit(dir, append([]string{"add", "-A", "--"}, spec...)...); err != nil {
+		return err
+	}
+
+	keep := existingAlwaysStaged(dir)
+	if len(keep) == 0 {
+		return nil
+	}
+	return runGit(dir, append([]string{"add", "--"}, keep...)...)
+}
+
+// stagePathspec is the pathspec selecting what the stageFilter commits.
+func stagePathspec(dir string) ([]string, error) {
+	f := newStageFilter(dir)
+	spec := []string{"."}
+	if len(f.paths) > 0 {
+		spec = nil
+		for _, p := range f.paths {
+			// git add fails on a pathspec that matches nothing
+			if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(p))); err == nil {
+				spec = append(spec, p)
+			}
+		}
+		if len(spec) == 0 {
+			return nil, fmt.Errorf("none of the configured paths exist: %s", strings.Join(f.paths, ", "))
+		}
+	}
+	if !includeHiddenFiles {
+		// Without :(glob) magic "*" also matches "/", so these cover hidden
+		// entries at every depth.
+		spec = append(spec, ":(exclude).*", ":(exclude)*/.*")
+	}
+	return append(spec, junkPathspec()...), nil
+}
+
+// existingAlwaysStaged lists the alwaysStaged entries present in dir.
+func existingAlwaysStaged(dir string) []string {
+	var keep []string
+	for _, name := range alwaysStaged {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			keep = append(keep, name)
+		}
+	}
+	return keep
+}
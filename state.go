@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is what gitmax remembers about pushed repos between runs, kept in
+// ~/.gitmax/state.json.
+type State struct {
+	Repos     map[string]*RepoState      `json:"repos"`                // Keyed by owner/name
+	HTTPCache map[string]*CachedResponse `json:"http_cache,omitempty"` // Keyed by cacheKey
+}
+
+// RepoState is the record for one pushed repo
+type RepoState struct {
+	Owner    string    `json:"owner"`
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Branch   string    `json:"branch,omitempty"` // Branch pushed to, empty for main
+	URL      string    `json:"url"`
+	Labels   []string  `json:"labels,omitempty"`
+	LastPush time.Time `json:"last_push"`
+	LastRun  string    `json:"last_run,omitempty"` // Run ID of the last push
+
+	// Replaced history stays on GitHub as unreachable objects
+	ForcePushes      int   `json:"force_pushes,omitempty"`
+	ForcePushedBytes int64 `json:"force_pushed_bytes,omitempty"`
+}
+
+// branch is the remote branch the directory was pushed to.
+func (r *RepoState) branch() string {
+	if r.Branch != "" {
+		return r.Branch
+	}
+	return "main"
+}
+
+var (
+	state     = &State{Repos: map[string]*RepoState{}}
+	statePath string
+	stateMu   sync.Mutex
+	runLabels stringList
+	labelTags bool
+)
+
+func loadState() error {
+	if statePath == "" {
+		statePath = filepath.Join(gitmaxDir(), "state.json")
+	}
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return err
+	}
+	if state.Repos == nil {
+		state.Repos = map[string]*RepoState{}
+	}
+	return nil
+}
+
+func saveState() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	pruneHTTPCache()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write-then-rename so a crash never leaves a truncated state file
+	tmp := statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
+
+// recordPush updates the state entry for a successfully pushed repo.
+func recordPush(job DirJob, result Result) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	key := job.owner() + "/" + job.RepoName
+	entry, ok := state.Repos[key]
+	if !ok {
+		entry = &RepoState{Owner: job.owner(), Name: job.RepoName}
+		state.Repos[key] = entry
+	}
+	entry.Path = job.Path
+	entry.Branch = job.Branch
+	entry.URL = result.RepoURL
+	entry.LastPush = time.Now()
+	entry.LastRun = runID
+	if result.Forced {
+		entry.ForcePushes++
+		entry.ForcePushedBytes += result.Transfer.Bytes
+	}
+	for _, l := range runLabels {
+		if !containsString(entry.Labels, l) {
+			entry.Labels = append(entry.Labels, l)
+		}
+	}
+	sort.Strings(entry.Labels)
+}
+
+// reposWithLabel returns the state entries carrying a label.
+func reposWithLabel(label string) []*RepoState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	var repos []*RepoState
+	for _, r := range state.Repos {
+		if containsString(r.Labels, label) {
+			repos = append(repos, r)
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Path < repos[j].Path })
+	return repos
+}
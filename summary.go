@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+)
+
+// SummaryVersion is bumped whenever a field changes meaning or is removed.
+// Adding fields does not change it.
+const SummaryVersion = 1
+
+// RunSummary is the machine-readable result of a run, written to
+// gitmax-summary.json for CI steps to parse.
+type RunSummary struct {
+	Version         int             `json:"version"`
+	RunID           string          `json:"run_id"`
+	Host            string          `json:"host"`
+	StartedAt       time.Time       `json:"started_at"`
+	FinishedAt      time.Time       `json:"finished_at"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	DryRun          bool            `json:"dry_run"`
+	Aborted         string          `json:"aborted,omitempty"` // Why remaining jobs were skipped
+	Totals          SummaryTotals   `json:"totals"`
+	Roots           []RootStats     `json:"roots,omitempty"`
+	Pauses          []PauseWindow   `json:"pauses,omitempty"`
+	Results         []SummaryResult `json:"results"`
+}
+
+type SummaryTotals struct {
+	Total   int64 `json:"total"`
+	Success int64 `json:"success"`
+	Failed  int64 `json:"failed"`
+	Skipped int64 `json:"skipped"`
+	Bytes   int64 `json:"bytes_pushed"`
+}
+
+// SummaryResult has status "success", "failed" or "skipped"
+type SummaryResult struct {
+	Path            string   `json:"path"`
+	Status          string   `json:"status"`
+	Message         string   `json:"message,omitempty"`
+	RepoURL         string   `json:"repo_url,omitempty"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	LargeFiles      []string `json:"large_files,omitempty"`
+	Bytes           int64    `json:"bytes_pushed"`
+	Objects         int64    `json:"objects_pushed"`
+	Transport       string   `json:"transport,omitempty"`
+}
+
+func writeSummary(path string) error {
+	finished := time.Now()
+	summary := RunSummary{
+		Version:         SummaryVersion,
+		RunID:           runID,
+		Host:            hostname,
+		StartedAt:       stats.StartTime,
+		FinishedAt:      finished,
+		DurationSeconds: finished.Sub(stats.StartTime).Seconds(),
+		DryRun:          dryRun,
+		Totals: SummaryTotals{
+			Total:   stats.Total,
+			Success: atomic.LoadInt64(&stats.Success),
+			Failed:  atomic.LoadInt64(&stats.Failed),
+			Skipped: atomic.LoadInt64(&stats.Skipped),
+			Bytes:   atomic.LoadInt64(&stats.BytesPushed),
+		},
+		Results: []SummaryResult{},
+	}
+	if msg, ok := abortedMsg.Load().(string); ok {
+		summary.Aborted = msg
+	}
+	pausesMu.Lock()
+	summary.Pauses = pauses
+	pausesMu.Unlock()
+	if roots := rootStats(); len(roots) > 1 {
+		summary.Roots = roots
+	}
+
+	for _, r := range results {
+		status := "failed"
+		if r.Skipped {
+			status = "skipped"
+		} else if r.Success {
+			status = "success"
+		}
+		summary.Results = append(summary.Results, SummaryResult{
+			Path:            r.Path,
+			Status:          status,
+			Message:         r.Message,
+			RepoURL:         r.RepoURL,
+			DurationSeconds: r.Duration.Seconds(),
+			LargeFiles:      r.LargeFiles,
+			Bytes:           r.Transfer.Bytes,
+			Objects:         r.Transfer.Objects,
+			Transport:       r.Transport,
+		})
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
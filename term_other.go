@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly || windows)
+
+package main
+
+// ttyWidth is unknown here; COLUMNS still applies.
+func ttyWidth() int {
+	return 0
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+
+// consoleScreenBufferInfo is CONSOLE_SCREEN_BUFFER_INFO
+type consoleScreenBufferInfo struct {
+	Size, Cursor             [2]int16
+	Attributes               uint16
+	Left, Top, Right, Bottom int16
+	MaxSize                  [2]int16
+}
+
+// ttyWidth is the column count of the console window on stdout, 0 if it
+// isn't one.
+func ttyWidth() int {
+	var info consoleScreenBufferInfo
+	if r, _, _ := procGetConsoleScreenBufferInfo.Call(os.Stdout.Fd(), uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0
+	}
+	return int(info.Right-info.Left) + 1
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The progress line and the boxes are drawn to fit the terminal. Its
+// width is read on every redraw, so a resize shows up within a tick;
+// COLUMNS overrides it. Output that isn't a terminal keeps full width.
+const (
+	maxBoxWidth = 64
+	minBoxWidth = 40
+)
+
+var (
+	progressShown int // Characters of the progress line on screen
+	progressWidth int // Terminal width it was drawn for
+)
+
+// terminalWidth returns the terminal's column count, or 0 when unknown.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return ttyWidth()
+}
+
+// boxInner is the width inside a box's borders.
+func boxInner() int {
+	w := terminalWidth()
+	if w <= 0 || w > maxBoxWidth {
+		w = maxBoxWidth
+	}
+	if w < minBoxWidth {
+		w = minBoxWidth
+	}
+	return w - 2
+}
+
+func boxTop()     { boxBorder("╔", "╗") }
+func boxDivider() { boxBorder("╠", "╣") }
+func boxBottom()  { boxBorder("╚", "╝") }
+
+func boxBorder(left, right string) {
+	fmt.Println(left + strings.Repeat("═", boxInner()) + right)
+}
+
+// boxRow prints a "label: value" line of a box, padding by characters
+// rather than bytes so translated labels line up. Values too long for
+// the box are shortened from the left, which keeps the end of a path.
+func boxRow(labelWidth int, label, value string) {
+	label = padRight(T(label)+": ", labelWidth)
+	room := boxInner() - 3 - utf8.RuneCountInString(label)
+	fmt.Printf("║  %s%s ║\n", label, padRight(truncatePath(value, room), room))
+}
+
+// boxTitle prints a box's title line.
+func boxTitle(title string, centered bool) {
+	inner := boxInner()
+	title = T(title)
+	if centered {
+		title = strings.Repeat(" ", max(0, (inner-2-utf8.RuneCountInString(title))/2)) + title
+	}
+	fmt.Printf("║  %s║\n", padRight(truncateEnd(title, inner-2), inner-2))
+}
+
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// truncateEnd shortens s from the right to at most n characters.
+func truncateEnd(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n || n < 1 {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// drawProgress replaces the progress line with line, cut to the width.
+func drawProgress(line string, width int) {
+	if width <= 0 {
+		// Not a terminal: no escape sequences, just overwrite
+		fmt.Print("\r" + padRight(line, progressShown))
+		progressShown = utf8.RuneCountInString(line)
+		return
+	}
+	line = truncateEnd(line, width-1)
+	clearProgress(width)
+	fmt.Print(line)
+	progressShown = utf8.RuneCountInString(line)
+	progressWidth = width
+}
+
+// clearProgress erases the progress line. A terminal that narrowed since
+// the last draw has wrapped it onto more rows, which are erased too.
+func clearProgress(width int) {
+	if progressShown == 0 {
+		return
+	}
+	if width < progressWidth {
+		if rows := (progressShown - 1) / width; rows > 0 {
+			fmt.Printf("\r\033[%dA", rows)
+		}
+	}
+	fmt.Print("\r\033[J")
+	progressShown = 0
+}
+
+// endProgress leaves the final progress line in place and moves below it,
+// so what follows starts on a line of its own.
+func endProgress() {
+	if progressShown > 0 {
+		fmt.Println()
+		progressShown = 0
+	}
+}
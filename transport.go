@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Pushes go over HTTPS by default. When a repo can't be reached that way
+// (intercepting proxies, token auth rejected with 400s) and SSH keys work
+// too, the push is retried over SSH, and the other way around with
+// -transport ssh. After a few such fallbacks the run switches its primary
+// transport for the remaining repos.
+const fallbackSwitchAfter = 3
+
+var (
+	transportFlag   = "https"
+	fallbackCount   int64
+	primarySwitched int32
+
+	sshOnce sync.Once
+	sshOK   bool
+)
+
+func otherTransport(t string) string {
+	if t == "ssh" {
+		return "https"
+	}
+	return "ssh"
+}
+
+func primaryTransport() string {
+	if atomic.LoadInt32(&primarySwitched) == 1 {
+		return otherTransport(transportFlag)
+	}
+	return transportFlag
+}
+
+func originURL(transport, owner, repoName string) string {
+	if transport == "ssh" {
+		return fmt.Sprintf("git@github.com:%s/%s.git", owner, repoName)
+	}
+	return fmt.Sprintf("https://github.com/%s/%s.git", owner, repoName)
+}
+
+// transportAvailable reports whether credentials for a transport exist.
+func transportAvailable(t string) bool {
+	if t == "https" {
+		return hasAPI()
+	}
+	sshOnce.Do(func() {
+		// GitHub answers a successful auth with exit status 1 and a greeting.
+		// The probe never adds github.com's key to known_hosts; trusting it
+		// is the user's call.
+		out, _ := exec.Command("ssh", "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10",
+			"-o", "StrictHostKeyChecking=yes", "git@github.com").CombinedOutput()
+		sshOK = strings.Contains(string(out), "successfully authenticated")
+		if strings.Contains(string(out), "Host key verification failed") {
+			fmt.Println("⚠ ssh unavailable: host key not known for github.com (add it to known_hosts to allow SSH)")
+		}
+	})
+	return sshOK
+}
+
+// connectOrigin points the push remote (origin, or gitmax with
+// -incremental) at the primary transport, falling back to
+// the other one when the remote can't be listed. It returns the transport
+// in use and the remote branch commit ("" when there is none).
+func connectOrigin(job DirJob) (string, string, error) {
+	transport := primaryTransport()
+	if err := configureRemote(job.Path, pushRemote(), originURL(transport, job.owner(), job.RepoName)); err != nil {
+		return transport, "", err
+	}
+	out, err := runGitOutput(job.Path, "ls-remote", "--heads", pushRemote(), job.branch())
+	if err != nil && !jobStopped(job.Path) && transportAvailable(otherTransport(transport)) {
+		alt := otherTransport(transport)
+		if err := configureRemote(job.Path, pushRemote(), originURL(alt, job.owner(), job.RepoName)); err != nil {
+			return transport, "", err
+		}
+		if altOut, altErr := runGitOutput(job.Path, "ls-remote", "--heads", pushRemote(), job.branch()); altErr == nil {
+			noteFallback(transport, alt)
+			return alt, headFromLsRemote(altOut), nil
+		}
+		// Neither works; stay on the primary so errors name the usual URL
+		configureRemote(job.Path, pushRemote(), originURL(transport, job.owner(), job.RepoName))
+	}
+	return transport, headFromLsRemote(out), nil
+}
+
+func headFromLsRemote(out string) string {
+	if fields := strings.Fields(out); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// pushOrigin runs the push, retrying once over the other transport. A push
+// the remote rejected, or one killed by a cancel or the stuck detector,
+// isn't retried.
+func pushOrigin(job DirJob, transport string, args ...string) (pushTransfer, string, error) {
+	release := acquirePush(job.Path, "github.com")
+	defer release()
+	transfer, err := runGitPush(job.Path, args...)
+	if err == nil || jobStopped(job.Path) || pushRejected(err) {
+		return transfer, transport, err
+	}
+	alt := otherTransport(transport)
+	if !transportAvailable(alt) {
+		return transfer, transport, err
+	}
+	if cerr := configureRemote(job.Path, pushRemote(), originURL(alt, job.owner(), job.RepoName)); cerr != nil {
+		return transfer, transport, err
+	}
+	altTransfer, altErr := runGitPush(job.Path, args...)
+	if altErr != nil {
+		return transfer, transport, fmt.Errorf("%v (%s retry: %v)", err, alt, altErr)
+	}
+	noteFallback(transport, alt)
+	return altTransfer, alt, nil
+}
+
+func noteFallback(from, to string) {
+	if atomic.AddInt64(&fallbackCount, 1) == fallbackSwitchAfter && from == primaryTransport() {
+		if atomic.CompareAndSwapInt32(&primarySwitched, 0, 1) {
+			fmt.Printf("\n⚠ %s keeps failing; using %s first for the remaining repos\n", strings.ToUpper(from), strings.ToUpper(to))
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Before gitmax overwrites or deletes remote history it keeps the old state
+// around: overwritten commits get a gitmax-trash/<branch>-<time> branch in
+// the same repo, deleted repos are renamed to gitmax-trash-<name>-<date>
+// instead. Trash branches pin the old objects, so each one added during a
+// run also drops that repo's branches older than trashDays.
+const (
+	trashBranchPrefix = "gitmax-trash/"
+	trashRepoPrefix   = "gitmax-trash-"
+	trashTimeFormat   = "20060102-150405"
+
+	// trashRefTimeFormat keeps two trash branches made in the same second
+	// apart; branches from before it carry trashTimeFormat
+	trashRefTimeFormat = "20060102-150405.000000"
+)
+
+var (
+	useTrash  = true
+	trashDays = 30 // 0 keeps trash branches until purged by hand
+)
+
+// trashRef preserves the commit a branch is about to be overwritten from.
+func trashRef(owner, repoName, branch, sha string) error {
+	if !useTrash || sha == "" || !hasAPI() {
+		return nil
+	}
+	ref := "refs/heads/" + trashBranchPrefix + branch + "-" + time.Now().UTC().Format(trashRefTimeFormat)
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, owner, repoName)
+	if err := apiPost(repoAPI+"/git/refs", map[string]string{"ref": ref, "sha": sha}, nil); err != nil {
+		return err
+	}
+	if trashDays > 0 {
+		if err := pruneTrashRefs(owner, repoName, time.Now().AddDate(0, 0, -trashDays)); err != nil && verbose {
+			fmt.Printf("pruning trash of %s/%s failed: %v\n", owner, repoName, err)
+		}
+	}
+	return nil
+}
+
+// pruneTrashRefs deletes a repo's trash branches from before cutoff.
+func pruneTrashRefs(owner, repoName string, cutoff time.Time) error {
+	entries, err := listTrashRefs(owner, repoName)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Time.IsZero() && e.Time.Before(cutoff) {
+			if err := deleteTrash(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteTrash removes a trash branch or trashed repo for good.
+func deleteTrash(e trashEntry) error {
+	url := fmt.Sprintf("%s/repos/%s/%s", githubAPI, e.Owner, e.Repo)
+	if e.Branch != "" {
+		url += "/git/refs/heads/" + e.Branch
+	}
+	return apiSend("DELETE", url, nil, nil)
+}
+
+// trashRepo renames a repo out of the way in place of deleting it.
+func trashRepo(owner, repoName string) (string, error) {
+	newName := trashRepoPrefix + repoName + "-" + time.Now().UTC().Format(trashTimeFormat)
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, owner, repoName)
+	return newName, apiSend("PATCH", repoAPI, map[string]string{"name": newName}, nil)
+}
+
+// trashEntry is a trash branch or trashed repo
+type trashEntry struct {
+	Owner  string
+	Repo   string
+	Branch string // Empty for a trashed repo
+	Source string // Branch the trash branch was overwritten from
+	Time   time.Time
+}
+
+func (e trashEntry) String() string {
+	if e.Branch == "" {
+		return fmt.Sprintf("%s/%s (repo)", e.Owner, e.Repo)
+	}
+	return fmt.Sprintf("%s/%s@%s", e.Owner, e.Repo, e.Branch)
+}
+
+// runTrash implements trash list, restore and purge.
+func runTrash(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: gitmax trash list|restore|purge [flags]")
+		return 1
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("trash "+sub, flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	days := fs.Int("days", trashDays, "Retention window for purge")
+	fs.Parse(args[1:])
+
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		return 1
+	}
+
+	entries, err := listTrash()
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+
+	switch sub {
+	case "list":
+		for _, e := range entries {
+			fmt.Printf("%s  %s\n", e.Time.Format("2006-01-02 15:04"), e)
+		}
+		fmt.Printf("\n%d trash entries\n", len(entries))
+		return 0
+
+	case "restore":
+		if fs.NArg() != 1 {
+			fmt.Println("Usage: gitmax trash restore <owner/repo@branch | owner/gitmax-trash-repo>")
+			return 1
+		}
+		for _, e := range entries {
+			if e.String() == fs.Arg(0) || e.Owner+"/"+e.Repo == fs.Arg(0) && e.Branch == "" {
+				if err := restoreTrash(e); err != nil {
+					fmt.Printf("✗ %v\n", redact(err.Error()))
+					return 1
+				}
+				fmt.Printf("✓ restored %s\n", e)
+				return 0
+			}
+		}
+		fmt.Printf("✗ no trash entry %s\n", fs.Arg(0))
+		return 1
+
+	case "purge":
+		cutoff := time.Now().AddDate(0, 0, -*days)
+		var purged int
+		for _, e := range entries {
+			if e.Time.After(cutoff) {
+				continue
+			}
+			if err := deleteTrash(e); err != nil {
+				fmt.Printf("✗ %s: %v\n", e, err)
+				continue
+			}
+			purged++
+			fmt.Printf("purged %s\n", e)
+		}
+		fmt.Printf("\n%d entries older than %d days purged\n", purged, *days)
+		return 0
+	}
+
+	fmt.Printf("Unknown trash command %q\n", sub)
+	return 1
+}
+
+// listTrash finds trashed repos on the account and trash branches in the
+// repos recorded in state.
+func listTrash() ([]trashEntry, error) {
+	var entries []trashEntry
+
+	repos, err := listUserRepos()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range repos {
+		if !strings.HasPrefix(r.Name, trashRepoPrefix) {
+			continue
+		}
+		owner := strings.SplitN(r.FullName, "/", 2)[0]
+		entries = append(entries, trashEntry{Owner: owner, Repo: r.Name, Time: trashTime(r.Name)})
+	}
+
+	for _, r := range state.Repos {
+		refs, err := listTrashRefs(r.Owner, r.Name)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, refs...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// listTrashRefs lists the trash branches of one repo.
+func listTrashRefs(owner, repoName string) ([]trashEntry, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/matching-refs/heads/%s", githubAPI, owner, repoName, trashBranchPrefix)
+	resp, err := githubRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, responseError("listing trash branches", resp)
+	}
+	var refs []struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&refs); err != nil {
+		return nil, err
+	}
+	var entries []trashEntry
+	for _, ref := range refs {
+		branch := strings.TrimPrefix(ref.Ref, "refs/heads/")
+		source, t := trashRefName(branch)
+		entries = append(entries, trashEntry{Owner: owner, Repo: repoName, Branch: branch, Source: source, Time: t})
+	}
+	return entries, nil
+}
+
+// trashRefName splits a trash branch into the branch it was overwritten
+// from and its time. Names it can't parse restore to main.
+func trashRefName(branch string) (string, time.Time) {
+	name := strings.TrimPrefix(branch, trashBranchPrefix)
+	for _, format := range []string{trashRefTimeFormat, trashTimeFormat} {
+		if len(name) <= len(format) || name[len(name)-len(format)-1] != '-' {
+			continue
+		}
+		if t, err := time.Parse(format, name[len(name)-len(format):]); err == nil {
+			return name[:len(name)-len(format)-1], t
+		}
+	}
+	return "main", time.Time{}
+}
+
+// trashTime parses the timestamp suffix of a trashed repo's name.
+func trashTime(name string) time.Time {
+	if len(name) < len(trashTimeFormat) {
+		return time.Time{}
+	}
+	t, _ := time.Parse(trashTimeFormat, name[len(name)-len(trashTimeFormat):])
+	return t
+}
+
+// restoreTrash points the branch a trash branch was overwritten from back
+// at it (trashing its current commit first), or renames a trashed repo
+// back.
+func restoreTrash(e trashEntry) error {
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, e.Owner, e.Repo)
+
+	if e.Branch == "" {
+		name := strings.TrimPrefix(e.Repo, trashRepoPrefix)
+		// A repo that merely starts with the prefix has no date to strip
+		if len(name) <= len(trashTimeFormat)+1 || name[len(name)-len(trashTimeFormat)-1] != '-' || trashTime(name).IsZero() {
+			return fmt.Errorf("%s doesn't end in a trash date; rename it by hand", e.Repo)
+		}
+		name = name[:len(name)-len(trashTimeFormat)-1]
+		return apiSend("PATCH", repoAPI, map[string]string{"name": name}, nil)
+	}
+
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	resp, err := githubRequest("GET", repoAPI+"/git/ref/heads/"+e.Branch, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return responseError("ref lookup", resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ref); err != nil {
+		return err
+	}
+
+	current, err := apiBranchSHA(repoAPI, e.Source)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return apiPost(repoAPI+"/git/refs", map[string]string{"ref": "refs/heads/" + e.Source, "sha": ref.Object.SHA}, nil)
+	}
+	if err := trashRef(e.Owner, e.Repo, e.Source, current); err != nil {
+		return err
+	}
+	return apiSend("PATCH", repoAPI+"/git/refs/heads/"+e.Source, map[string]interface{}{"sha": ref.Object.SHA, "force": true}, nil)
+}
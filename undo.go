@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// runUndo resets every remote a run pushed to back to its previous commit,
+// and optionally deletes the repos the run created.
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	id := fs.String("run", "last", "Run ID to undo")
+	deleteCreated := fs.Bool("delete-created", false, "Delete repos the run created (needs delete_repo scope)")
+	force := fs.Bool("force", false, "Reset even if a remote moved since the run")
+	dry := fs.Bool("dry-run", false, "Show what would be undone")
+	noTrash := fs.Bool("no-trash", false, "Delete instead of moving to trash")
+	fs.Parse(args)
+	useTrash = !*noTrash
+
+	record, err := findRunRecord(*id)
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Undoing run %s (%s, %d repos)\n\n", record.ID, record.StartedAt.Format("2006-01-02 15:04:05"), len(record.Repos))
+
+	var failed int
+	for _, ref := range record.Repos {
+		action, err := undoRepo(ref, *deleteCreated, *force, *dry)
+		if err != nil {
+			failed++
+			fmt.Printf("✗ %s/%s: %v\n", ref.Owner, ref.Name, redact(err.Error()))
+			continue
+		}
+		fmt.Printf("✓ %s/%s: %s\n", ref.Owner, ref.Name, action)
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d repo(s) could not be undone\n", failed)
+		return 1
+	}
+	return 0
+}
+
+func findRunRecord(id string) (*RunRecord, error) {
+	if id != "last" {
+		return loadRunRecord(id)
+	}
+	records, err := listRunRecords()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no recorded runs in %s", runsDir())
+	}
+	return records[len(records)-1], nil
+}
+
+func undoRepo(ref RunRepoRef, deleteCreated, force, dry bool) (string, error) {
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, ref.Owner, ref.Name)
+
+	if ref.Created && deleteCreated {
+		if dry {
+			return "would delete repo", nil
+		}
+		if useTrash {
+			name, err := trashRepo(ref.Owner, ref.Name)
+			return "moved to trash as " + name, err
+		}
+		return "deleted repo", apiSend("DELETE", repoAPI, nil, nil)
+	}
+	if ref.Created && ref.PrevSHA == "" {
+		// Nothing to go back to, and nothing worth a trash branch
+		return "created by the run; kept (use -delete-created)", nil
+	}
+
+	branch := ref.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	current, err := apiBranchSHA(repoAPI, branch)
+	if err != nil {
+		return "", err
+	}
+	if current != ref.PushedSHA && !force {
+		return "", fmt.Errorf("%s moved since the run (%.7s, expected %.7s); use -force", branch, current, ref.PushedSHA)
+	}
+
+	if !dry {
+		if err := trashRef(ref.Owner, ref.Name, branch, current); err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case ref.PrevSHA != "":
+		if dry {
+			return fmt.Sprintf("would reset %s to %.7s", branch, ref.PrevSHA), nil
+		}
+		err := apiSend("PATCH", repoAPI+"/git/refs/heads/"+branch, map[string]interface{}{"sha": ref.PrevSHA, "force": true}, nil)
+		return fmt.Sprintf("reset %s to %.7s", branch, ref.PrevSHA), err
+	default:
+		// The remote was empty before the run
+		if dry {
+			return "would delete " + branch, nil
+		}
+		return "deleted " + branch, apiSend("DELETE", repoAPI+"/git/refs/heads/"+branch, nil, nil)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Every git command, API call and wait is timed and charged to a stage,
+// both in the run's totals and on the worker whose job it ran for, found
+// through activeJobs. Time in a job that no stage covers, such as scanning
+// and hashing files, is "other"; time outside jobs is "idle". The end of
+// the run prints where the workers' time went, to tell an API-bound run
+// from a disk-bound or network-bound one before tuning flags.
+const (
+	stageInit = iota
+	stageAdd
+	stageCommit
+	stagePush
+	stageGit // Any other git command
+	stageAPI
+	stageWait // Semaphores, rate limits, breaker and outage pauses
+	numStages
+)
+
+var stageNames = [numStages]string{"init", "add", "commit", "push", "git", "api", "wait"}
+
+// stageClock accumulates nanoseconds per stage
+type stageClock struct {
+	busy  int64 // Time spent in jobs
+	stage [numStages]int64
+}
+
+var (
+	stageTotals  stageClock
+	workerClocks []stageClock
+)
+
+func initWorkerClocks(workers int) {
+	workerClocks = make([]stageClock, workers)
+}
+
+func workerClock(worker int) *stageClock {
+	if worker < 0 || worker >= len(workerClocks) {
+		return nil
+	}
+	return &workerClocks[worker]
+}
+
+// recordWorkerStage charges d to a stage of the worker.
+func recordWorkerStage(worker, stage int, d time.Duration) {
+	atomic.AddInt64(&stageTotals.stage[stage], int64(d))
+	if c := workerClock(worker); c != nil {
+		atomic.AddInt64(&c.stage[stage], int64(d))
+	}
+}
+
+// recordStage charges d to a stage of the worker running the job in dir.
+func recordStage(dir string, stage int, d time.Duration) {
+	worker := -1
+	if v, ok := activeJobs.Load(dir); ok {
+		worker = v.(*activeJob).Worker
+	}
+	recordWorkerStage(worker, stage, d)
+}
+
+// recordBusy adds a finished job's time to its worker.
+func recordBusy(worker int, d time.Duration) {
+	atomic.AddInt64(&stageTotals.busy, int64(d))
+	if c := workerClock(worker); c != nil {
+		atomic.AddInt64(&c.busy, int64(d))
+	}
+}
+
+// recordAPI charges an API call to the job working on the repo in its
+// URL. Calls naming no repo, like repo creation, only count in the totals.
+func recordAPI(path string, d time.Duration) {
+	worker := -1
+	if m := repoURLRe.FindStringSubmatch(path); m != nil {
+		activeJobs.Range(func(_, v interface{}) bool {
+			job := v.(*activeJob)
+			if strings.EqualFold(job.Repo, m[1]+"/"+m[2]) {
+				worker = job.Worker
+				return false
+			}
+			return true
+		})
+	}
+	recordWorkerStage(worker, stageAPI, d)
+}
+
+// gitStage classifies a git command by its subcommand, skipping global
+// options like -c name=value.
+func gitStage(args []string) int {
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-c" || a == "-C":
+			i++
+		case strings.HasPrefix(a, "-"):
+		case a == "init":
+			return stageInit
+		case a == "add" || a == "fast-import":
+			return stageAdd
+		case a == "commit":
+			return stageCommit
+		case a == "push":
+			return stagePush
+		default:
+			return stageGit
+		}
+	}
+	return stageGit
+}
+
+// printUtilization shows how the workers' time split across stages.
+func printUtilization(elapsed time.Duration, workers int) {
+	capacity := float64(elapsed) * float64(workers)
+	busy := atomic.LoadInt64(&stageTotals.busy)
+	if capacity <= 0 || busy == 0 {
+		return
+	}
+
+	type row struct {
+		name string
+		ns   int64
+	}
+	var rows []row
+	var staged int64
+	for i, name := range stageNames {
+		ns := atomic.LoadInt64(&stageTotals.stage[i])
+		staged += ns
+		rows = append(rows, row{name, ns})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ns > rows[j].ns })
+	if other := busy - staged; other > 0 {
+		rows = append(rows, row{"other", other})
+	}
+	if idle := int64(capacity) - busy; idle > 0 {
+		rows = append(rows, row{"idle", idle})
+	}
+
+	fmt.Printf(T("\n⏱ Where the workers' time went (%d workers × %s):\n"), workers, formatDuration(elapsed))
+	for _, r := range rows {
+		if r.ns == 0 {
+			continue
+		}
+		share := float64(r.ns) / capacity
+		fmt.Printf("   %-7s %10s %6s%%  %s\n", r.name, formatDuration(time.Duration(r.ns)),
+			formatFloat(100*share, 1), strings.Repeat("█", int(share*40+0.5)))
+	}
+	if hint := bottleneckHint(rows[0].name); hint != "" {
+		fmt.Printf("   → %s\n", T(hint))
+	}
+
+	if verbose {
+		printWorkerUtilization(elapsed)
+	} else if len(workerClocks) > 1 {
+		printWorkerSpread(elapsed)
+	}
+}
+
+// bottleneckHint reads the biggest stage.
+func bottleneckHint(stage string) string {
+	switch stage {
+	case "api":
+		return "API-bound: GitHub calls dominate; more workers mostly add rate limiting"
+	case "push":
+		return "Network-bound: pushes dominate; -max-pushes or a faster link helps more than workers"
+	case "init", "add", "commit", "git":
+		return "Disk-bound: local git work dominates; more workers help until the disk saturates"
+	case "wait":
+		return "Limit-bound: workers mostly wait on -api-concurrency, -max-pushes or pauses"
+	}
+	return ""
+}
+
+// workerBusy is the share of the run a worker spent in jobs.
+func workerBusy(c *stageClock, elapsed time.Duration) float64 {
+	return float64(atomic.LoadInt64(&c.busy)) / float64(elapsed)
+}
+
+// printWorkerSpread summarizes how evenly the workers were used.
+func printWorkerSpread(elapsed time.Duration) {
+	shares := make([]float64, len(workerClocks))
+	for i := range workerClocks {
+		shares[i] = workerBusy(&workerClocks[i], elapsed)
+	}
+	sort.Float64s(shares)
+	fmt.Printf(T("   Workers busy: %s%% min, %s%% median, %s%% max (-v for each worker)\n"),
+		formatFloat(100*shares[0], 0), formatFloat(100*shares[len(shares)/2], 0), formatFloat(100*shares[len(shares)-1], 0))
+}
+
+// printWorkerUtilization prints each worker's busy share and stage split.
+func printWorkerUtilization(elapsed time.Duration) {
+	fmt.Printf("   %-6s %5s", "WORKER", "BUSY")
+	for _, name := range stageNames {
+		fmt.Printf(" %6s", strings.ToUpper(name))
+	}
+	fmt.Println()
+	for i := range workerClocks {
+		c := &workerClocks[i]
+		fmt.Printf("   %-6d %4s%%", i, formatFloat(100*workerBusy(c, elapsed), 0))
+		for s := range stageNames {
+			fmt.Printf(" %5s%%", formatFloat(100*float64(atomic.LoadInt64(&c.stage[s]))/float64(elapsed), 0))
+		}
+		fmt.Println()
+	}
+}
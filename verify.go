@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// repoDrift is the difference between a local directory and its backup
+type repoDrift struct {
+	Repo     *RepoState
+	Added    []string // Local only: not backed up
+	Removed  []string // Remote only: deleted locally since the backup
+	Modified []string
+	Err      error
+}
+
+func (d repoDrift) clean() bool {
+	return d.Err == nil && len(d.Added)+len(d.Removed)+len(d.Modified) == 0
+}
+
+// runVerify compares each pushed repo's remote tree against the local
+// directory it was pushed from.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	workers := fs.Int("w", DefaultWorkers, "Number of parallel checks")
+	var matches stringList
+	fs.Var(&matches, "match", "Only verify repos whose source path matches this glob (repeatable)")
+	label := fs.String("label", "", "Only verify repos recorded with this label")
+	fs.BoolVar(&includeHiddenFiles, "hidden-files", false, "Backups were made with -hidden-files")
+	fs.BoolVar(&verifyWithGit, "git", false, "Read remote trees with blobless shallow clones instead of the API")
+	configFile := fs.String("config", "", "Config file with per-directory paths (default: ~/.gitmax.yml)")
+	fs.Parse(args)
+
+	if err := loadConfig(*configFile); err != nil {
+		fmt.Printf("✗ Could not read config: %v\n", err)
+		return 1
+	}
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		return 1
+	}
+	loadBlobCache()
+
+	repos := selectStateRepos(*label, matches)
+	if len(repos) == 0 {
+		fmt.Println("No pushed repos recorded in state")
+		return 1
+	}
+
+	drifts := make([]repoDrift, len(repos))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, *workers)
+	for i, repo := range repos {
+		wg.Add(1)
+		go func(i int, repo *RepoState) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			drifts[i] = verifyRepo(repo)
+		}(i, repo)
+	}
+	wg.Wait()
+
+	var drifted int
+	for _, d := range drifts {
+		if d.clean() {
+			if verbose {
+				fmt.Printf("✓ %s\n", d.Repo.Path)
+			}
+			continue
+		}
+		drifted++
+		if d.Err != nil {
+			fmt.Printf("✗ %s: %v\n", d.Repo.Path, redact(d.Err.Error()))
+			continue
+		}
+		fmt.Printf("⚠ %s: %d not backed up, %d deleted locally, %d modified\n",
+			d.Repo.Path, len(d.Added), len(d.Removed), len(d.Modified))
+		printDriftList("+", d.Added)
+		printDriftList("-", d.Removed)
+		printDriftList("~", d.Modified)
+	}
+	saveBlobCache()
+
+	fmt.Printf("\nVerified %d repos: %d in sync, %d drifted\n", len(repos), len(repos)-drifted, drifted)
+	if drifted > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printDriftList(mark string, paths []string) {
+	const limit = 10
+	for i, p := range paths {
+		if i == limit && !verbose {
+			fmt.Printf("     … %d more\n", len(paths)-limit)
+			return
+		}
+		fmt.Printf("   %s %s\n", mark, p)
+	}
+}
+
+// selectStateRepos filters state entries by label and source path globs.
+func selectStateRepos(label string, patterns []string) []*RepoState {
+	var repos []*RepoState
+	if label != "" {
+		repos = reposWithLabel(label)
+	} else {
+		for _, r := range state.Repos {
+			repos = append(repos, r)
+		}
+		sort.Slice(repos, func(i, j int) bool { return repos[i].Path < repos[j].Path })
+	}
+	if len(patterns) == 0 {
+		return repos
+	}
+
+	var kept []*RepoState
+	for _, r := range repos {
+		for _, p := range patterns {
+			if matchGlob(p, r.Path) {
+				kept = append(kept, r)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+func verifyRepo(repo *RepoState) repoDrift {
+	drift := repoDrift{Repo: repo}
+
+	var remote map[string]string
+	var err error
+	if !verifyWithGit {
+		remote, err = remoteTree(repo.Owner, repo.Name, repo.branch())
+	}
+	if verifyWithGit || err == errTreeTruncated {
+		remote, err = gitRemoteTree(repo.Owner, repo.Name, repo.branch())
+	}
+	if err == nil {
+		remote, err = originalNames(repo, remote)
+	}
+	if err != nil {
+		drift.Err = err
+		return drift
+	}
+	local, err := localTree(repo.Path)
+	if err != nil {
+		drift.Err = err
+		return drift
+	}
+
+	for p, sha := range local {
+		remoteSHA, ok := remote[p]
+		switch {
+		case !ok:
+			drift.Added = append(drift.Added, p)
+		case remoteSHA != sha:
+			drift.Modified = append(drift.Modified, p)
+		}
+	}
+	for p := range remote {
+		if _, ok := local[p]; !ok {
+			drift.Removed = append(drift.Removed, p)
+		}
+	}
+	sort.Strings(drift.Added)
+	sort.Strings(drift.Removed)
+	sort.Strings(drift.Modified)
+	return drift
+}
+
+// originalNames puts the files a -portable-names push committed under safe
+// names back under the names NamesFile maps them to, as on disk.
+func originalNames(repo *RepoState, remote map[string]string) (map[string]string, error) {
+	if _, ok := remote[NamesFile]; !ok {
+		return remote, nil
+	}
+	data, err := fetchRepoFileAt(repo.Owner, repo.Name, NamesFile, repo.branch())
+	if err != nil {
+		return nil, err
+	}
+	var manifest NamesManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", NamesFile, err)
+	}
+	files := make(map[string]string, len(remote))
+	for p, sha := range remote {
+		if original, ok := manifest.Names[p]; ok {
+			p = original
+		}
+		if p != NamesFile {
+			files[p] = sha
+		}
+	}
+	return files, nil
+}
+
+// remoteTree maps each file path on a remote branch to its blob SHA.
+func remoteTree(owner, repoName, branch string) (map[string]string, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/git/trees/%s?recursive=1", githubAPI, owner, repoName, branch)
+	resp, err := githubRequest("GET", url, nil)
+	if err != nil {
+		return nil, transportError("tree fetch", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, responseError("tree fetch", resp)
+	}
+
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			SHA  string `json:"sha"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tree); err != nil {
+		return nil, err
+	}
+	if tree.Truncated {
+		return nil, errTreeTruncated
+	}
+
+	files := map[string]string{}
+	for _, e := range tree.Tree {
+		if e.Type == "blob" {
+			files[e.Path] = e.SHA
+		}
+	}
+	return files, nil
+}
+
+// verifyWithGit reads every remote tree through git, not only the ones too
+// large for the API listing
+var verifyWithGit bool
+
+var errTreeTruncated = errors.New("remote tree too large for the API listing")
+
+// gitRemoteTree lists a remote branch like remoteTree, through a
+// bare clone of its last commit without file contents: trees come down,
+// blobs don't, so auditing thousands of repos costs about as much as
+// listing them.
+func gitRemoteTree(owner, repoName, branch string) (map[string]string, error) {
+	tmp, err := os.MkdirTemp("", "gitmax-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	url := fmt.Sprintf("https://github.com/%s/%s.git", owner, repoName)
+	if err := runGit(tmp, "clone", "--quiet", "--bare", "--filter=blob:none", "--depth", "1",
+		"--single-branch", "--branch", branch, url, "repo.git"); err != nil {
+		return nil, fmt.Errorf("tree fetch: clone failed: %v", err)
+	}
+	out, err := runGitOutput(filepath.Join(tmp, "repo.git"), "ls-tree", "-r", "-z", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("tree fetch: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, entry := range strings.Split(out, "\x00") {
+		// <mode> SP <type> SP <sha> TAB <path>
+		meta, path, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if ok && len(fields) == 3 && fields[1] == "blob" {
+			files[path] = fields[2]
+		}
+	}
+	return files, nil
+}
+
+// localTree maps each file that would be pushed to its blob SHA. With a
+// .git directory, git decides what's ignored; otherwise every file counts.
+func localTree(dir string) (map[string]string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		listed, err := lsFilesZ(dir, "--cached", "--others", "--exclude-standard")
+		if err != nil {
+			return nil, fmt.Errorf("listing files: %v", err)
+		}
+		paths = listed
+	} else {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, path)
+			paths = append(paths, filepath.ToSlash(rel))
+			return nil
+		})
+	}
+
+	filter := newStageFilter(dir)
+	var local []apiFile
+	for _, p := range paths {
+		if !filter.includes(p) {
+			continue
+		}
+		abs := filepath.Join(dir, filepath.FromSlash(p))
+		info, err := os.Lstat(abs)
+		if err != nil {
+			// Listed by the index but deleted from disk
+			continue
+		}
+		if info.IsDir() {
+			// A gitlink: nested repos and submodules are their own backups
+			continue
+		}
+		mode := "100644"
+		if info.Mode()&os.ModeSymlink != 0 {
+			mode = "120000"
+		}
+		local = append(local, apiFile{Path: p, Abs: abs, Mode: mode, Size: info.Size()})
+	}
+
+	shas, err := hashFiles(local)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	for i, f := range local {
+		files[f.Path] = shas[i]
+	}
+	return files, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set at release time with
+// -ldflags "-X main.version=1.2.3 -X main.commit=abc123 -X main.buildDate=2024-01-01T00:00:00Z"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// VersionInfo is what gitmax version reports
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+	Git       string `json:"git,omitempty"`
+	GH        string `json:"gh,omitempty"`
+	LFS       string `json:"git_lfs,omitempty"`
+}
+
+func versionInfo() VersionInfo {
+	info := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	// go build from a checkout records VCS details even without -ldflags
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+		if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+	}
+
+	info.Git = toolVersion(gitBin, "--version")
+	info.GH = toolVersion("gh", "--version")
+	info.LFS = toolVersion(gitBin, "lfs", "version")
+	return info
+}
+
+// toolVersion returns the first line a tool prints for its version, or ""
+// when the tool isn't installed.
+func toolVersion(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	line := strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]
+	return strings.TrimSpace(line)
+}
+
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	output := fs.String("o", "text", "Output format: text or json")
+	fs.StringVar(&gitBin, "git-bin", gitBin, "git executable to report on")
+	fs.Parse(args)
+
+	info := versionInfo()
+	switch *output {
+	case "json":
+		data, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Println(string(data))
+	case "text":
+		fmt.Printf("gitmax %s\n", info.Version)
+		if info.Commit != "" {
+			fmt.Printf("  Commit:     %s\n", info.Commit)
+		}
+		if info.BuildDate != "" {
+			fmt.Printf("  Built:      %s\n", info.BuildDate)
+		}
+		fmt.Printf("  Go:         %s (%s)\n", info.GoVersion, info.Platform)
+		fmt.Printf("  git:        %s\n", orMissing(info.Git))
+		fmt.Printf("  gh:         %s\n", orMissing(info.GH))
+		fmt.Printf("  git-lfs:    %s\n", orMissing(info.LFS))
+	default:
+		fmt.Printf("Unknown output format %q\n", *output)
+		return 1
+	}
+	return 0
+}
+
+func orMissing(s string) string {
+	if s == "" {
+		return "not found"
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+)
+
+// runVisibility flips gitmax-managed repos between public and private,
+// selected by source path pattern or run label.
+func runVisibility(args []string) int {
+	fs := flag.NewFlagSet("visibility", flag.ExitOnError)
+	opts := addCommonFlags(fs)
+	private := fs.Bool("private", false, "Make matching repos private")
+	public := fs.Bool("public", false, "Make matching repos public")
+	var matches stringList
+	fs.Var(&matches, "match", "Source path glob selecting repos (repeatable)")
+	label := fs.String("label", "", "Select repos recorded with this label")
+	workers := fs.Int("w", 5, "Number of parallel API requests")
+	dry := fs.Bool("dry-run", false, "Show what would change")
+	fs.Parse(args)
+
+	if *private == *public {
+		fmt.Println("Usage: gitmax visibility -private|-public [-match <glob>] [-label <name>]")
+		return 1
+	}
+	if len(matches) == 0 && *label == "" {
+		fmt.Println("✗ refusing to change every repo: give -match or -label")
+		return 1
+	}
+	if err := opts.requireToken(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		return 1
+	}
+	if err := loadState(); err != nil {
+		fmt.Printf("✗ Could not read state: %v\n", err)
+		return 1
+	}
+
+	repos := selectStateRepos(*label, matches)
+	if len(repos) == 0 {
+		fmt.Println("No recorded repos match")
+		return 1
+	}
+
+	target := "public"
+	if *private {
+		target = "private"
+	}
+
+	var mu sync.Mutex
+	var changed, failed int
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, *workers)
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo *RepoState) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			msg, err := setVisibility(repo, *private, *dry)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				fmt.Printf("✗ %s/%s: %v\n", repo.Owner, repo.Name, err)
+				return
+			}
+			if msg != "" {
+				changed++
+				fmt.Printf("✓ %s/%s: %s\n", repo.Owner, repo.Name, msg)
+			}
+		}(repo)
+	}
+	wg.Wait()
+
+	fmt.Printf("\n%d repo(s) made %s, %d failed\n", changed, target, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// setVisibility changes one repo; hand-made repos (no marker topic) are
+// never touched.
+func setVisibility(repo *RepoState, private, dry bool) (string, error) {
+	if managedTopic != "" {
+		managed, err := isManagedRepo(repo.Owner, repo.Name)
+		if err != nil {
+			return "", err
+		}
+		if !managed {
+			return "", fmt.Errorf("not marked with the %q topic; skipped", managedTopic)
+		}
+	}
+
+	target := "public"
+	if private {
+		target = "private"
+	}
+	if dry {
+		return "would become " + target, nil
+	}
+
+	repoAPI := fmt.Sprintf("%s/repos/%s/%s", githubAPI, repo.Owner, repo.Name)
+	if err := apiSend("PATCH", repoAPI, map[string]bool{"private": private}, nil); err != nil {
+		return "", err
+	}
+	return "now " + target, nil
+}
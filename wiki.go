@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With -wiki, a directory's documentation is also pushed to its repo's
+// GitHub wiki (<repo>.wiki.git). The pages come from a sibling folder named
+// after the directory with a .wiki suffix, as left by cloning a wiki next
+// to its repo, or failing that from the directory's docs/ subfolder. Like
+// the code, the wiki is replaced by a fresh snapshot on every run.
+var pushWikis bool
+
+// wikiBranch is the branch GitHub serves wiki pages from
+const wikiBranch = "master"
+
+// wikiSource returns the folder holding dir's wiki pages, or "".
+func wikiSource(dir string) string {
+	for _, candidate := range []string{filepath.Clean(dir) + ".wiki", filepath.Join(dir, "docs")} {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// withoutWikiDirs drops the .wiki siblings of other jobs, and everything
+// below them, since they are pushed as those jobs' wikis.
+func withoutWikiDirs(jobs []DirJob) []DirJob {
+	planned := map[string]bool{}
+	for _, j := range jobs {
+		planned[filepath.Clean(j.Path)] = true
+	}
+	var kept []DirJob
+	for _, j := range jobs {
+		if !insideWikiDir(filepath.Clean(j.Path), planned) {
+			kept = append(kept, j)
+		}
+	}
+	return kept
+}
+
+func insideWikiDir(path string, planned map[string]bool) bool {
+	for p := path; p != filepath.Dir(p); p = filepath.Dir(p) {
+		if strings.HasSuffix(p, ".wiki") && planned[strings.TrimSuffix(p, ".wiki")] {
+			return true
+		}
+	}
+	return false
+}
+
+// pushWiki commits the wiki pages in a git dir of their own inside the
+// job's .git, so docs/ doesn't need a nested repo, and force pushes them.
+func pushWiki(job DirJob, transport string) error {
+	source := wikiSource(job.Path)
+	if source == "" {
+		return nil
+	}
+	gitDir := filepath.Join(job.Path, ".git", "gitmax-wiki")
+	os.RemoveAll(gitDir)
+	if err := runGit(job.Path, "init", "-q", "--bare", gitDir); err != nil {
+		return fmt.Errorf("wiki: git init failed: %v", err)
+	}
+	defer os.RemoveAll(gitDir)
+
+	wiki := []string{"--git-dir=" + gitDir, "--work-tree=" + source}
+	if err := runGit(job.Path, append(wiki, "add", "-A")...); err != nil {
+		return fmt.Errorf("wiki: git add failed: %v", err)
+	}
+	name, email := commitIdentity()
+	identity := []string{"-c", "user.name=" + name, "-c", "user.email=" + email}
+	commit := append(append(identity, wiki...), "commit", "-q", "--allow-empty", "-m", commitMessage(job))
+	if err := runGit(job.Path, commit...); err != nil {
+		return fmt.Errorf("wiki: git commit failed: %v", err)
+	}
+
+	url := originURL(transport, job.owner(), job.RepoName+".wiki")
+	release := acquirePush(job.Path, "github.com")
+	err := runGit(job.Path, "--git-dir="+gitDir, "push", "--force", url, "HEAD:refs/heads/"+wikiBranch)
+	release()
+	if err != nil {
+		// GitHub only creates the wiki repo once its first page exists
+		return fmt.Errorf("wiki push failed (create the first page of the %s wiki on GitHub if it has none): %v", job.RepoName, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A directory whose .git is a file rather than a directory is a checkout
+// of a repository kept elsewhere: a linked worktree, a submodule, or a
+// repo made with --separate-git-dir. Replacing that .git would only drop
+// the pointer, leaving the real repository with a dangling worktree entry
+// and the snapshot without the history it was meant to sit on, so such
+// directories are skipped with the repository to push instead.
+
+// linkedCheckout describes the repository a directory's .git file points
+// to, or returns "" when .git is missing or a directory.
+func linkedCheckout(dir string) string {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Lstat(dotGit)
+	if err != nil || info.IsDir() {
+		return ""
+	}
+	data, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return "its .git is an unreadable file"
+	}
+	line := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+	if !strings.HasPrefix(line, "gitdir:") {
+		return "its .git is a file but not a gitdir pointer"
+	}
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	gitDir = filepath.Clean(gitDir)
+
+	if common, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		main := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(main) {
+			main = filepath.Join(gitDir, main)
+		}
+		main = filepath.Clean(main)
+		if filepath.Base(main) == ".git" {
+			main = filepath.Dir(main)
+		}
+		return fmt.Sprintf("linked worktree of %s; push that directory instead", main)
+	}
+	if i := strings.Index(filepath.ToSlash(gitDir), "/.git/modules/"); i >= 0 {
+		return fmt.Sprintf("submodule checkout of the repo at %s; push that repo instead", gitDir[:i])
+	}
+	return fmt.Sprintf("its repository is kept in %s (separate git dir), which gitmax won't replace", gitDir)
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// readXattrs returns a file's extended attributes. Unreadable ones, like
+// security.* without privileges, are left out.
+func readXattrs(path string) map[string][]byte {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil
+	}
+	attrs := map[string][]byte{}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(path, name, value); err == nil {
+			attrs[name] = value[:n]
+		}
+	}
+	return attrs
+}
+
+func writeXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Extended attributes are only read on Linux.
+func readXattrs(path string) map[string][]byte {
+	return nil
+}
+
+func writeXattr(path, name string, value []byte) error {
+	return errors.New("extended attributes are only restored on Linux")
+}